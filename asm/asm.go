@@ -1,15 +1,14 @@
 package asm
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
 const (
@@ -18,62 +17,207 @@ const (
 	Reg
 	Addr
 	Eof
+	Punct
+	Str
 )
 
 const ErrThreshold = 8
 
+// StartLabel is the entry point of a program without an .entry
+// directive, if defined.
+const StartLabel = "_start"
+
+// Symbol is a token of the source. Line and Col give the position of
+// its first byte, counting from 1.
 type Symbol struct {
 	Type int
 	Val  string
 	Line int
+	Col  int
 }
 
+// Instruction describes an instruction: its opcode and the kinds of
+// its operands in source order, each Reg or Addr. Reads and Writes
+// list, by index, the register operands it reads and writes, and Flow
+// is its effect on control, one of the Flow constants. Rel is set if
+// the last operand is a branch target encoded as an offset from the
+// instruction, and Stack if it reads and writes the stack pointer
+// %sp. SetsFlags and TestsFlags are set if it writes or reads the
+// status flags.
 type Instruction struct {
-	Op     byte
-	Params []int
+	Op         byte
+	Params     []int
+	Reads      []int
+	Writes     []int
+	Flow       int
+	Rel        bool
+	Stack      bool
+	SetsFlags  bool
+	TestsFlags bool
 }
 
 type Reader struct {
 	sym  []Symbol
 	nsym int
+	opts Options
+}
+
+// NumRegs is the number of registers of the standard machine.
+const NumRegs = 8
+
+// ValidRegs reports whether a machine may have n registers. Machines
+// have 8, 16 or 32.
+func ValidRegs(n int) bool {
+	return n == 8 || n == 16 || n == 32
+}
+
+// RegSp is the stack pointer used by push, pop, call, callr and ret, which
+// may also be written %sp.
+const RegSp = 7
+
+// Options control the source language accepted by a Writer.
+type Options struct {
+	Strict   bool // reject identifiers where a register is expected
+	FoldCase bool // accept mnemonics and directives in any case
+	Regs     int  // registers of the target machine, NumRegs if 0
+
+	// IncludePaths are searched in order for files named by .include
+	// that are not found next to the including file.
+	IncludePaths []string
+
+	// VonNeumann places data and bss sections without an .org after
+	// the code rather than at 0, for machines that load the code into
+	// memory (cpu.SetVonNeumann).
+	VonNeumann bool
 }
 
 type Writer struct {
-	buf  bytes.Buffer
+	buf  *bytes.Buffer
 	pc   uint32
+	here uint32
 	lab  map[string]uint32
-	addr map[uint32]string
+	labk map[string]int
+	labl map[string]int
+	equ  map[string]*Expr
+	fix  map[fixup]*Expr
+	pos  Symbol
+	pool []*Expr
+	nlit int
+	refs map[string][]int
 	f    io.Writer
-}
 
-var Hdr = []byte{0x48, 0x59, 0x50, 0x00}
+	text  *section
+	cur   *section
+	sects []*section
+	entry *Expr
+	dbase uint32
+
+	debug bool
+	opt   bool
+	inl   int
+	opts  Options
+	file  string
+	lines []Line
+	src   []source
+}
 
 var syms = map[byte]int{
 	'%': Reg,
 	'$': Addr,
 }
 
-var inst = map[string]Instruction{
-	"nop":  {OpNop, []int{}},
-	"ld":   {OpLd, []int{Reg, Reg}},
-	"lr":   {OpLr, []int{Addr, Reg}},
-	"st":   {OpSt, []int{Reg, Reg}},
-	"add":  {OpAdd, []int{Reg, Reg, Reg}},
-	"sub":  {OpSub, []int{Reg, Reg, Reg}},
-	"addi": {OpAddi, []int{Reg, Addr, Reg}},
-	"subi": {OpSubi, []int{Reg, Addr, Reg}},
-	"p":    {OpP, []int{Reg}},
-	"beq":  {OpBeq, []int{Reg, Reg, Addr}},
-	"bne":  {OpBne, []int{Reg, Reg, Addr}},
-	"bgt":  {OpBgt, []int{Reg, Reg, Addr}},
-	"blt":  {OpBlt, []int{Reg, Reg, Addr}},
-	"j":    {OpJ, []int{Addr}},
-	"jr":   {OpJr, []int{Reg}},
-	"call": {OpCall, []int{Addr}},
-	"exit": {OpExit, []int{}},
-}
-
-var lc int
+const puncts = "=+-(),;"
+
+// Relative reports whether the last operand of the instruction op is
+// a branch target encoded as an offset from the address of the
+// instruction.
+func Relative(op byte) bool {
+	_, f, ok := lookup(op)
+	return ok && f.Rel
+}
+
+// Mnemonic returns the name of the instruction encoded as op, or an
+// empty string if op is not a known opcode.
+func Mnemonic(op byte) string {
+	name, _, _ := lookup(op)
+	return mnemonic(name)
+}
+
+// mnemonic returns the name written for the instruction or operand
+// form key of inst.
+func mnemonic(key string) string {
+	name, _, _ := strings.Cut(key, " ")
+	return name
+}
+
+// Opcode returns the opcode of the instruction name.
+func Opcode(name string) (byte, bool) {
+	f, ok := inst[name]
+	return f.Op, ok
+}
+
+// Size returns the encoded length of the instruction op in bytes,
+// including the opcode itself, or 0 if op is not a known opcode.
+func Size(op byte) int {
+	_, f, ok := lookup(op)
+	if !ok {
+		return 0
+	}
+
+	n := 1
+	for _, t := range f.Params {
+		if t == Addr {
+			n += 4
+		} else {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Operands returns the operand kinds of the instruction op.
+func Operands(op byte) ([]int, bool) {
+	_, f, ok := lookup(op)
+	return f.Params, ok
+}
+
+// lookup returns the name and description of the instruction op.
+func lookup(op byte) (string, Instruction, bool) {
+	for k, v := range inst {
+		if v.Op == op {
+			return k, v, true
+		}
+	}
+
+	return "", Instruction{}, false
+}
+
+// RegisterInstruction adds the instruction name, encoded as op
+// followed by operands of the given kinds, each Reg or Addr. It is
+// meant to be called during initialization, before any assembling.
+func RegisterInstruction(name string, op byte, operands []int) error {
+	if _, ok := inst[name]; ok {
+		return fmt.Errorf("instruction '%s' already defined", name)
+	}
+
+	if _, ok := directives[name]; ok || name == "" || name[0] == '.' {
+		return fmt.Errorf("bad instruction name '%s'", name)
+	}
+
+	if m := Mnemonic(op); m != "" {
+		return fmt.Errorf("opcode %02x already used by '%s'", op, m)
+	}
+
+	for _, j := range operands {
+		if j != Reg && j != Addr {
+			return fmt.Errorf("bad operand kind %d", j)
+		}
+	}
+
+	inst[name] = Instruction{Op: op, Params: append([]int(nil), operands...)}
+	return nil
+}
 
 func NewReader(s []Symbol) *Reader {
 	r := new(Reader)
@@ -84,14 +228,21 @@ func NewReader(s []Symbol) *Reader {
 func NewWriter(w io.Writer) *Writer {
 	r := new(Writer)
 	r.lab = make(map[string]uint32)
-	r.addr = make(map[uint32]string)
+	r.labk = make(map[string]int)
+	r.labl = make(map[string]int)
+	r.equ = make(map[string]*Expr)
+	r.fix = make(map[fixup]*Expr)
+	r.refs = make(map[string][]int)
 	r.f = w
+	r.text = &section{kind: SectText}
+	r.use(r.text)
+	r.opts.Strict = true
 	return r
 }
 
 func (s *Reader) Read() (Symbol, error) {
 	if s.nsym == len(s.sym) {
-		return Symbol{}, errors.New("bad argument count")
+		return Symbol{}, errorf(CodeUnexpected, "bad argument count")
 	}
 
 	sym := s.sym[s.nsym]
@@ -99,6 +250,15 @@ func (s *Reader) Read() (Symbol, error) {
 	return sym, nil
 }
 
+// Peek returns the next symbol without consuming it.
+func (s *Reader) Peek() Symbol {
+	if s.nsym == len(s.sym) {
+		return Symbol{Type: Eof}
+	}
+
+	return s.sym[s.nsym]
+}
+
 func (s *Reader) Expect(t int) (Symbol, error) {
 	sym, err := s.Read()
 
@@ -109,16 +269,20 @@ func (s *Reader) Expect(t int) (Symbol, error) {
 	switch t {
 	case Id:
 		if sym.Type != t && sym.Type != Label {
-			return sym, fmt.Errorf("expected identifier got '%s'", sym.Val)
+			return sym, errorf(CodeUnexpected, "expected identifier got '%s'", sym.Val)
 		}
 	default:
-		if sym.Type != t && sym.Type != Id {
-			tval := "register"
-			if t == Addr {
-				tval = "immediate"
-			}
+		tval := "register"
+		if t == Addr {
+			tval = "immediate"
+		}
 
-			return sym, fmt.Errorf("expected %s got '%s'", tval, sym.Val)
+		if sym.Type == Id && t == Reg && s.opts.Strict {
+			return sym, errorf(CodeBareReg, "expected register got identifier '%s' (missing '%%'?)", sym.Val)
+		}
+
+		if sym.Type != t && sym.Type != Id {
+			return sym, errorf(CodeUnexpected, "expected %s got '%s'", tval, sym.Val)
 		}
 	}
 
@@ -126,7 +290,7 @@ func (s *Reader) Expect(t int) (Symbol, error) {
 }
 
 func (w *Writer) WriteAddr(addr uint32) {
-	binary.Write(&w.buf, binary.LittleEndian, addr)
+	binary.Write(w.buf, binary.LittleEndian, addr)
 	w.pc += 4
 }
 
@@ -134,25 +298,48 @@ func (w *Writer) WriteSymbol(sym Symbol) error {
 	switch sym.Type {
 	case Id:
 		if f, ok := inst[sym.Val]; ok {
+			if w.cur != w.text {
+				return errorf(CodeOutsideText, "instruction outside .text")
+			}
+
+			if w.debug {
+				s := w.where(sym.Line)
+				w.lines = append(w.lines, Line{w.pc, s.file, s.line})
+			}
+
+			w.here = w.pc
 			w.buf.WriteByte(f.Op)
 			w.pc++
 		} else {
-			w.addr[w.pc] = sym.Val
-			w.WriteAddr(0)
+			w.pos = sym
+			return w.WriteExpr(&Expr{Name: sym.Val})
 		}
 	case Label:
-		_, ok := w.lab[sym.Val]
-
-		if ok {
-			return fmt.Errorf("redefining label '%s'", sym.Val)
+		if w.defined(sym.Val) {
+			return w.redefined(sym.Val)
 		}
 
 		w.lab[sym.Val] = w.pc
+		w.labk[sym.Val] = w.cur.kind
+		w.labl[sym.Val] = sym.Line
 	case Reg:
+		if sym.Val == "sp" {
+			sym.Val = strconv.Itoa(RegSp)
+		}
+
 		r, err := strconv.Atoi(sym.Val)
 
 		if err != nil {
-			return fmt.Errorf("bad register '%s'", sym.Val)
+			return errorf(CodeBadReg, "bad register '%s'", sym.Val)
+		}
+
+		n := w.opts.Regs
+		if n == 0 {
+			n = NumRegs
+		}
+
+		if r < 0 || r >= n || r > 0xff {
+			return errorf(CodeRegRange, "register %%%s out of range (%d registers)", sym.Val, n)
 		}
 
 		w.buf.WriteByte(byte(r))
@@ -161,7 +348,7 @@ func (w *Writer) WriteSymbol(sym Symbol) error {
 		addr, err := strconv.ParseInt(sym.Val, 16, 32)
 
 		if err != nil {
-			return fmt.Errorf("bad address '%s'", sym.Val)
+			return errorf(CodeBadNumber, "bad address '%s'", sym.Val)
 		}
 
 		w.WriteAddr(uint32(addr))
@@ -170,187 +357,445 @@ func (w *Writer) WriteSymbol(sym Symbol) error {
 	return nil
 }
 
-func (w *Writer) Write() (int, error) {
-	b := w.buf.Bytes()
+// WriteExpr writes the value of e as an address. Expressions that
+// refer to labels are resolved by Write.
+func (w *Writer) WriteExpr(e *Expr) error {
+	return w.writeValue(e, 4)
+}
 
-	for i, j := range w.addr {
-		l, ok := w.lab[j]
+// writeValue writes the low n bytes of e.
+func (w *Writer) writeValue(e *Expr, n int) error {
+	e = e.at(w.here)
+
+	var i uint32
+	if !e.Const() {
+		w.fix[fixup{w.cur, w.buf.Len(), n, w.pos}] = e
+	} else if v, err := e.eval(nil); err != nil {
+		return err
+	} else if err := fits(v, n); err != nil {
+		return err
+	} else {
+		i = v
+	}
 
-		if !ok {
-			return -1, fmt.Errorf("%s: no such label", j)
-		}
+	for j := 0; j < n; j++ {
+		w.buf.WriteByte(byte(i >> (8 * j)))
+	}
+
+	w.pc += uint32(n)
+	return nil
+}
 
-		b[i] = byte(l)
-		b[i+1] = byte(l >> 8)
-		b[i+2] = byte(l >> 16)
-		b[i+3] = byte(l >> 24)
+// fits checks that v, taken as signed or unsigned, is unchanged when
+// cut down to n bytes.
+func fits(v uint32, n int) error {
+	if n >= 4 {
+		return nil
 	}
 
-	if n, err := w.f.Write(Hdr); err != nil {
-		return n, err
+	b := uint(8 * n)
+	if v < 1<<b || int32(v) < 0 && int32(v) >= -1<<(b-1) {
+		return nil
 	}
 
-	return w.f.Write(b)
+	return errorf(CodeTruncated, "value %#x does not fit in %d bytes", v, n)
 }
 
-func ReadToken(r *bufio.Reader) (string, error) {
-	b := new(bytes.Buffer)
+// Define binds name to the value of e, as in 'name = e'.
+func (w *Writer) Define(name string, e *Expr) error {
+	if w.defined(name) {
+		return w.redefined(name)
+	}
 
-	for {
-		c, err := r.ReadByte()
+	w.equ[name] = e.at(w.pc)
+	return nil
+}
 
-		if err != nil {
-			return "", err
-		}
+// SetDebug makes Write include a line table, naming file as the
+// source of every instruction, and a symbol table.
+func (w *Writer) SetDebug(file string) {
+	w.debug = true
+	w.file = file
+}
 
-		if c == '\n' {
-			lc++
-		}
+// SetFile names the file being assembled, which is where .include
+// looks first and which diagnostics leave unnamed.
+func (w *Writer) SetFile(file string) {
+	w.file = file
+}
+
+// SetStrict controls whether a bare identifier is rejected where a
+// register is expected. Strict checking is on by default; without it
+// the identifier is encoded as an immediate.
+func (w *Writer) SetStrict(on bool) {
+	w.opts.Strict = on
+}
+
+// SetOptions replaces the source language options of w. The default
+// is Options{Strict: true}.
+func (w *Writer) SetOptions(o Options) {
+	w.opts = o
+}
+
+// SetOptimize enables the peephole optimizer.
+func (w *Writer) SetOptimize(on bool) {
+	w.opt = on
+}
+
+// SetInline makes the assembler inline calls to leaf routines of up
+// to budget bytes, as done by Inline. 0 disables inlining.
+func (w *Writer) SetInline(budget int) {
+	w.inl = budget
+}
+
+// Labels returns the address of every label defined so far.
+func (w *Writer) Labels() map[string]uint32 {
+	l := make(map[string]uint32, len(w.lab))
+	for k, v := range w.lab {
+		l[k] = v
+	}
+
+	return l
+}
+
+// Objects returns every label as an Object of the section it is in,
+// sorted by address and then name.
+func (w *Writer) Objects() []Object {
+	var r []Object
+	for k, v := range w.lab {
+		r = append(r, Object{k, w.labk[k], v})
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		a, b := r[i], r[j]
+		return a.Addr < b.Addr || a.Addr == b.Addr && a.Name < b.Name
+	})
+
+	return r
+}
+
+func (w *Writer) defined(name string) bool {
+	_, ok := w.lab[name]
+	_, eok := w.equ[name]
+	return ok || eok
+}
 
-		if unicode.IsSpace(rune(c)) {
-			break
+// redefined returns the error for defining name again, pointing to
+// the first definition if its line is known.
+func (w *Writer) redefined(name string) error {
+	if l := w.labl[name]; l > 0 {
+		s := w.where(l)
+		if s.file != w.file {
+			return errorf(CodeRedefined, "redefining label '%s' (first defined at %s:%d)", name, s.file, s.line)
 		}
 
-		b.WriteByte(c)
+		return errorf(CodeRedefined, "redefining label '%s' (first defined on line %d)", name, s.line)
+	}
+
+	return errorf(CodeRedefined, "redefining label '%s'", name)
+}
+
+// Value returns the value of the label or constant name. It is
+// only meaningful once every label has been seen.
+func (w *Writer) Value(name string) (uint32, error) {
+	return w.value(name, 0)
+}
+
+func (w *Writer) value(name string, depth int) (uint32, error) {
+	if l, ok := w.lab[name]; ok {
+		return l, nil
+	}
+
+	e, ok := w.equ[name]
+	if !ok {
+		return 0, errorf(CodeUndefined, "%s: no such label", name)
+	}
+
+	if depth > len(w.equ) {
+		return 0, errorf(CodeCircular, "%s: circular definition", name)
 	}
 
-	return b.String(), nil
+	return e.eval(func(s string) (uint32, error) {
+		return w.value(s, depth+1)
+	})
 }
 
-func Read(r *bufio.Reader) (sym Symbol, err error) {
-	sym.Type = -1
+// fixups returns the pending fixups in source order.
+func (w *Writer) fixups() []fixup {
+	var r []fixup
+	for k := range w.fix {
+		r = append(r, k)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		a, b := r[i].pos, r[j].pos
+		if a.Line != b.Line || a.Col != b.Col {
+			return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+		}
 
-	for {
-		c, err := r.ReadByte()
+		return r[i].off < r[j].off
+	})
+
+	return r
+}
+
+func (w *Writer) Write() (int, error) {
+	for _, i := range w.fixups() {
+		l, err := w.fix[i].eval(w.Value)
+
+		if err == nil {
+			err = fits(l, i.n)
+		}
 
 		if err != nil {
-			sym.Type = Eof
-			break
+			return -1, err
 		}
 
-		switch c {
-		case '\n':
-			lc++
-		case '#':
-			r.ReadBytes('\n')
-			lc++
-			return sym, nil
+		b := i.s.buf.Bytes()[i.off:]
+		for j := 0; j < i.n; j++ {
+			b[j] = byte(l >> (8 * j))
 		}
+	}
+
+	m := Image{Code: w.text.buf.Bytes()}
+	if w.debug {
+		m.Lines = w.lines
+		m.Objects = w.Objects()
+	}
 
-		if unicode.IsSpace(rune(c)) {
-			continue
+	if w.entry == nil && w.defined(StartLabel) {
+		w.entry = &Expr{Name: StartLabel}
+	}
+
+	if w.entry != nil {
+		l, err := w.entry.eval(w.Value)
+		if err != nil {
+			return -1, err
 		}
 
-		if !unicode.IsGraphic(rune(c)) {
-			return sym, fmt.Errorf("invalid character '%02x'", c)
+		if l >= uint32(len(m.Code)) {
+			return -1, errorf(CodeEntry, "entry point %08x outside .text", l)
 		}
 
-		if t, ok := syms[c]; ok {
-			s, err := ReadToken(r)
+		m.Entry = l
+	}
 
-			if err != nil {
-				sym.Type = Eof
-			} else {
-				sym = Symbol{t, s, lc}
-			}
+	sects, err := w.sections()
+	if err != nil {
+		return -1, err
+	}
+
+	m.Sections = sects
+
+	return w.f.Write(m.Bytes())
+}
+
+// Diagnostic is an error or warning found while assembling. Line is
+// 0 for errors that do not belong to a single line, and Col 0 if the
+// column is not known. Code is one of the Code constants, or empty
+// for problems without one. Text is the source line, if available.
+// File names the included file holding the line, and is empty for
+// the file being assembled.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Col     int
+	Code    string
+	Msg     string
+	Warning bool
+	Text    string
+}
+
+func (d Diagnostic) String() string {
+	msg := d.Msg
+	if d.Code != "" {
+		msg = d.Code + ": " + msg
+	}
+
+	if d.Warning {
+		msg = "warning " + msg
+	}
+
+	file := ""
+	if d.File != "" {
+		file = d.File + ":"
+	}
+
+	switch {
+	case d.Line == 0:
+		return file + msg
+	case d.Col == 0:
+		return fmt.Sprintf("%s%d: %s", file, d.Line, msg)
+	}
+
+	return fmt.Sprintf("%s%d:%d: %s", file, d.Line, d.Col, msg)
+}
+
+// Snippet returns the source line of d with a caret under the column,
+// or an empty string if either is unknown.
+func (d Diagnostic) Snippet() string {
+	if d.Text == "" || d.Col < 1 || d.Col > len(d.Text)+1 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(d.Text + "\n")
 
-			break
+	for _, j := range d.Text[:d.Col-1] {
+		if j == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
 		}
+	}
 
-		if sym.Type == -1 && unicode.IsLetter(rune(c)) {
-			r.UnreadByte()
-			s, err := ReadToken(r)
-
-			if err != nil {
-				sym.Type = Eof
-			} else {
-				if s[len(s)-1] == ':' {
-					sym = Symbol{Label, strings.TrimSuffix(s, ":"), lc}
-					lc++
-				} else {
-					sym = Symbol{Id, s, lc}
-				}
-			}
+	b.WriteByte('^')
+	return b.String()
+}
 
-			break
+// Assemble assembles src in memory, returning the image and every
+// diagnostic found. The image is nil if there were errors.
+func Assemble(src []byte) ([]byte, []Diagnostic) {
+	var b bytes.Buffer
+	var d []Diagnostic
+
+	_, err := NewWriter(&b).gen(bytes.NewReader(src), func(x Diagnostic) {
+		d = append(d, x)
+	})
+
+	if err != nil {
+		if len(d) == 0 || d[len(d)-1].Warning {
+			d = append(d, Diagnostic{Code: Code(err), Msg: err.Error()})
 		}
+
+		return nil, d
 	}
 
-	return sym, nil
+	return b.Bytes(), d
 }
 
 // Gen takes the code from r and writes a machine code representation
 // to w. Any errors are outputted to e.
 func Gen(r io.Reader, w io.Writer, e io.Writer) (sym []Symbol, err error) {
-	b := bufio.NewReader(r)
-	errc := 0
+	return NewWriter(w).Gen(r, e)
+}
 
-	werr := func(s Symbol, err error) {
-		if errc <= ErrThreshold {
-			fmt.Fprintf(e, "%d: %s\n", s.Line, err)
-		}
-		errc++
-	}
+// Gen is like the package level Gen, writing to w. The labels of
+// the program remain available from w afterwards.
+func (writer *Writer) Gen(r io.Reader, e io.Writer) (sym []Symbol, err error) {
+	n := 0
 
-	for {
-		s, err := Read(b)
+	sym, err = writer.gen(r, func(d Diagnostic) {
+		if d.Warning || n <= ErrThreshold {
+			fmt.Fprintln(e, d)
 
-		if err != nil {
-			werr(s, err)
+			if s := d.Snippet(); s != "" {
+				fmt.Fprintln(e, s)
+			}
 		}
 
-		if errc > ErrThreshold {
-			return sym, errors.New("invalid file")
+		if !d.Warning {
+			n++
 		}
+	})
 
-		if s.Type != -1 {
-			sym = append(sym, s)
-		}
+	if c := Code(err); c != "" {
+		err = fmt.Errorf("%s: %w", c, err)
+	}
 
-		if s.Type == Eof {
-			break
-		}
+	return sym, err
+}
+
+// gen assembles r, passing each error to report.
+func (writer *Writer) gen(r io.Reader, report func(Diagnostic)) (sym []Symbol, err error) {
+	if n := writer.opts.Regs; n != 0 && !ValidRegs(n) {
+		return nil, fmt.Errorf("machines have 8, 16 or 32 registers, not %d", n)
 	}
 
-	reader := NewReader(sym)
-	writer := NewWriter(w)
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		s, err := reader.Expect(Id)
+	var inc []Diagnostic
+	var b bytes.Buffer
 
-		if s.Type == Eof {
-			break
-		}
+	writer.src = nil
+	writer.include(&b, src, writer.file, []string{writer.file}, func(err error) {
+		inc = append(inc, Diagnostic{Line: len(writer.src), Code: Code(err), Msg: err.Error()})
+	})
 
-		if err != nil {
-			werr(s, err)
-			continue
-		}
+	src = writer.expand(b.Bytes(), func(err error) {
+		inc = append(inc, Diagnostic{Line: len(writer.src), Code: Code(err), Msg: err.Error()})
+	})
+
+	report = writer.locate(report)
+	errc := len(inc)
+
+	for _, j := range inc {
+		report(j)
+	}
 
-		if s.Type != Label {
-			f, ok := inst[s.Val]
+	werr := func(s Symbol, err error) {
+		report(Diagnostic{Line: s.Line, Col: s.Col, Code: Code(err), Msg: err.Error()})
+		errc++
+	}
+
+	sym = lex(NewLexer(bytes.NewReader(src)), werr)
+	if errc > ErrThreshold {
+		return sym, errors.New("invalid file")
+	}
+
+	rd := NewReader(sym)
+	rd.opts = writer.opts
+	prog := parse(rd, werr)
+
+	if writer.inl > 0 {
+		prog = Inline(prog, writer.inl)
+	}
 
-			if !ok {
-				werr(s, fmt.Errorf("bad instruction '%s'", s.Val))
-				continue
+	if writer.opt {
+		prog = Optimize(prog)
+	} else {
+		dead := unreachable(prog)
+		for i, j := range prog {
+			if dead[i] && (i == 0 || !dead[i-1]) {
+				report(Diagnostic{Line: j.Line, Col: j.Col, Code: CodeUnreachable, Msg: "unreachable code", Warning: true})
 			}
+		}
+	}
+
+	writer.reference(prog)
 
-			writer.WriteSymbol(s)
-			for _, t := range f.Params {
-				s, err = reader.Expect(t)
+	if writer.opts.VonNeumann {
+		writer.dbase = writer.codeSize(prog)
+	}
+
+	for _, j := range prog {
+		if err := writer.WriteStmt(j); err != nil {
+			pos := Symbol{Line: j.Line, Col: j.Col}
 
-				if err != nil {
-					werr(s, err)
-				} else if err = writer.WriteSymbol(s); err != nil {
-					werr(s, err)
-				}
+			var oe *operandError
+			if errors.As(err, &oe) {
+				pos = oe.Sym
 			}
 
-			continue
+			werr(pos, err)
+		}
+	}
+
+	if err := writer.endPool(); err != nil {
+		werr(Symbol{}, err)
+	}
+
+	for _, j := range writer.fixups() {
+		l, err := writer.fix[j].eval(writer.Value)
+		if err == nil {
+			err = fits(l, j.n)
 		}
 
-		if err = writer.WriteSymbol(s); err != nil {
-			werr(s, err)
+		if err != nil {
+			werr(j.pos, err)
 		}
 	}
 
@@ -361,7 +806,7 @@ func Gen(r io.Reader, w io.Writer, e io.Writer) (sym []Symbol, err error) {
 	}
 
 	if _, err := writer.Write(); err != nil {
-		fmt.Fprintf(e, "%s\n", err)
+		return sym, err
 	}
 
 	return sym, nil
@@ -0,0 +1,64 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Diagnostic codes. A code names a kind of problem and never changes
+// meaning, so tools can match on it while messages are reworded.
+// Codes starting with E are errors, those with W warnings.
+const (
+	CodeUndefined    = "E0001" // reference to a label that is not defined
+	CodeRedefined    = "E0002" // label or constant defined twice
+	CodeBadInst      = "E0003" // unknown instruction or directive
+	CodeUnexpected   = "E0004" // wrong or missing token
+	CodeBareReg      = "E0005" // identifier where a register is expected
+	CodeBadReg       = "E0006" // malformed register
+	CodeRegRange     = "E0007" // register beyond the machine's registers
+	CodeBadNumber    = "E0008" // malformed or out of range number
+	CodeBadChar      = "E0009" // character that starts no token
+	CodeUnterminated = "E0010" // /* comment without */ or string without closing quote
+	CodeOutsideText  = "E0011" // instruction in a data or bss section
+	CodeCircular     = "E0012" // constant defined in terms of itself
+	CodeEntry        = "E0013" // bad or repeated entry point
+	CodeOrg          = "E0014" // .org moving backwards in .text
+	CodeBssData      = "E0015" // initialized data in .bss
+	CodeOverlap      = "E0016" // overlapping data or bss sections
+	CodeTruncated    = "E0017" // value too wide for its field
+	CodePool         = "E0018" // literal pool outside .data
+	CodeInclude      = "E0019" // missing or too deeply nested include
+	CodeTooBig       = "E0020" // code or section past the end of its address space
+	CodeMacro        = "E0021" // bad macro definition or use, or no free scratch register
+	CodeUnreachable  = "W0001" // code that can never execute
+)
+
+// codeError is an error with a diagnostic code.
+type codeError struct {
+	code string
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codeError) Unwrap() error {
+	return e.err
+}
+
+// errorf formats an error carrying code.
+func errorf(code, format string, args ...any) error {
+	return &codeError{code, fmt.Errorf(format, args...)}
+}
+
+// Code returns the diagnostic code of err, or an empty string if it
+// has none.
+func Code(err error) string {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	return ""
+}
@@ -0,0 +1,62 @@
+package asm
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TestDiagnostics")
+
+// TestDiagnostics assembles each testdata/diag/*.s and compares what
+// Gen reports, diagnostics and final error, with the .golden file next
+// to it. Run with -update to rewrite the golden files.
+func TestDiagnostics(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "diag", "*.s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range files {
+		j := j
+		t.Run(strings.TrimSuffix(filepath.Base(j), ".s"), func(t *testing.T) {
+			src, err := os.ReadFile(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got bytes.Buffer
+
+			w := NewWriter(io.Discard)
+			w.SetStrict(true)
+			w.SetFile(j)
+
+			if _, err := w.Gen(bytes.NewReader(src), &got); err != nil {
+				fmt.Fprintf(&got, "error: %s\n", err)
+			}
+
+			golden := strings.TrimSuffix(j, ".s") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.String() != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+			}
+		})
+	}
+}
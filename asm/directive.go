@@ -0,0 +1,125 @@
+package asm
+
+// directive is an assembler directive taking args expressions, or a
+// comma separated list of at least one if args is -1.
+type directive struct {
+	args int
+	do   func(w *Writer, e []*Expr) error
+}
+
+var directives = map[string]directive{
+	".text": {0, func(w *Writer, e []*Expr) error {
+		w.enter(SectText)
+		return nil
+	}},
+	".data": {0, func(w *Writer, e []*Expr) error {
+		w.enter(SectData)
+		return nil
+	}},
+	".bss": {0, func(w *Writer, e []*Expr) error {
+		w.enter(SectBss)
+		return nil
+	}},
+	".org": {1, func(w *Writer, e []*Expr) error {
+		addr, err := w.constExpr(e[0])
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case addr == w.pc:
+		case w.cur == w.text:
+			if addr < w.pc {
+				return errorf(CodeOrg, ".org %08x is behind %08x", addr, w.pc)
+			}
+
+			if err := w.grow(addr - w.pc); err != nil {
+				return err
+			}
+
+			for w.pc < addr {
+				w.buf.WriteByte(OpNop)
+				w.pc++
+			}
+		default:
+			w.org(w.cur.kind, addr)
+		}
+
+		return nil
+	}},
+	".entry": {1, func(w *Writer, e []*Expr) error {
+		if w.entry != nil {
+			return errorf(CodeEntry, "entry point already set")
+		}
+
+		w.entry = e[0].at(w.here)
+		return nil
+	}},
+	".word": {-1, func(w *Writer, e []*Expr) error {
+		return w.data(e, 4)
+	}},
+	".byte": {-1, func(w *Writer, e []*Expr) error {
+		return w.data(e, 1)
+	}},
+	".pool": {0, func(w *Writer, e []*Expr) error {
+		return w.flushPool()
+	}},
+	".space": {1, func(w *Writer, e []*Expr) error {
+		n, err := w.constExpr(e[0])
+		if err != nil {
+			return err
+		}
+
+		if err := w.grow(n); err != nil {
+			return err
+		}
+
+		w.buf.Write(make([]byte, n))
+		w.pc += n
+		return nil
+	}},
+}
+
+// exprs reads the operands of a directive taking n expressions.
+func (s *Reader) exprs(n int) ([]*Expr, error) {
+	var r []*Expr
+
+	for i := 0; i < n || n < 0; i++ {
+		if i > 0 && n < 0 {
+			if p := s.Peek(); p.Type != Punct || p.Val != "," {
+				break
+			}
+
+			s.Read()
+		}
+
+		e, err := s.Expr()
+		if err != nil {
+			return nil, err
+		}
+
+		r = append(r, e)
+	}
+
+	return r, nil
+}
+
+// constExpr evaluates e, which must be computable now.
+func (w *Writer) constExpr(e *Expr) (uint32, error) {
+	return e.at(w.here).eval(w.Value)
+}
+
+// data writes each expression as an n byte value.
+func (w *Writer) data(e []*Expr, n int) error {
+	if w.cur.kind == SectBss {
+		return errorf(CodeBssData, "data in .bss")
+	}
+
+	for _, j := range e {
+		if err := w.writeValue(j, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
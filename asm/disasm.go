@@ -0,0 +1,210 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Decoded is a single decoded instruction. Args holds the operand
+// values in source order and Kinds their types (Reg or Addr).
+type Decoded struct {
+	Addr  uint32
+	Op    byte
+	Name  string
+	Args  []uint32
+	Kinds []int
+	Size  int
+}
+
+// Decode decodes the instruction at the start of code, which is
+// located at addr.
+func Decode(code []byte, addr uint32) (Decoded, error) {
+	if len(code) == 0 {
+		return Decoded{}, fmt.Errorf("%08x: end of code", addr)
+	}
+
+	d := Decoded{Addr: addr, Op: code[0], Size: 1}
+
+	var f Instruction
+	for k, v := range inst {
+		if v.Op == d.Op {
+			d.Name = mnemonic(k)
+			f = v
+			break
+		}
+	}
+
+	if d.Name == "" {
+		return d, fmt.Errorf("%08x: invalid opcode %02x", addr, d.Op)
+	}
+
+	for _, t := range f.Params {
+		switch t {
+		case Addr:
+			if len(code) < d.Size+4 {
+				return d, fmt.Errorf("%08x: truncated instruction", addr)
+			}
+
+			d.Args = append(d.Args, binary.LittleEndian.Uint32(code[d.Size:]))
+			d.Size += 4
+		default:
+			if len(code) < d.Size+1 {
+				return d, fmt.Errorf("%08x: truncated instruction", addr)
+			}
+
+			d.Args = append(d.Args, uint32(code[d.Size]))
+			d.Size++
+		}
+
+		d.Kinds = append(d.Kinds, t)
+	}
+
+	return d, nil
+}
+
+// Disasm decodes code, located at addr, from start to end. Bytes that
+// do not decode are returned as single byte instructions with an
+// empty Name.
+func Disasm(code []byte, addr uint32) []Decoded {
+	var r []Decoded
+
+	for i := 0; i < len(code); {
+		d, err := Decode(code[i:], addr+uint32(i))
+		if err != nil {
+			d = Decoded{Addr: addr + uint32(i), Op: code[i], Size: 1}
+		}
+
+		r = append(r, d)
+		i += d.Size
+	}
+
+	return r
+}
+
+// String returns d in assembler syntax.
+func (d Decoded) String() string {
+	if d.Name == "" {
+		return fmt.Sprintf(".byte $%02x", d.Op)
+	}
+
+	s := []string{d.Name}
+	for i, j := range d.Args {
+		if d.Kinds[i] == Reg {
+			s = append(s, fmt.Sprintf("%%%d", j))
+		} else if Relative(d.Op) && i == len(d.Args)-1 {
+			s = append(s, fmt.Sprintf("$%x", d.Addr+j))
+		} else {
+			s = append(s, fmt.Sprintf("$%x", j))
+		}
+	}
+
+	return strings.Join(s, " ")
+}
+
+// Reg returns operand i if it is a register.
+func (d Decoded) Reg(i int) (byte, bool) {
+	if i < 0 || i >= len(d.Args) || d.Kinds[i] != Reg {
+		return 0, false
+	}
+
+	return byte(d.Args[i]), true
+}
+
+// Imm returns operand i if it is an immediate.
+func (d Decoded) Imm(i int) (uint32, bool) {
+	if i < 0 || i >= len(d.Args) || d.Kinds[i] != Addr {
+		return 0, false
+	}
+
+	return d.Args[i], true
+}
+
+// IsBranch reports whether d may transfer control somewhere other
+// than the next instruction: the conditional branches, j, jr and
+// call, and their relative forms. exit is not a branch.
+func (d Decoded) IsBranch() bool {
+	switch d.info().Flow {
+	case FlowBranch, FlowJump, FlowCall:
+		return true
+	}
+
+	return false
+}
+
+// IsCall reports whether d is call or callr.
+func (d Decoded) IsCall() bool {
+	return d.info().Flow == FlowCall
+}
+
+// BranchTarget returns the address d branches to, if it is known
+// without running the program. Relative targets are resolved against
+// d.Addr; jr has none.
+func (d Decoded) BranchTarget() (uint32, bool) {
+	if !d.IsBranch() {
+		return 0, false
+	}
+
+	t, ok := d.Imm(len(d.Args) - 1)
+	if d.info().Rel {
+		t += d.Addr
+	}
+
+	return t, ok
+}
+
+// FallsThrough reports whether execution may continue with the
+// instruction following d.
+func (d Decoded) FallsThrough() bool {
+	switch d.info().Flow {
+	case FlowJump, FlowStop:
+		return false
+	}
+
+	return true
+}
+
+// Reads returns the registers d reads, including %sp for the stack
+// instructions. Hypercalls and instructions added with
+// RegisterInstruction may read others.
+func (d Decoded) Reads() []byte {
+	f := d.info()
+	return d.regs(f.Reads, f.Stack)
+}
+
+// Writes returns the registers d writes, including %sp for the stack
+// instructions. Hypercalls and instructions added with
+// RegisterInstruction may write others.
+func (d Decoded) Writes() []byte {
+	f := d.info()
+	return d.regs(f.Writes, f.Stack)
+}
+
+// info returns the description of the instruction d, which is empty
+// if d did not decode.
+func (d Decoded) info() Instruction {
+	if d.Name == "" {
+		return Instruction{}
+	}
+
+	_, f, _ := lookup(d.Op)
+	return f
+}
+
+// regs returns the register operands of d with the given indices,
+// and %sp if sp is set.
+func (d Decoded) regs(idx []int, sp bool) []byte {
+	var r []byte
+
+	for _, i := range idx {
+		if n, ok := d.Reg(i); ok {
+			r = append(r, n)
+		}
+	}
+
+	if sp {
+		r = append(r, RegSp)
+	}
+
+	return r
+}
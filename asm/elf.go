@@ -0,0 +1,201 @@
+package asm
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// EM_HYPO is the ELF machine type of hypo binaries. It is not
+// registered and spells "HY".
+const EM_HYPO = 0x4859
+
+// WriteELF writes m as an ELF32 executable with a PT_LOAD segment and
+// a section for the code at address 0 and for each data and bss
+// section at its address in memory. Each object of m becomes a symbol
+// in .symtab, in the section holding it, and the entry point is that
+// of m.
+func WriteELF(w io.Writer, m *Image) error {
+	const (
+		ehsize = 52
+		phsize = 32
+		shsize = 40
+		symsz  = 16
+	)
+
+	// Section headers are the null section, .text, one for each of
+	// m.Sections, then .symtab, .strtab and .shstrtab.
+	symndx := uint32(2 + len(m.Sections))
+
+	var shstrtab bytes.Buffer
+	shname := make(map[string]uint32)
+	for _, j := range []string{"", ".text", ".data", ".bss", ".symtab", ".strtab", ".shstrtab"} {
+		shname[j] = uint32(shstrtab.Len())
+		shstrtab.WriteString(j)
+		shstrtab.WriteByte(0)
+	}
+
+	objs := append([]Object(nil), m.Objects...)
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].Name < objs[j].Name
+	})
+
+	var strtab, symtab bytes.Buffer
+	strtab.WriteByte(0)
+	binary.Write(&symtab, binary.LittleEndian, elf.Sym32{})
+
+	for _, j := range objs {
+		binary.Write(&symtab, binary.LittleEndian, elf.Sym32{
+			Name:  uint32(strtab.Len()),
+			Value: j.Addr,
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_NOTYPE),
+			Shndx: elfSection(m, j),
+		})
+		strtab.WriteString(j.Name)
+		strtab.WriteByte(0)
+	}
+
+	phnum := 1 + len(m.Sections)
+	off := uint32(ehsize + phsize*phnum)
+
+	sh := []elf.Section32{{}}
+	ph := make([]elf.Prog32, 0, phnum)
+
+	text := elf.Section32{
+		Name:      shname[".text"],
+		Type:      uint32(elf.SHT_PROGBITS),
+		Flags:     uint32(elf.SHF_ALLOC | elf.SHF_EXECINSTR),
+		Off:       off,
+		Size:      uint32(len(m.Code)),
+		Addralign: 1,
+	}
+
+	sh = append(sh, text)
+	ph = append(ph, elf.Prog32{
+		Type:   uint32(elf.PT_LOAD),
+		Off:    off,
+		Filesz: text.Size,
+		Memsz:  text.Size,
+		Flags:  uint32(elf.PF_R | elf.PF_X),
+		Align:  1,
+	})
+
+	off += text.Size
+
+	for _, j := range m.Sections {
+		s := elf.Section32{
+			Name:      shname[sectNames[j.Kind]],
+			Type:      uint32(elf.SHT_PROGBITS),
+			Flags:     uint32(elf.SHF_ALLOC | elf.SHF_WRITE),
+			Addr:      j.Addr,
+			Off:       off,
+			Size:      j.Size,
+			Addralign: 1,
+		}
+
+		p := elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    off,
+			Vaddr:  j.Addr,
+			Paddr:  j.Addr,
+			Filesz: uint32(len(j.Data)),
+			Memsz:  j.Size,
+			Flags:  uint32(elf.PF_R | elf.PF_W),
+			Align:  1,
+		}
+
+		if j.Kind == SectBss {
+			s.Type = uint32(elf.SHT_NOBITS)
+		}
+
+		sh = append(sh, s)
+		ph = append(ph, p)
+		off += uint32(len(j.Data))
+	}
+
+	sym := off
+	str := sym + uint32(symtab.Len())
+	shstr := str + uint32(strtab.Len())
+	shoff := (shstr + uint32(shstrtab.Len()) + 3) &^ 3
+
+	sh = append(sh,
+		elf.Section32{
+			Name:      shname[".symtab"],
+			Type:      uint32(elf.SHT_SYMTAB),
+			Off:       sym,
+			Size:      uint32(symtab.Len()),
+			Link:      symndx + 1,
+			Info:      1,
+			Addralign: 4,
+			Entsize:   symsz,
+		},
+		elf.Section32{
+			Name:      shname[".strtab"],
+			Type:      uint32(elf.SHT_STRTAB),
+			Off:       str,
+			Size:      uint32(strtab.Len()),
+			Addralign: 1,
+		},
+		elf.Section32{
+			Name:      shname[".shstrtab"],
+			Type:      uint32(elf.SHT_STRTAB),
+			Off:       shstr,
+			Size:      uint32(shstrtab.Len()),
+			Addralign: 1,
+		},
+	)
+
+	hdr := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   EM_HYPO,
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     m.Entry,
+		Phoff:     ehsize,
+		Shoff:     shoff,
+		Ehsize:    ehsize,
+		Phentsize: phsize,
+		Phnum:     uint16(phnum),
+		Shentsize: shsize,
+		Shnum:     uint16(len(sh)),
+		Shstrndx:  uint16(symndx + 2),
+	}
+
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, hdr)
+	binary.Write(&b, binary.LittleEndian, ph)
+	b.Write(m.Code)
+	for _, j := range m.Sections {
+		b.Write(j.Data)
+	}
+	b.Write(symtab.Bytes())
+	b.Write(strtab.Bytes())
+	b.Write(shstrtab.Bytes())
+	b.Write(make([]byte, int(shoff)-b.Len()))
+	binary.Write(&b, binary.LittleEndian, sh)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// elfSection returns the index of the ELF section holding o, as laid
+// out by WriteELF, or SHN_ABS if no section of m holds it.
+func elfSection(m *Image, o Object) uint16 {
+	if o.Kind == SectText {
+		return 1
+	}
+
+	for i, j := range m.Sections {
+		if j.Kind == o.Kind && o.Addr >= j.Addr && o.Addr <= j.Addr+j.Size {
+			return uint16(2 + i)
+		}
+	}
+
+	return uint16(elf.SHN_ABS)
+}
@@ -0,0 +1,214 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Here is the name of the location counter in expressions.
+const Here = "."
+
+var funcs = map[string]byte{
+	"hi": 'h',
+	"lo": 'l',
+}
+
+// Expr is an assembly-time expression. Leaves are either a constant
+// Val or a reference to Name; interior nodes apply Op to X and Y.
+type Expr struct {
+	Op   byte
+	X, Y *Expr
+	Val  uint32
+	Name string
+}
+
+// Expr parses an expression from the symbol stream:
+//
+//	expr = term { ('+' | '-') term }
+//	term = '-' term | '(' expr ')' | func '(' expr ')' | immediate | identifier
+//	func = 'hi' | 'lo'
+//
+// hi and lo select the upper and lower 16 bits of their argument.
+func (s *Reader) Expr() (*Expr, error) {
+	x, err := s.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p := s.Peek()
+		if p.Type != Punct || (p.Val != "+" && p.Val != "-") {
+			return x, nil
+		}
+
+		s.Read()
+		y, err := s.term()
+		if err != nil {
+			return nil, err
+		}
+
+		x = &Expr{Op: p.Val[0], X: x, Y: y}
+	}
+}
+
+// ParseExpr parses s as a whole expression, written as in source.
+func ParseExpr(s string) (*Expr, error) {
+	var err error
+
+	sym := lex(NewLexer(strings.NewReader(s)), func(_ Symbol, e error) {
+		if err == nil {
+			err = e
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewReader(sym)
+
+	e, err := r.Expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if n := r.Peek(); n.Type != Eof {
+		return nil, errorf(CodeUnexpected, "unexpected '%s'", n.Val)
+	}
+
+	return e, nil
+}
+
+func (s *Reader) term() (*Expr, error) {
+	sym, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	switch sym.Type {
+	case Punct:
+		switch sym.Val {
+		case "-":
+			x, err := s.term()
+			if err != nil {
+				return nil, err
+			}
+
+			return &Expr{Op: 'n', X: x}, nil
+		case "(":
+			x, err := s.Expr()
+			if err != nil {
+				return nil, err
+			}
+
+			if sym, err = s.Read(); err != nil {
+				return nil, err
+			} else if sym.Type != Punct || sym.Val != ")" {
+				return nil, errorf(CodeUnexpected, "expected ')' got '%s'", sym.Val)
+			}
+
+			return x, nil
+		}
+	case Addr:
+		i, err := strconv.ParseInt(sym.Val, 16, 64)
+		if err != nil || i < -1<<31 || i > 1<<32-1 {
+			return nil, errorf(CodeBadNumber, "bad address '%s'", sym.Val)
+		}
+
+		return &Expr{Val: uint32(i)}, nil
+	case Id:
+		if op, ok := funcs[sym.Val]; ok {
+			if p := s.Peek(); p.Type == Punct && p.Val == "(" {
+				x, err := s.term()
+				if err != nil {
+					return nil, err
+				}
+
+				return &Expr{Op: op, X: x}, nil
+			}
+		}
+
+		return &Expr{Name: sym.Val}, nil
+	}
+
+	return nil, errorf(CodeUnexpected, "expected immediate got '%s'", sym.Val)
+}
+
+// at returns a copy of e with the location counter replaced by pc.
+func (e *Expr) at(pc uint32) *Expr {
+	if e == nil {
+		return nil
+	}
+
+	if e.Op == 0 && e.Name == Here {
+		return &Expr{Val: pc}
+	}
+
+	return &Expr{e.Op, e.X.at(pc), e.Y.at(pc), e.Val, e.Name}
+}
+
+// uses reports whether e refers to name.
+func (e *Expr) uses(name string) bool {
+	if e == nil {
+		return false
+	}
+
+	if e.Op == 0 {
+		return e.Name == name
+	}
+
+	return e.X.uses(name) || e.Y.uses(name)
+}
+
+// Const reports whether e refers to no names.
+func (e *Expr) Const() bool {
+	if e == nil {
+		return true
+	}
+
+	if e.Op == 0 {
+		return e.Name == ""
+	}
+
+	return e.X.Const() && e.Y.Const()
+}
+
+// eval computes e, resolving names through lookup.
+func (e *Expr) eval(lookup func(string) (uint32, error)) (uint32, error) {
+	if e.Op == 0 {
+		if e.Name == "" {
+			return e.Val, nil
+		}
+
+		return lookup(e.Name)
+	}
+
+	x, err := e.X.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.Op {
+	case 'n':
+		return -x, nil
+	case 'h':
+		return x >> 16, nil
+	case 'l':
+		return x & 0xffff, nil
+	}
+
+	y, err := e.Y.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.Op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	}
+
+	return 0, fmt.Errorf("bad operator '%c'", e.Op)
+}
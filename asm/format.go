@@ -0,0 +1,221 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Indent is the indentation of statements in formatted source.
+const Indent = "    "
+
+// Format returns src in canonical form: labels on lines of their own
+// in the first column, statements indented by Indent with their
+// operands and trailing comments aligned, and runs of blank lines
+// reduced to one. Lines that are part of a /* */ comment are kept as
+// they are.
+func Format(src []byte) ([]byte, error) {
+	var out bytes.Buffer
+
+	tw := tabwriter.NewWriter(&out, 0, 8, 1, ' ', 0)
+	blank := false
+	block := false
+	n := 0
+
+	for i, j := range strings.Split(string(src), "\n") {
+		code, comment, hasComment := strings.Cut(j, "#")
+
+		if block || strings.Contains(code, "/*") {
+			block = inBlock(j, block)
+			tw.Write([]byte(strings.TrimRight(j, " \t\r") + "\n"))
+			blank = false
+			n++
+			continue
+		}
+
+		if hasComment {
+			comment = "# " + strings.TrimSpace(comment)
+		}
+
+		sym, err := lexLine(code, i+1)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sym) == 0 && !hasComment {
+			blank = n > 0
+			continue
+		}
+
+		if blank {
+			tw.Write([]byte("\n"))
+			blank = false
+		}
+
+		n++
+
+		if !hasComment {
+			comment = ""
+		}
+
+		stmts := splitStmts(sym)
+		for k, st := range stmts {
+			c := ""
+			if k == len(stmts)-1 {
+				c = comment
+			}
+
+			formatStmt(tw, st, c, j[0] == '#')
+		}
+	}
+
+	tw.Flush()
+	return out.Bytes(), nil
+}
+
+// inBlock reports whether a /* */ comment is open at the end of
+// line, given whether one was open at its start.
+func inBlock(line string, open bool) bool {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case open && strings.HasPrefix(line[i:], "*/"):
+			open = false
+			i++
+		case !open && line[i] == '#':
+			return false
+		case !open && strings.HasPrefix(line[i:], "/*"):
+			open = true
+			i++
+		}
+	}
+
+	return open
+}
+
+// splitStmts splits the symbols of a line at each ';'.
+func splitStmts(sym []Symbol) [][]Symbol {
+	r := [][]Symbol{nil}
+
+	for _, j := range sym {
+		if j.Type == Punct && j.Val == ";" {
+			r = append(r, nil)
+			continue
+		}
+
+		r[len(r)-1] = append(r[len(r)-1], j)
+	}
+
+	return r
+}
+
+// formatStmt writes a statement with its labels and comment, if not
+// empty. A lone comment stays in the first column if it started there.
+func formatStmt(w io.Writer, sym []Symbol, comment string, col0 bool) {
+	for len(sym) > 0 && sym[0].Type == Label {
+		l := sym[0].Val + ":"
+		if len(sym) == 1 && comment != "" {
+			l += " " + comment
+			comment = ""
+		}
+
+		io.WriteString(w, l+"\n")
+		sym = sym[1:]
+	}
+
+	var line string
+
+	switch {
+	case len(sym) == 0:
+		if comment == "" {
+			return
+		}
+
+		line = Indent + comment
+		if col0 {
+			line = comment
+		}
+
+		comment = ""
+	case len(sym) > 1 && sym[1].Type == Punct && sym[1].Val == "=" && sym[0].Val != LoadConst:
+		line = sym[0].Val + " = " + joinSyms(sym[2:])
+	default:
+		line = Indent + symText(sym[0]) + "\t" + joinSyms(sym[1:])
+	}
+
+	if comment != "" {
+		line += "\t" + comment
+	}
+
+	io.WriteString(w, strings.TrimRight(line, " \t")+"\n")
+}
+
+// lexLine returns the symbols of a line of source without comments.
+func lexLine(code string, line int) ([]Symbol, error) {
+	var r []Symbol
+
+	l := NewLexer(strings.NewReader(code))
+	for {
+		s, err := l.Read()
+		if err != nil {
+			return nil, errors.New(Diagnostic{Line: line, Code: Code(err), Msg: err.Error()}.String())
+		}
+
+		if s.Type == Eof {
+			return r, nil
+		}
+
+		if s.Type != -1 {
+			r = append(r, s)
+		}
+	}
+}
+
+func symText(s Symbol) string {
+	switch s.Type {
+	case Reg:
+		return "%" + s.Val
+	case Addr:
+		return "$" + s.Val
+	case Label:
+		return s.Val + ":"
+	case Str:
+		return `"` + s.Val + `"`
+	}
+
+	return s.Val
+}
+
+// joinSyms writes out operands, separating them by spaces and keeping
+// expressions together.
+func joinSyms(s []Symbol) string {
+	var b strings.Builder
+
+	for i, j := range s {
+		if i > 0 && spaced(s[i-1], j) {
+			b.WriteByte(' ')
+		}
+
+		b.WriteString(symText(j))
+	}
+
+	return b.String()
+}
+
+func spaced(a, b Symbol) bool {
+	if b.Type == Punct && strings.Contains(",)+-", b.Val) {
+		return false
+	}
+
+	if a.Type == Punct && strings.Contains("(+-=", a.Val) {
+		return false
+	}
+
+	if b.Type == Punct && b.Val == "(" {
+		_, ok := funcs[a.Val]
+		return a.Type != Id || !ok
+	}
+
+	return true
+}
@@ -0,0 +1,46 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const hexRecord = 16
+
+// WriteHex writes code to w as Intel HEX records starting at address
+// 0. Extended linear address records are emitted when code crosses a
+// 64 KiB boundary.
+func WriteHex(w io.Writer, code []byte) error {
+	b := bufio.NewWriter(w)
+
+	for i := 0; i < len(code); i += hexRecord {
+		if i > 0 && i&0xffff == 0 {
+			hexLine(b, 0, 4, []byte{byte(i >> 24), byte(i >> 16)})
+		}
+
+		end := i + hexRecord
+		if end > len(code) {
+			end = len(code)
+		}
+
+		// records never cross a 64 KiB boundary since it is a
+		// multiple of the record length.
+		hexLine(b, uint16(i), 0, code[i:end])
+	}
+
+	hexLine(b, 0, 1, nil)
+	return b.Flush()
+}
+
+func hexLine(w io.Writer, addr uint16, typ byte, data []byte) {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + typ
+
+	fmt.Fprintf(w, ":%02X%04X%02X", len(data), addr, typ)
+	for _, j := range data {
+		fmt.Fprintf(w, "%02X", j)
+		sum += j
+	}
+
+	fmt.Fprintf(w, "%02X\n", -sum)
+}
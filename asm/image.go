@@ -0,0 +1,367 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Magic starts every image.
+const Magic = "HYP"
+
+// Version is the current image format version. Version 0 images are
+// Magic and a zero byte followed by the code; version 1 headers lack
+// the Sum field.
+const Version = 2
+
+// Header flags.
+const (
+	FlagDebug = 1 << iota
+	FlagSections
+	FlagSymbols
+)
+
+// Header is the start of an image. It is followed by Size bytes of
+// code, then a line table if FlagDebug is set:
+//
+//	nfiles uint32, { len uint16, name [len]byte }
+//	nlines uint32, { addr uint32, file uint16, line uint32 }
+//
+// and then a table of memory sections if FlagSections is set:
+//
+//	nsects uint32, { kind byte, addr uint32, size uint32, data [size]byte }
+//
+// data is omitted for bss sections. Last comes a symbol table if
+// FlagSymbols is set:
+//
+//	nsyms uint32, { kind byte, addr uint32, len uint16, name [len]byte }
+//
+// Sum is the IEEE CRC-32 of everything following the header. All
+// integers are little endian.
+type Header struct {
+	Magic   [3]byte
+	Version byte
+	Entry   uint32
+	Size    uint32
+	Flags   uint32
+	Sum     uint32
+}
+
+// Line maps the instruction at Addr to its source location.
+type Line struct {
+	Addr uint32
+	File string
+	Line int
+}
+
+// Section is a region of memory initialized by the loader. Data is
+// nil for bss sections.
+type Section struct {
+	Kind int
+	Addr uint32
+	Size uint32
+	Data []byte
+}
+
+// Object is a named address in the section of kind Kind.
+type Object struct {
+	Name string
+	Kind int
+	Addr uint32
+}
+
+// Image is a decoded hypo binary. Lines, Sections and Objects are
+// sorted by Addr.
+type Image struct {
+	Entry    uint32
+	Code     []byte
+	Lines    []Line
+	Sections []Section
+	Objects  []Object
+}
+
+// ReadImage decodes a binary written by Image.Bytes.
+func ReadImage(b []byte) (*Image, error) {
+	var h Header
+
+	r := bytes.NewReader(b)
+	if binary.Read(r, binary.LittleEndian, &h.Magic) != nil || binary.Read(r, binary.LittleEndian, &h.Version) != nil {
+		return nil, errors.New("could not read header")
+	} else if string(h.Magic[:]) != Magic {
+		return nil, errors.New("bad header")
+	}
+
+	switch h.Version {
+	case 0:
+		return &Image{Code: b[4:]}, nil
+	case 1, Version:
+	default:
+		return nil, fmt.Errorf("unsupported version %d", h.Version)
+	}
+
+	if binary.Read(r, binary.LittleEndian, &h.Entry) != nil ||
+		binary.Read(r, binary.LittleEndian, &h.Size) != nil ||
+		binary.Read(r, binary.LittleEndian, &h.Flags) != nil ||
+		h.Version > 1 && binary.Read(r, binary.LittleEndian, &h.Sum) != nil {
+		return nil, errors.New("could not read header")
+	}
+
+	if h.Version > 1 && crc32.ChecksumIEEE(b[len(b)-r.Len():]) != h.Sum {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	if h.Flags&^(FlagDebug|FlagSections|FlagSymbols) != 0 {
+		return nil, fmt.Errorf("unknown flags %08x", h.Flags)
+	}
+
+	if int64(h.Size) > int64(r.Len()) {
+		return nil, errors.New("bad code size")
+	}
+
+	if h.Entry != 0 && h.Entry >= h.Size {
+		return nil, fmt.Errorf("entry point %08x outside code", h.Entry)
+	}
+
+	off := len(b) - r.Len()
+	m := &Image{Entry: h.Entry, Code: b[off : off+int(h.Size)]}
+	r.Seek(int64(h.Size), io.SeekCurrent)
+
+	if h.Flags&FlagDebug != 0 {
+		if err := m.readLines(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Flags&FlagSections != 0 {
+		if err := m.readSections(r, b); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Flags&FlagSymbols != 0 {
+		if err := m.readObjects(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Image) readObjects(r *bytes.Reader) error {
+	bad := errors.New("bad symbol table")
+
+	var n uint32
+	if binary.Read(r, binary.LittleEndian, &n) != nil {
+		return bad
+	}
+
+	for i := uint32(0); i < n; i++ {
+		var h struct {
+			Kind byte
+			Addr uint32
+			Len  uint16
+		}
+
+		if binary.Read(r, binary.LittleEndian, &h) != nil || int(h.Len) > r.Len() {
+			return bad
+		}
+
+		s := make([]byte, h.Len)
+		r.Read(s)
+		m.Objects = append(m.Objects, Object{string(s), int(h.Kind), h.Addr})
+	}
+
+	return nil
+}
+
+func (m *Image) readLines(r *bytes.Reader) error {
+	bad := errors.New("bad debug section")
+
+	var n uint32
+	if binary.Read(r, binary.LittleEndian, &n) != nil {
+		return bad
+	}
+
+	var files []string
+	for i := uint32(0); i < n; i++ {
+		var l uint16
+		if binary.Read(r, binary.LittleEndian, &l) != nil || int(l) > r.Len() {
+			return bad
+		}
+
+		s := make([]byte, l)
+		r.Read(s)
+		files = append(files, string(s))
+	}
+
+	if binary.Read(r, binary.LittleEndian, &n) != nil {
+		return bad
+	}
+
+	for i := uint32(0); i < n; i++ {
+		var e struct {
+			Addr uint32
+			File uint16
+			Line uint32
+		}
+
+		if binary.Read(r, binary.LittleEndian, &e) != nil || int(e.File) >= len(files) {
+			return bad
+		}
+
+		m.Lines = append(m.Lines, Line{e.Addr, files[e.File], int(e.Line)})
+	}
+
+	return nil
+}
+
+// readSections reads the section table from r, which reads b.
+func (m *Image) readSections(r *bytes.Reader, b []byte) error {
+	bad := errors.New("bad section table")
+
+	var n uint32
+	if binary.Read(r, binary.LittleEndian, &n) != nil {
+		return bad
+	}
+
+	for i := uint32(0); i < n; i++ {
+		var h struct {
+			Kind byte
+			Addr uint32
+			Size uint32
+		}
+
+		if binary.Read(r, binary.LittleEndian, &h) != nil {
+			return bad
+		}
+
+		s := Section{Kind: int(h.Kind), Addr: h.Addr, Size: h.Size}
+
+		switch s.Kind {
+		case SectData:
+			if int64(h.Size) > int64(r.Len()) {
+				return bad
+			}
+
+			off := len(b) - r.Len()
+			s.Data = b[off : off+int(h.Size)]
+			r.Seek(int64(h.Size), io.SeekCurrent)
+		case SectBss:
+		default:
+			return fmt.Errorf("bad section kind %d", s.Kind)
+		}
+
+		m.Sections = append(m.Sections, s)
+	}
+
+	return nil
+}
+
+// Bytes encodes m as a current version image. The line and section
+// tables are only written if m has them.
+func (m *Image) Bytes() []byte {
+	h := Header{Version: Version, Entry: m.Entry, Size: uint32(len(m.Code))}
+	copy(h.Magic[:], Magic)
+
+	if len(m.Lines) > 0 {
+		h.Flags |= FlagDebug
+	}
+
+	if len(m.Sections) > 0 {
+		h.Flags |= FlagSections
+	}
+
+	if len(m.Objects) > 0 {
+		h.Flags |= FlagSymbols
+	}
+
+	var b bytes.Buffer
+	b.Write(m.Code)
+
+	if h.Flags&FlagDebug != 0 {
+		m.writeLines(&b)
+	}
+
+	if h.Flags&FlagSections != 0 {
+		binary.Write(&b, binary.LittleEndian, uint32(len(m.Sections)))
+		for _, j := range m.Sections {
+			b.WriteByte(byte(j.Kind))
+			binary.Write(&b, binary.LittleEndian, j.Addr)
+			binary.Write(&b, binary.LittleEndian, j.Size)
+			if j.Kind == SectData {
+				b.Write(j.Data)
+			}
+		}
+	}
+
+	if h.Flags&FlagSymbols != 0 {
+		binary.Write(&b, binary.LittleEndian, uint32(len(m.Objects)))
+		for _, j := range m.Objects {
+			b.WriteByte(byte(j.Kind))
+			binary.Write(&b, binary.LittleEndian, j.Addr)
+			binary.Write(&b, binary.LittleEndian, uint16(len(j.Name)))
+			b.WriteString(j.Name)
+		}
+	}
+
+	h.Sum = crc32.ChecksumIEEE(b.Bytes())
+
+	var hb bytes.Buffer
+	binary.Write(&hb, binary.LittleEndian, h)
+	hb.Write(b.Bytes())
+	return hb.Bytes()
+}
+
+func (m *Image) writeLines(b *bytes.Buffer) {
+	var files []string
+	idx := make(map[string]uint16)
+	for _, j := range m.Lines {
+		if _, ok := idx[j.File]; !ok {
+			idx[j.File] = uint16(len(files))
+			files = append(files, j.File)
+		}
+	}
+
+	binary.Write(b, binary.LittleEndian, uint32(len(files)))
+	for _, j := range files {
+		binary.Write(b, binary.LittleEndian, uint16(len(j)))
+		b.WriteString(j)
+	}
+
+	binary.Write(b, binary.LittleEndian, uint32(len(m.Lines)))
+	for _, j := range m.Lines {
+		binary.Write(b, binary.LittleEndian, j.Addr)
+		binary.Write(b, binary.LittleEndian, idx[j.File])
+		binary.Write(b, binary.LittleEndian, uint32(j.Line))
+	}
+}
+
+// Object returns the object of a section of kind that contains addr:
+// the one with the highest address not above it. off is the offset of
+// addr into the object.
+func (m *Image) Object(kind int, addr uint32) (o Object, off uint32, ok bool) {
+	for _, j := range m.Objects {
+		if j.Kind == kind && j.Addr <= addr && (!ok || j.Addr >= o.Addr) {
+			o, ok = j, true
+		}
+	}
+
+	return o, addr - o.Addr, ok
+}
+
+// Source returns the line table entry covering addr.
+func (m *Image) Source(addr uint32) (Line, bool) {
+	i := sort.Search(len(m.Lines), func(i int) bool {
+		return m.Lines[i].Addr > addr
+	})
+
+	if i == 0 {
+		return Line{}, false
+	}
+
+	return m.Lines[i-1], true
+}
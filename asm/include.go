@@ -0,0 +1,152 @@
+package asm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxInclude bounds the nesting of .include.
+const maxInclude = 16
+
+// source is the origin of a line of source after expanding includes.
+type source struct {
+	file string
+	line int
+	text string
+}
+
+// include appends the lines of src, read from file, to b, replacing
+// each '.include "name"' line by the lines of the file it names. The
+// origin of every line is appended to w.src. open lists the files
+// being included, outermost first. Errors are passed to werr, and
+// belong to the last line appended.
+func (w *Writer) include(b *bytes.Buffer, src []byte, file string, open []string, werr func(error)) {
+	block := false
+
+	for i, j := range strings.SplitAfter(string(src), "\n") {
+		if j == "" {
+			continue
+		}
+
+		at := source{file, i + 1, strings.TrimRight(j, "\r\n")}
+
+		// Lines starting in a /* */ comment are not directives.
+		name, ok := includeName(j)
+		ok = ok && !block
+		block = inBlock(j, block)
+
+		if !ok {
+			b.WriteString(j)
+			w.src = append(w.src, at)
+			continue
+		}
+
+		// Keep the line count by leaving the directive as a blank line.
+		b.WriteString("\n")
+		w.src = append(w.src, at)
+
+		if len(open) >= maxInclude {
+			werr(errorf(CodeInclude, "includes nested too deeply"))
+			continue
+		}
+
+		path, inc, err := w.findInclude(name, file)
+		if err != nil {
+			werr(err)
+			continue
+		}
+
+		if cyclic(open, path) {
+			werr(errorf(CodeInclude, "circular include of '%s'", name))
+			continue
+		}
+
+		if len(inc) > 0 && inc[len(inc)-1] != '\n' {
+			inc = append(inc, '\n')
+		}
+
+		w.include(b, inc, path, append(open, path), werr)
+	}
+}
+
+// cyclic reports whether path is one of the files in open.
+func cyclic(open []string, path string) bool {
+	for _, j := range open {
+		if filepath.Clean(j) == filepath.Clean(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// includeName returns the file named by line if it is an .include
+// directive. The name may be quoted.
+func includeName(line string) (string, bool) {
+	code, _, _ := strings.Cut(line, "#")
+
+	f := strings.Fields(code)
+	if len(f) != 2 || f[0] != Include {
+		return "", false
+	}
+
+	return strings.Trim(f[1], `"`), true
+}
+
+// findInclude reads the file name included from the file from,
+// looking in the directory of from and then in each of the include
+// paths.
+func (w *Writer) findInclude(name, from string) (string, []byte, error) {
+	dirs := append([]string{filepath.Dir(from)}, w.opts.IncludePaths...)
+	if filepath.IsAbs(name) {
+		dirs = []string{""}
+	}
+
+	for _, j := range dirs {
+		p := filepath.Join(j, name)
+		if b, err := os.ReadFile(p); err == nil {
+			return p, b, nil
+		}
+	}
+
+	return "", nil, errorf(CodeInclude, "cannot find include file '%s'", name)
+}
+
+// where returns the origin of line n of the expanded source.
+func (w *Writer) where(n int) source {
+	if n > 0 && n <= len(w.src) {
+		return w.src[n-1]
+	}
+
+	return source{w.file, n, ""}
+}
+
+// locate wraps report to give each diagnostic the file, line and text
+// it has in the source before expanding includes.
+func (w *Writer) locate(report func(Diagnostic)) func(Diagnostic) {
+	return func(d Diagnostic) {
+		if d.Line > 0 && d.Line <= len(w.src) {
+			s := w.src[d.Line-1]
+			d.Line, d.Text = s.line, s.text
+
+			if s.file != w.file {
+				d.File = s.file
+			}
+		}
+
+		report(d)
+	}
+}
+
+// origin returns the position in the source before expanding includes
+// of line n of the expanded source.
+func (w *Writer) origin(n int) Pos {
+	s := w.where(n)
+	if s.file == w.file {
+		return Pos{Line: s.line}
+	}
+
+	return Pos{s.file, s.line}
+}
@@ -0,0 +1,80 @@
+package asm
+
+// Inline replaces each 'call f' and 'callr f' by the body of f, if f
+// is a leaf routine whose body takes at most budget bytes. A leaf
+// routine is a label followed by plain instructions without any
+// control flow, ending in 'ret', that do not touch the stack. Inlined
+// code keeps the source lines of the routine, and the routine itself
+// stays in place for other uses. Unlike call, inlined code pushes no
+// return address, so it writes nothing below %sp.
+func Inline(p []Stmt, budget int) []Stmt {
+	leaves := make(map[string][]Stmt)
+
+	for i, j := range p {
+		if j.Kind != StmtLabel {
+			continue
+		}
+
+		k := i + 1
+		for k < len(p) && p[k].Kind == StmtLabel {
+			k++
+		}
+
+		if body, ok := leaf(p[k:], budget); ok {
+			leaves[j.Name] = body
+		}
+	}
+
+	var r []Stmt
+	for _, j := range p {
+		if j.Kind == StmtInst && (j.Name == "call" || j.Name == "callr") {
+			if e := j.Args[0].Expr; e.Op == 0 {
+				if body, ok := leaves[e.Name]; ok {
+					r = append(r, body...)
+					continue
+				}
+			}
+		}
+
+		r = append(r, j)
+	}
+
+	return r
+}
+
+// leaf returns the body of the routine starting p, without its ret, if
+// it is a leaf routine of at most budget bytes.
+func leaf(p []Stmt, budget int) ([]Stmt, bool) {
+	n := 0
+
+	for i, j := range p {
+		f, ok := inst[j.Name]
+		if j.Kind != StmtInst || !ok || !plain(j) {
+			return nil, false
+		}
+
+		if j.Name == "ret" {
+			return p[:i], true
+		}
+
+		if f.Stack {
+			return nil, false
+		}
+
+		for _, k := range j.Args {
+			if k.Kind == Reg && (k.Sym.Val == "sp" || k.Sym.Val == "7") {
+				return nil, false
+			}
+		}
+
+		if d := (Decoded{Op: f.Op, Name: j.Name}); d.IsBranch() || !d.FallsThrough() || j.Name == "yield" {
+			return nil, false
+		}
+
+		if n += Size(f.Op); n > budget {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
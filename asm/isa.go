@@ -0,0 +1,74 @@
+package asm
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Spec is an instruction as described by the ISA specification.
+// Operands are "reg" or "addr", Reads and Writes index the register
+// operands, as in Instruction, and Flow is "next", "branch", "jump",
+// "call" or "stop". Stack is set for instructions that also read and
+// write %sp, and SetsFlags and TestsFlags for those that write or read
+// the status flags.
+type Spec struct {
+	Mnemonic   string   `json:"mnemonic"`
+	Opcode     byte     `json:"opcode"`
+	Operands   []string `json:"operands"`
+	Size       int      `json:"size"`
+	Reads      []int    `json:"reads"`
+	Writes     []int    `json:"writes"`
+	Flow       string   `json:"flow"`
+	Relative   bool     `json:"relative"`
+	Stack      bool     `json:"stack"`
+	SetsFlags  bool     `json:"setsFlags"`
+	TestsFlags bool     `json:"testsFlags"`
+}
+
+var flowNames = []string{"next", "branch", "jump", "call", "stop"}
+
+// ISA returns the specification of every instruction, including those
+// added with RegisterInstruction, ordered by opcode.
+func ISA() []Spec {
+	var r []Spec
+
+	for k, v := range inst {
+		s := Spec{
+			Mnemonic:   mnemonic(k),
+			Opcode:     v.Op,
+			Operands:   []string{},
+			Size:       Size(v.Op),
+			Reads:      append([]int{}, v.Reads...),
+			Writes:     append([]int{}, v.Writes...),
+			Flow:       flowNames[v.Flow],
+			Relative:   v.Rel,
+			Stack:      v.Stack,
+			SetsFlags:  v.SetsFlags,
+			TestsFlags: v.TestsFlags,
+		}
+
+		for _, j := range v.Params {
+			if j == Reg {
+				s.Operands = append(s.Operands, "reg")
+			} else {
+				s.Operands = append(s.Operands, "addr")
+			}
+		}
+
+		r = append(r, s)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Opcode < r[j].Opcode
+	})
+
+	return r
+}
+
+// WriteISA writes the specification returned by ISA to w as JSON.
+func WriteISA(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(ISA())
+}
@@ -0,0 +1,194 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Lexer splits assembly source into symbols, tracking line and
+// column numbers.
+type Lexer struct {
+	r    *bufio.Reader
+	line int
+	col  int
+}
+
+func NewLexer(r io.Reader) *Lexer {
+	return &Lexer{bufio.NewReader(r), 1, 0}
+}
+
+func (l *Lexer) readByte() (byte, error) {
+	c, err := l.r.ReadByte()
+	if err == nil {
+		l.col++
+	}
+
+	return c, err
+}
+
+func (l *Lexer) unreadByte() {
+	l.r.UnreadByte()
+	l.col--
+}
+
+// ReadToken reads the rest of a token, up to white space, a comment
+// or punctuation.
+func (l *Lexer) ReadToken() (string, error) {
+	b := new(bytes.Buffer)
+
+	for {
+		c, err := l.readByte()
+
+		if err != nil {
+			if b.Len() > 0 {
+				break
+			}
+
+			return "", err
+		}
+
+		if unicode.IsSpace(rune(c)) || c == '#' || (b.Len() > 0 && strings.IndexByte(puncts, c) >= 0) {
+			l.unreadByte()
+			break
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String(), nil
+}
+
+// skipBlock skips the rest of a /* */ comment whose '/' has been
+// read.
+func (l *Lexer) skipBlock() error {
+	l.readByte()
+
+	for {
+		c, err := l.readByte()
+		if err != nil {
+			return errorf(CodeUnterminated, "unterminated comment")
+		}
+
+		switch c {
+		case '\n':
+			l.line++
+			l.col = 0
+		case '*':
+			if b, err := l.r.Peek(1); err == nil && b[0] == '/' {
+				l.readByte()
+				return nil
+			}
+		}
+	}
+}
+
+// Read returns the next symbol. Symbols of type -1 carry no
+// information and should be skipped.
+func (l *Lexer) Read() (sym Symbol, err error) {
+	sym.Type = -1
+
+	for {
+		c, err := l.readByte()
+		col := l.col
+
+		if err != nil {
+			sym = Symbol{Eof, "", l.line, col + 1}
+			break
+		}
+
+		switch c {
+		case '\n':
+			l.line++
+			l.col = 0
+		case '#':
+			if _, err := l.r.ReadBytes('\n'); err == nil {
+				l.line++
+				l.col = 0
+			}
+			return sym, nil
+		case '/':
+			if b, err := l.r.Peek(1); err == nil && b[0] == '*' {
+				sym.Line, sym.Col = l.line, col
+				return sym, l.skipBlock()
+			}
+		}
+
+		if unicode.IsSpace(rune(c)) {
+			continue
+		}
+
+		if !unicode.IsGraphic(rune(c)) {
+			sym.Line, sym.Col = l.line, col
+			return sym, errorf(CodeBadChar, "invalid character '%02x'", c)
+		}
+
+		if strings.IndexByte(puncts, c) >= 0 {
+			sym = Symbol{Punct, string(c), l.line, col}
+			break
+		}
+
+		if t, ok := syms[c]; ok {
+			s, err := l.ReadToken()
+
+			if err != nil {
+				sym = Symbol{Eof, "", l.line, l.col + 1}
+			} else {
+				sym = Symbol{t, s, l.line, col}
+			}
+
+			break
+		}
+
+		if unicode.IsLetter(rune(c)) || c == '.' || c == '_' || c == '\\' {
+			l.unreadByte()
+			s, err := l.ReadToken()
+
+			if err != nil {
+				sym = Symbol{Eof, "", l.line, l.col + 1}
+			} else if s[len(s)-1] == ':' {
+				sym = Symbol{Label, strings.TrimSuffix(s, ":"), l.line, col}
+			} else {
+				sym = Symbol{Id, s, l.line, col}
+			}
+
+			break
+		}
+
+		if c == '"' {
+			s, err := l.readString()
+			sym = Symbol{Str, s, l.line, col}
+			return sym, err
+		}
+
+		sym.Line, sym.Col = l.line, col
+		return sym, errorf(CodeBadChar, "unexpected character '%c'", c)
+	}
+
+	return sym, nil
+}
+
+// readString reads the rest of a string whose opening quote has been
+// read. Strings end on the same line and have no escapes.
+func (l *Lexer) readString() (string, error) {
+	var b bytes.Buffer
+
+	for {
+		c, err := l.readByte()
+		if err != nil || c == '\n' {
+			if err == nil {
+				l.unreadByte()
+			}
+
+			return b.String(), errorf(CodeUnterminated, "unterminated string")
+		}
+
+		if c == '"' {
+			return b.String(), nil
+		}
+
+		b.WriteByte(c)
+	}
+}
@@ -0,0 +1,278 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ABI is a register convention, with register sets as bit masks.
+type ABI struct {
+	Args  uint32 // registers holding arguments and results
+	Saved uint32 // registers a routine must preserve
+	Sp    byte   // stack pointer, preserved and readable on entry
+}
+
+// StdABI is the convention of hypo programs: arguments and results in
+// %0 to %2, %3 free, %4 to %6 preserved across calls and the stack
+// pointer in %7, below which call pushes the return address.
+var StdABI = ABI{Args: 0x07, Saved: 0x70, Sp: 7}
+
+// Routine is the register usage of a routine. LiveIn holds the
+// registers it reads before writing, LiveOut those it writes that a
+// caller reads after it returns, and Clobbers all it may write,
+// including through the routines it calls. Problems lists the ways it
+// breaks the ABI.
+type Routine struct {
+	Addr     uint32
+	LiveIn   []byte
+	LiveOut  []byte
+	Clobbers []byte
+	Problems []string
+}
+
+// routine is a routine being analysed, with register sets as masks.
+type routine struct {
+	addr uint32
+	ins  []int
+	in   uint32
+	clob uint32
+	live map[int]uint32
+}
+
+// Liveness analyses every routine of code, loaded at base: the one at
+// entry and each target of call or callr. Calls are summarised by the
+// callee's registers and hcall is taken to read and write abi.Args.
+// Only called routines are checked against abi. Routines are ordered
+// by address.
+func Liveness(code []byte, base, entry uint32, abi ABI) []Routine {
+	prog := Disasm(code, base)
+
+	at := make(map[uint32]int, len(prog))
+	for i, j := range prog {
+		at[j.Addr] = i
+	}
+
+	called := make(map[uint32]bool)
+	rs := map[uint32]*routine{entry: {addr: entry}}
+
+	for _, j := range prog {
+		if j.IsCall() {
+			if t, ok := j.BranchTarget(); ok {
+				rs[t] = &routine{addr: t}
+				called[t] = true
+			}
+		}
+	}
+
+	// Registers only grow from empty, so recursive routines get the
+	// least solution.
+	for _, r := range rs {
+		r.ins = reach(prog, at, r.addr)
+	}
+
+	effect := func(d Decoded) (use, def uint32) {
+		switch {
+		case d.IsCall():
+			t, _ := d.BranchTarget()
+			if c, ok := rs[t]; ok {
+				return c.in | mask(d.Reads()), c.clob
+			}
+		case (d.Op == OpHcall || d.Op == OpSys) && d.Name != "":
+			return abi.Args, abi.Args
+		}
+
+		// Stack instructions leave %sp balanced in correct code.
+		use, def = mask(d.Reads()), mask(d.Writes())
+		if d.info().Stack {
+			def &^= 1 << abi.Sp
+		}
+
+		return use, def
+	}
+
+	for n, changed := 0, true; changed && n < 32; n++ {
+		changed = false
+
+		for _, r := range rs {
+			r.live = live(prog, at, r.ins, effect)
+
+			var in, clob uint32
+			if len(r.ins) > 0 {
+				in = r.live[r.ins[0]]
+			}
+
+			for _, i := range r.ins {
+				_, def := effect(prog[i])
+				clob |= def
+			}
+
+			if in != r.in || clob != r.clob {
+				r.in, r.clob = in, clob
+				changed = true
+			}
+		}
+	}
+
+	out := make(map[uint32]uint32)
+	for _, r := range rs {
+		for _, i := range r.ins {
+			d := prog[i]
+			t, ok := d.BranchTarget()
+			if !ok || !d.IsCall() || i+1 >= len(prog) {
+				continue
+			}
+
+			if c, ok := rs[t]; ok {
+				out[t] |= r.live[i+1] & c.clob
+			}
+		}
+	}
+
+	var res []Routine
+	for _, r := range rs {
+		x := Routine{Addr: r.addr, LiveIn: regList(r.in), LiveOut: regList(out[r.addr]), Clobbers: regList(r.clob)}
+		if called[r.addr] {
+			x.Problems = check(r.in, r.clob, out[r.addr], abi)
+		}
+
+		res = append(res, x)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Addr < res[j].Addr
+	})
+
+	return res
+}
+
+// check returns the ways a called routine with the given registers
+// breaks abi.
+func check(in, clob, out uint32, abi ABI) []string {
+	var r []string
+
+	for _, j := range regList(in & abi.Saved) {
+		r = append(r, fmt.Sprintf("reads %%%d before writing it", j))
+	}
+
+	for _, j := range regList(clob & (abi.Saved | 1<<abi.Sp)) {
+		r = append(r, fmt.Sprintf("clobbers preserved %%%d", j))
+	}
+
+	for _, j := range regList(out &^ abi.Args &^ (1 << abi.Sp)) {
+		r = append(r, fmt.Sprintf("returns a value in %%%d", j))
+	}
+
+	return r
+}
+
+// reach returns the instructions of the routine at addr: those
+// reachable from it without following calls or returns.
+func reach(prog []Decoded, at map[uint32]int, addr uint32) []int {
+	var r []int
+
+	seen := make(map[int]bool)
+	todo := []uint32{addr}
+
+	for len(todo) > 0 {
+		a := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		i, ok := at[a]
+		if !ok || seen[i] {
+			continue
+		}
+
+		seen[i] = true
+		r = append(r, i)
+
+		for _, j := range succ(prog[i]) {
+			todo = append(todo, j)
+		}
+	}
+
+	sort.Ints(r)
+
+	// The routine starts at addr even if it loops back below it.
+	for k, i := range r {
+		if prog[i].Addr == addr {
+			r[0], r[k] = r[k], r[0]
+		}
+	}
+
+	return r
+}
+
+// succ returns the addresses execution may continue at after d within
+// a routine: calls return to the next instruction, and ret leaves it
+// as jr does, to an address not known without running it.
+func succ(d Decoded) []uint32 {
+	next := d.Addr + uint32(d.Size)
+
+	switch {
+	case d.Name == "":
+		return nil
+	case d.IsCall():
+		return []uint32{next}
+	}
+
+	var r []uint32
+	if d.FallsThrough() {
+		r = append(r, next)
+	}
+
+	if t, ok := d.BranchTarget(); ok {
+		r = append(r, t)
+	}
+
+	return r
+}
+
+// live returns the registers live before each instruction of ins.
+func live(prog []Decoded, at map[uint32]int, ins []int, effect func(Decoded) (uint32, uint32)) map[int]uint32 {
+	in := make(map[int]uint32, len(ins))
+
+	for changed := true; changed; {
+		changed = false
+
+		for k := len(ins) - 1; k >= 0; k-- {
+			i := ins[k]
+
+			var out uint32
+			for _, j := range succ(prog[i]) {
+				if s, ok := at[j]; ok {
+					out |= in[s]
+				}
+			}
+
+			use, def := effect(prog[i])
+			if v := use | out&^def; v != in[i] {
+				in[i] = v
+				changed = true
+			}
+		}
+	}
+
+	return in
+}
+
+func mask(r []byte) uint32 {
+	var m uint32
+	for _, j := range r {
+		if j < 32 {
+			m |= 1 << j
+		}
+	}
+
+	return m
+}
+
+func regList(m uint32) []byte {
+	var r []byte
+	for i := byte(0); i < 32; i++ {
+		if m&(1<<i) != 0 {
+			r = append(r, i)
+		}
+	}
+
+	return r
+}
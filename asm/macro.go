@@ -0,0 +1,316 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Macro directives. A macro is defined by
+//
+//	.macro name a b
+//	.scratch t
+//	    addi \a $0 \t
+//	    addi \b $0 \a
+//	    addi \t $0 \b
+//	.endm
+//
+// and used as 'name %1 %2', alone on its line after any labels. In
+// the body, \a stands for the argument given for a and \@ for a number
+// unique to each expansion, for labels. Each name declared by .scratch
+// stands for a register named neither by the arguments nor by the
+// body, other than %sp, which is pushed before the body and popped
+// after it so that the caller's value survives.
+const (
+	Macro   = ".macro"
+	Endm    = ".endm"
+	Scratch = ".scratch"
+)
+
+// maxMacro bounds the nesting of macro expansions.
+const maxMacro = 16
+
+type macro struct {
+	params  []string
+	scratch []string
+	body    []string
+}
+
+// macroParam matches a parameter reference in a macro body.
+var macroParam = regexp.MustCompile(`\\(@|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// regName matches a register operand.
+var regName = regexp.MustCompile(`%([0-9]+|sp)\b`)
+
+// expand replaces each macro definition in src by blank lines and each
+// use by the body of the macro. w.src gives the origin of each line of
+// src, and is updated to match the result; lines of an expansion come
+// from the line using the macro. Errors are passed to werr, and belong
+// to the last line written.
+func (w *Writer) expand(src []byte, werr func(error)) []byte {
+	var b bytes.Buffer
+
+	orig := w.src
+	w.src = nil
+
+	macros := make(map[string]*macro)
+	lines := strings.SplitAfter(string(src), "\n")
+	block := false
+	n := 0
+
+	for i := 0; i < len(lines); i++ {
+		j := lines[i]
+		if j == "" {
+			continue
+		}
+
+		at := source{w.file, i + 1, strings.TrimRight(j, "\r\n")}
+		if i < len(orig) {
+			at = orig[i]
+		}
+
+		f := strings.Fields(stripComment(j))
+		open := block
+		block = inBlock(j, block)
+
+		if open || len(f) == 0 {
+			b.WriteString(j)
+			w.src = append(w.src, at)
+			continue
+		}
+
+		switch f[0] {
+		case Macro:
+			b.WriteString("\n")
+			w.src = append(w.src, at)
+
+			end := i + 1
+			for end < len(lines) && !isEndm(lines[end]) {
+				end++
+			}
+
+			m := &macro{}
+			if end == len(lines) {
+				werr(errorf(CodeMacro, "%s without %s", Macro, Endm))
+			} else if len(f) < 2 {
+				werr(errorf(CodeMacro, "%s without a name", Macro))
+			} else if _, ok := macros[f[1]]; ok {
+				werr(errorf(CodeMacro, "redefining macro '%s'", f[1]))
+			} else if _, ok := inst[f[1]]; ok {
+				werr(errorf(CodeMacro, "macro '%s' has the name of an instruction", f[1]))
+			} else {
+				m.params = f[2:]
+				macros[f[1]] = m
+			}
+
+			// Collect the body, blanking its lines and the .endm.
+			for k := i + 1; k < len(lines) && k <= end; k++ {
+				b.WriteString("\n")
+				if k < len(orig) {
+					w.src = append(w.src, orig[k])
+				} else {
+					w.src = append(w.src, source{w.file, k + 1, ""})
+				}
+
+				g := strings.Fields(stripComment(lines[k]))
+
+				switch {
+				case k == end:
+				case len(g) > 0 && g[0] == Macro:
+					werr(errorf(CodeMacro, "%s inside a macro", Macro))
+				case len(g) > 0 && g[0] == Scratch:
+					m.scratch = append(m.scratch, g[1:]...)
+				default:
+					m.body = append(m.body, strings.TrimRight(lines[k], "\r\n"))
+				}
+			}
+
+			i = end
+		case Endm, Scratch:
+			b.WriteString("\n")
+			w.src = append(w.src, at)
+			werr(errorf(CodeMacro, "%s outside a macro", f[0]))
+		default:
+			var out []string
+			if err := w.invoke(j, macros, &n, 0, &out); err != nil {
+				b.WriteString("\n")
+				w.src = append(w.src, at)
+				werr(err)
+				continue
+			}
+
+			if out == nil {
+				b.WriteString(j)
+				w.src = append(w.src, at)
+				continue
+			}
+
+			for _, k := range out {
+				b.WriteString(k + "\n")
+				w.src = append(w.src, source{at.file, at.line, k})
+			}
+		}
+	}
+
+	return b.Bytes()
+}
+
+// invoke appends to out the lines line expands to if it uses a macro,
+// leaving out nil otherwise. n counts expansions, and depth is the
+// nesting of the macro using line.
+func (w *Writer) invoke(line string, macros map[string]*macro, n *int, depth int, out *[]string) error {
+	f := strings.Fields(stripComment(line))
+
+	k := 0
+	for k < len(f) && strings.HasSuffix(f[k], ":") {
+		k++
+	}
+
+	if k == len(f) {
+		return nil
+	}
+
+	m, ok := macros[f[k]]
+	if !ok {
+		return nil
+	}
+
+	if depth >= maxMacro {
+		return errorf(CodeMacro, "macros nested too deeply")
+	}
+
+	args := f[k+1:]
+	if len(args) != len(m.params) {
+		return errorf(CodeMacro, "wrong number of arguments to macro '%s' (want %d, got %d)", f[k], len(m.params), len(args))
+	}
+
+	if k > 0 {
+		*out = append(*out, strings.Join(f[:k], " "))
+	}
+
+	*n++
+
+	vals := make(map[string]string)
+	for i, j := range m.params {
+		vals[j] = args[i]
+	}
+
+	vals["@"] = strconv.Itoa(*n)
+
+	regs, err := w.scratch(m, args, macros)
+	if err != nil {
+		return err
+	}
+
+	for i, j := range m.scratch {
+		vals[j] = "%" + strconv.Itoa(regs[i])
+		*out = append(*out, fmt.Sprintf("%spush %%%d", Indent, regs[i]))
+	}
+
+	for _, j := range m.body {
+		var bad string
+		s := macroParam.ReplaceAllStringFunc(j, func(p string) string {
+			v, ok := vals[p[1:]]
+			if !ok && bad == "" {
+				bad = p
+			}
+
+			return v
+		})
+
+		if bad != "" {
+			return errorf(CodeMacro, "unknown macro parameter '%s'", bad)
+		}
+
+		var inner []string
+		if err := w.invoke(s, macros, n, depth+1, &inner); err != nil {
+			return err
+		}
+
+		if inner == nil {
+			inner = []string{s}
+		}
+
+		*out = append(*out, inner...)
+	}
+
+	for i := len(regs) - 1; i >= 0; i-- {
+		*out = append(*out, fmt.Sprintf("%spop %%%d", Indent, regs[i]))
+	}
+
+	return nil
+}
+
+// scratch returns a register for each scratch name of m, used with
+// args: the lowest not named by the arguments, the body or the bodies
+// of the macros it uses.
+func (w *Writer) scratch(m *macro, args []string, macros map[string]*macro) ([]int, error) {
+	n := w.opts.Regs
+	if n == 0 {
+		n = NumRegs
+	}
+
+	used := map[int]bool{RegSp: true}
+	for _, j := range args {
+		named(j, used)
+	}
+
+	seen := map[*macro]bool{m: true}
+	todo := []*macro{m}
+
+	for len(todo) > 0 {
+		x := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		for _, j := range x.body {
+			named(j, used)
+
+			for _, k := range strings.Fields(stripComment(j)) {
+				if y, ok := macros[k]; ok && !seen[y] {
+					seen[y] = true
+					todo = append(todo, y)
+				}
+			}
+		}
+	}
+
+	var r []int
+	for _, j := range m.scratch {
+		k := 0
+		for k < n && used[k] {
+			k++
+		}
+
+		if k == n {
+			return nil, errorf(CodeMacro, "no free register for scratch '%s'", j)
+		}
+
+		used[k] = true
+		r = append(r, k)
+	}
+
+	return r, nil
+}
+
+// isEndm reports whether line is an .endm directive.
+func isEndm(line string) bool {
+	f := strings.Fields(stripComment(line))
+	return len(f) > 0 && f[0] == Endm
+}
+
+// named adds the registers named in s to used.
+func named(s string, used map[int]bool) {
+	for _, j := range regName.FindAllStringSubmatch(s, -1) {
+		if r, err := strconv.Atoi(j[1]); err == nil {
+			used[r] = true
+		}
+	}
+}
+
+// stripComment returns line without its '#' comment.
+func stripComment(line string) string {
+	code, _, _ := strings.Cut(line, "#")
+	return code
+}
@@ -18,4 +18,137 @@ const (
 	OpJr
 	OpCall
 	OpExit
+	OpHcall
+	OpYield
+	OpPe
+	OpBeqr
+	OpBner
+	OpBgtr
+	OpBltr
+	OpBr
+	OpCallr
+	OpPush
+	OpPop
+	OpRet
+	OpShl
+	OpShr
+	OpSar
+	OpShli
+	OpShri
+	OpSari
+	OpBcs
+	OpBvs
+	OpBz
+	OpLb
+	OpLbu
+	OpLh
+	OpLhu
+	OpSb
+	OpSh
+	OpCas
+	OpSwap
+	OpClz
+	OpCtz
+	OpPopc
+	OpRol
+	OpRor
+	OpRoli
+	OpRori
+	OpSlt
+	OpSltu
+	OpSeq
+	OpCmov
+	OpG
+	OpEi
+	OpDi
+	OpIret
+	OpSys
+	OpExitr
+	OpExiti
+	OpMtcr
+	OpMfcr
 )
+
+// Effects of instructions on control, as given by Instruction.Flow.
+const (
+	FlowNext   = iota // continues with the next instruction
+	FlowBranch        // continues at its target or with the next instruction
+	FlowJump          // continues at its target, or an address known at run time
+	FlowCall          // continues at its target, to return after the instruction
+	FlowStop          // halts the machine
+)
+
+// inst is the instruction set. Everything that needs to know about an
+// instruction, from the assembler to the disassembler, the cpu and
+// the exported specification, takes it from here. An instruction that
+// also takes an operand has an entry for that form too, keyed by the
+// mnemonic and the operand as written, such as "exit %r"; the parser
+// picks the form and everything else shows the mnemonic alone.
+var inst = map[string]Instruction{
+	"nop":     {Op: OpNop, Params: []int{}},
+	"ld":      {Op: OpLd, Params: []int{Reg, Reg}, Reads: []int{1}, Writes: []int{0}},
+	"lr":      {Op: OpLr, Params: []int{Addr, Reg}, Writes: []int{1}},
+	"st":      {Op: OpSt, Params: []int{Reg, Reg}, Reads: []int{0, 1}},
+	"add":     {Op: OpAdd, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"sub":     {Op: OpSub, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"addi":    {Op: OpAddi, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"subi":    {Op: OpSubi, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"p":       {Op: OpP, Params: []int{Reg}, Reads: []int{0}},
+	"beq":     {Op: OpBeq, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch},
+	"bne":     {Op: OpBne, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch},
+	"bgt":     {Op: OpBgt, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch},
+	"blt":     {Op: OpBlt, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch},
+	"j":       {Op: OpJ, Params: []int{Addr}, Flow: FlowJump},
+	"jr":      {Op: OpJr, Params: []int{Reg}, Reads: []int{0}, Flow: FlowJump},
+	"call":    {Op: OpCall, Params: []int{Addr}, Flow: FlowCall, Stack: true},
+	"exit":    {Op: OpExit, Params: []int{}, Flow: FlowStop},
+	"hcall":   {Op: OpHcall, Params: []int{Addr}},
+	"yield":   {Op: OpYield, Params: []int{}},
+	"pe":      {Op: OpPe, Params: []int{Reg}, Reads: []int{0}},
+	"beqr":    {Op: OpBeqr, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch, Rel: true},
+	"bner":    {Op: OpBner, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch, Rel: true},
+	"bgtr":    {Op: OpBgtr, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch, Rel: true},
+	"bltr":    {Op: OpBltr, Params: []int{Reg, Reg, Addr}, Reads: []int{0, 1}, Flow: FlowBranch, Rel: true},
+	"br":      {Op: OpBr, Params: []int{Addr}, Flow: FlowJump, Rel: true},
+	"callr":   {Op: OpCallr, Params: []int{Addr}, Flow: FlowCall, Rel: true, Stack: true},
+	"push":    {Op: OpPush, Params: []int{Reg}, Reads: []int{0}, Stack: true},
+	"pop":     {Op: OpPop, Params: []int{Reg}, Writes: []int{0}, Stack: true},
+	"ret":     {Op: OpRet, Params: []int{}, Flow: FlowJump, Stack: true},
+	"shl":     {Op: OpShl, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"shr":     {Op: OpShr, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"sar":     {Op: OpSar, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"shli":    {Op: OpShli, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"shri":    {Op: OpShri, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"sari":    {Op: OpSari, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"bcs":     {Op: OpBcs, Params: []int{Addr}, Flow: FlowBranch, TestsFlags: true},
+	"bvs":     {Op: OpBvs, Params: []int{Addr}, Flow: FlowBranch, TestsFlags: true},
+	"bz":      {Op: OpBz, Params: []int{Addr}, Flow: FlowBranch, TestsFlags: true},
+	"lb":      {Op: OpLb, Params: []int{Reg, Reg}, Reads: []int{1}, Writes: []int{0}},
+	"lbu":     {Op: OpLbu, Params: []int{Reg, Reg}, Reads: []int{1}, Writes: []int{0}},
+	"lh":      {Op: OpLh, Params: []int{Reg, Reg}, Reads: []int{1}, Writes: []int{0}},
+	"lhu":     {Op: OpLhu, Params: []int{Reg, Reg}, Reads: []int{1}, Writes: []int{0}},
+	"sb":      {Op: OpSb, Params: []int{Reg, Reg}, Reads: []int{0, 1}},
+	"sh":      {Op: OpSh, Params: []int{Reg, Reg}, Reads: []int{0, 1}},
+	"cas":     {Op: OpCas, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1, 2}, Writes: []int{1}, SetsFlags: true},
+	"swap":    {Op: OpSwap, Params: []int{Reg, Reg}, Reads: []int{0, 1}, Writes: []int{1}},
+	"clz":     {Op: OpClz, Params: []int{Reg, Reg}, Reads: []int{0}, Writes: []int{1}},
+	"ctz":     {Op: OpCtz, Params: []int{Reg, Reg}, Reads: []int{0}, Writes: []int{1}},
+	"popc":    {Op: OpPopc, Params: []int{Reg, Reg}, Reads: []int{0}, Writes: []int{1}},
+	"rol":     {Op: OpRol, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"ror":     {Op: OpRor, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}, SetsFlags: true},
+	"roli":    {Op: OpRoli, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"rori":    {Op: OpRori, Params: []int{Reg, Addr, Reg}, Reads: []int{0}, Writes: []int{2}, SetsFlags: true},
+	"slt":     {Op: OpSlt, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}},
+	"sltu":    {Op: OpSltu, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}},
+	"seq":     {Op: OpSeq, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1}, Writes: []int{2}},
+	"cmov":    {Op: OpCmov, Params: []int{Reg, Reg, Reg}, Reads: []int{0, 1, 2}, Writes: []int{2}},
+	"g":       {Op: OpG, Params: []int{Reg}, Writes: []int{0}},
+	"ei":      {Op: OpEi, Params: []int{}},
+	"di":      {Op: OpDi, Params: []int{}},
+	"iret":    {Op: OpIret, Params: []int{}, Flow: FlowJump, Stack: true, SetsFlags: true},
+	"sys":     {Op: OpSys, Params: []int{Addr}},
+	"exit %r": {Op: OpExitr, Params: []int{Reg}, Reads: []int{0}, Flow: FlowStop},
+	"exit $n": {Op: OpExiti, Params: []int{Addr}, Flow: FlowStop},
+	"mtcr":    {Op: OpMtcr, Params: []int{Reg, Addr}, Reads: []int{0}},
+	"mfcr":    {Op: OpMfcr, Params: []int{Addr, Reg}, Writes: []int{1}},
+}
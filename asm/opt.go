@@ -0,0 +1,174 @@
+package asm
+
+// Optimize applies peephole optimizations to the instructions of p
+// until none apply:
+//
+//   - 'lr $0 %r' becomes the shorter 'sub %r %r %r'
+//   - 'j l' directly followed by the definition of l is removed
+//   - adjacent 'addi %r x %r' and 'addi %r y %r' become
+//     'addi %r x+y %r', and likewise for subi
+//   - 'addi %r $0 %r' and 'subi %r $0 %r' are removed
+//   - unreachable instructions are removed
+//
+// Any other statement between two instructions keeps them apart. The
+// rewrites change the status flags, so programs that test them only
+// have unreachable instructions removed.
+func Optimize(p []Stmt) []Stmt {
+	flags := testsFlags(p)
+
+	for {
+		n, changed := p, false
+		if !flags {
+			n, changed = peephole(p)
+		}
+
+		dead := unreachable(n)
+		p = n[:0:0]
+		for i, j := range n {
+			if dead[i] {
+				changed = true
+				continue
+			}
+
+			p = append(p, j)
+		}
+
+		if !changed {
+			return p
+		}
+	}
+}
+
+// unreachable marks the instructions of p that can never execute:
+// those following a j, jr, br, ret or exit without a label in between.
+func unreachable(p []Stmt) []bool {
+	dead := make([]bool, len(p))
+	flow := true
+
+	for i, j := range p {
+		switch j.Kind {
+		case StmtLabel:
+			flow = true
+		case StmtInst:
+			dead[i] = !flow
+
+			if f, ok := inst[j.Name]; ok && (f.Flow == FlowJump || f.Flow == FlowStop) {
+				flow = false
+			}
+		}
+	}
+
+	return dead
+}
+
+func peephole(p []Stmt) ([]Stmt, bool) {
+	var r []Stmt
+	changed := false
+
+	for i := 0; i < len(p); i++ {
+		s := p[i]
+
+		if s.Kind != StmtInst || !plain(s) {
+			r = append(r, s)
+			continue
+		}
+
+		switch s.Name {
+		case "lr":
+			if isZero(s.Args[0].Expr) {
+				d := s.Args[1]
+				r = append(r, Stmt{Kind: StmtInst, Name: "sub", Line: s.Line, Col: s.Col, Args: []Operand{d, d, d}})
+				changed = true
+				continue
+			}
+		case "j":
+			if e := s.Args[0].Expr; e.Op == 0 && e.Name != "" && e.Name != Here && labelNext(p[i+1:], e.Name) {
+				changed = true
+				continue
+			}
+		case "addi", "subi":
+			if !sameReg(s.Args[0], s.Args[2]) {
+				break
+			}
+
+			if isZero(s.Args[1].Expr) {
+				changed = true
+				continue
+			}
+
+			if i+1 < len(p) {
+				t := p[i+1]
+				if t.Kind == StmtInst && t.Name == s.Name && plain(t) && sameReg(t.Args[0], s.Args[0]) && sameReg(t.Args[2], s.Args[0]) {
+					a := s.Args[1]
+					a.Expr = &Expr{Op: '+', X: s.Args[1].Expr, Y: t.Args[1].Expr}
+					r = append(r, Stmt{Kind: StmtInst, Name: s.Name, Line: s.Line, Col: s.Col, Args: []Operand{s.Args[0], a, s.Args[2]}})
+					changed = true
+					i++
+					continue
+				}
+			}
+		}
+
+		r = append(r, s)
+	}
+
+	return r, changed
+}
+
+// plain reports whether s has register operands written as registers
+// and immediates that do not use the location counter, which would
+// change meaning if the code moved.
+func plain(s Stmt) bool {
+	for _, j := range s.Args {
+		if j.Kind == Reg && j.Sym.Type != Reg {
+			return false
+		}
+
+		if j.Kind == Addr && j.Expr.uses(Here) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// testsFlags reports whether an instruction of p reads the status
+// flags.
+func testsFlags(p []Stmt) bool {
+	for _, j := range p {
+		if j.Kind == StmtInst && inst[j.Name].TestsFlags {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameReg(a, b Operand) bool {
+	return a.Kind == Reg && b.Kind == Reg && a.Sym.Val == b.Sym.Val
+}
+
+func isZero(e *Expr) bool {
+	if !e.Const() {
+		return false
+	}
+
+	v, err := e.eval(nil)
+	return err == nil && v == 0
+}
+
+// labelNext reports whether name is defined by one of the labels at
+// the start of p.
+func labelNext(p []Stmt, name string) bool {
+	for _, j := range p {
+		if j.Kind != StmtLabel {
+			return false
+		}
+
+		if j.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
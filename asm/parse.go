@@ -0,0 +1,334 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Statement kinds.
+const (
+	StmtLabel = iota
+	StmtInst
+	StmtDirective
+	StmtAssign
+)
+
+// Operand is an instruction operand of kind Reg or Addr, or the Str
+// or Id naming the file of an .include. Sym is the register for Reg
+// operands, the file name for includes, and the first symbol of the
+// expression Expr for immediates.
+type Operand struct {
+	Kind int
+	Sym  Symbol
+	Expr *Expr
+}
+
+// Stmt is a single statement: a label definition, an instruction, a
+// directive with its expressions or an assignment 'Name = Exprs[0]'.
+// Line and Col give the position of Name.
+type Stmt struct {
+	Kind  int
+	Name  string
+	Line  int
+	Col   int
+	Args  []Operand
+	Exprs []*Expr
+}
+
+// Include is the directive replaced by the source of the file it
+// names. Writer expands includes before parsing, so only Parse returns
+// them, as directives with the file name as their one operand.
+const Include = ".include"
+
+// Program is a parsed source file.
+type Program struct {
+	Stmts []Stmt
+}
+
+// Parse reads the program in r without encoding it, returning every
+// error found. Statements with errors are left out of the program.
+// Macros are expanded, with the statements of an expansion on the line
+// using the macro.
+func Parse(r io.Reader) (*Program, []Diagnostic) {
+	var d []Diagnostic
+
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return &Program{}, []Diagnostic{{Msg: err.Error()}}
+	}
+
+	w := NewWriter(io.Discard)
+	for i, j := range strings.Split(string(src), "\n") {
+		w.src = append(w.src, source{"", i + 1, strings.TrimRight(j, "\r")})
+	}
+
+	report := w.locate(func(x Diagnostic) {
+		d = append(d, x)
+	})
+
+	src = w.expand(src, func(err error) {
+		report(Diagnostic{Line: len(w.src), Code: Code(err), Msg: err.Error()})
+	})
+
+	werr := func(s Symbol, err error) {
+		report(Diagnostic{Line: s.Line, Col: s.Col, Code: Code(err), Msg: err.Error()})
+	}
+
+	rd := NewReader(lex(NewLexer(bytes.NewReader(src)), werr))
+	rd.opts.Strict = true
+
+	p := parse(rd, werr)
+	for i := range p {
+		p[i].Line = w.where(p[i].Line).line
+	}
+
+	return &Program{p}, d
+}
+
+// lex reads every symbol from l, passing each error to werr.
+func lex(l *Lexer, werr func(Symbol, error)) (sym []Symbol) {
+	for {
+		s, err := l.Read()
+
+		if err != nil {
+			werr(s, err)
+		}
+
+		if s.Type != -1 {
+			sym = append(sym, s)
+		}
+
+		if s.Type == Eof {
+			return sym
+		}
+	}
+}
+
+// parse reads the statements of a program from r, passing each error
+// to werr.
+func parse(r *Reader, werr func(Symbol, error)) (p []Stmt) {
+	for {
+		if n := r.Peek(); n.Type == Punct && n.Val == ";" {
+			r.Read()
+			continue
+		}
+
+		s, err := r.Expect(Id)
+
+		if s.Type == Eof {
+			break
+		}
+
+		if err != nil {
+			werr(s, err)
+			continue
+		}
+
+		if s.Type == Label {
+			p = append(p, Stmt{Kind: StmtLabel, Name: s.Val, Line: s.Line, Col: s.Col})
+			continue
+		}
+
+		name := r.keyword(s.Val)
+
+		if name == Include {
+			f := r.Peek()
+			if f.Type != Str && f.Type != Id {
+				werr(f, errorf(CodeUnexpected, "expected file name got '%s'", f.Val))
+				continue
+			}
+
+			r.Read()
+			p = append(p, Stmt{Kind: StmtDirective, Name: name, Line: s.Line, Col: s.Col, Args: []Operand{{Kind: f.Type, Sym: f}}})
+			continue
+		}
+
+		if d, ok := directives[name]; ok {
+			e, err := r.exprs(d.args)
+			if err != nil {
+				werr(s, err)
+				continue
+			}
+
+			p = append(p, Stmt{Kind: StmtDirective, Name: name, Line: s.Line, Col: s.Col, Exprs: e})
+			continue
+		}
+
+		if n := r.Peek(); n.Type == Punct && n.Val == "=" && name != LoadConst {
+			r.Read()
+
+			e, err := r.Expr()
+			if err != nil {
+				werr(s, err)
+				continue
+			}
+
+			p = append(p, Stmt{Kind: StmtAssign, Name: s.Val, Line: s.Line, Col: s.Col, Exprs: []*Expr{e}})
+			continue
+		}
+
+		name = r.form(name, s.Line)
+
+		f, ok := inst[name]
+		if name == LoadConst {
+			f, ok = Instruction{Params: []int{Addr, Reg}}, true
+		}
+
+		if !ok {
+			werr(s, errorf(CodeBadInst, "bad instruction '%s'", s.Val))
+			continue
+		}
+
+		st := Stmt{Kind: StmtInst, Name: name, Line: s.Line, Col: s.Col}
+		bad := false
+
+		for i, t := range f.Params {
+			if name == LoadConst && i == 0 {
+				if p := r.Peek(); p.Type == Punct && p.Val == "=" {
+					r.Read()
+				} else {
+					werr(p, errorf(CodeUnexpected, "expected '=' got '%s'", p.Val))
+					bad = true
+				}
+			}
+
+			if t == Addr {
+				n := r.Peek()
+
+				e, err := r.Expr()
+				if err != nil {
+					werr(n, err)
+					bad = true
+					continue
+				}
+
+				st.Args = append(st.Args, Operand{Kind: Addr, Sym: n, Expr: e})
+				continue
+			}
+
+			sym, err := r.Expect(t)
+			if err != nil {
+				werr(sym, err)
+				bad = true
+				continue
+			}
+
+			st.Args = append(st.Args, Operand{Kind: t, Sym: sym})
+		}
+
+		if !bad {
+			p = append(p, st)
+		}
+	}
+
+	return p
+}
+
+// form returns the key in inst of the operand form of the instruction
+// name written on line, which is name itself unless an operand follows
+// on the same line and name has a form taking it.
+func (r *Reader) form(name string, line int) string {
+	n := r.Peek()
+	if n.Line != line || n.Type == Eof || n.Type == Label || n.Type == Punct && n.Val == ";" {
+		return name
+	}
+
+	k := name + " $n"
+	if n.Type == Reg {
+		k = name + " %r"
+	}
+
+	if _, ok := inst[k]; ok {
+		return k
+	}
+
+	return name
+}
+
+// keyword returns the instruction or directive name spells when case
+// is folded, or name unchanged.
+func (r *Reader) keyword(name string) string {
+	if !r.opts.FoldCase {
+		return name
+	}
+
+	l := strings.ToLower(name)
+	if _, ok := inst[l]; ok {
+		return l
+	}
+
+	if _, ok := directives[l]; ok {
+		return l
+	}
+
+	return name
+}
+
+// WriteStmt encodes s.
+func (w *Writer) WriteStmt(s Stmt) error {
+	switch s.Kind {
+	case StmtLabel:
+		return w.WriteSymbol(Symbol{Label, s.Name, s.Line, s.Col})
+	case StmtAssign:
+		if err := w.Define(s.Name, s.Exprs[0]); err != nil {
+			return err
+		}
+
+		w.labl[s.Name] = s.Line
+		return nil
+	case StmtDirective:
+		if s.Name == Include {
+			return errorf(CodeInclude, ".include of '%s' not expanded", s.Args[0].Sym.Val)
+		}
+
+		w.here = w.pc
+		w.pos = Symbol{Line: s.Line, Col: s.Col}
+		return directives[s.Name].do(w, s.Exprs)
+	}
+
+	if s.Name == LoadConst {
+		return w.loadConst(s)
+	}
+
+	if err := w.WriteSymbol(Symbol{Id, s.Name, s.Line, s.Col}); err != nil {
+		return err
+	}
+
+	rel := Relative(inst[s.Name].Op)
+
+	for i, j := range s.Args {
+		var err error
+		if j.Kind == Addr {
+			e := j.Expr
+			if rel && i == len(s.Args)-1 {
+				e = &Expr{Op: '-', X: e, Y: &Expr{Name: Here}}
+			}
+
+			w.pos = j.Sym
+			err = w.WriteExpr(e)
+		} else {
+			err = w.WriteSymbol(j.Sym)
+		}
+
+		if err != nil {
+			return &operandError{j.Sym, err}
+		}
+	}
+
+	return nil
+}
+
+// operandError is an error in encoding the operand starting at Sym.
+type operandError struct {
+	Sym Symbol
+	Err error
+}
+
+func (e *operandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *operandError) Unwrap() error {
+	return e.Err
+}
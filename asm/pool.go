@@ -0,0 +1,62 @@
+package asm
+
+import "strconv"
+
+// LoadConst is the pseudo instruction 'lc =x %r', which loads the
+// constant x from a literal pool. It assembles to 'lr p %r; ld %r %r'
+// where p is the address of x in the next pool: the one placed by the
+// following .pool directive, which must be in a data section, or else
+// one placed after every other data section.
+const LoadConst = "lc"
+
+// literal returns the name of the pool slot of the nth literal. Names
+// start with '=', so they cannot clash with labels.
+func literal(n int) string {
+	return "=" + strconv.Itoa(n)
+}
+
+// loadConst encodes the lc statement s.
+func (w *Writer) loadConst(s Stmt) error {
+	name := literal(w.nlit + len(w.pool))
+	w.pool = append(w.pool, s.Args[0].Expr.at(w.pc))
+
+	r := s.Args[1]
+	lr := Stmt{Kind: StmtInst, Name: "lr", Line: s.Line, Col: s.Col, Args: []Operand{
+		{Kind: Addr, Sym: s.Args[0].Sym, Expr: &Expr{Name: name}}, r,
+	}}
+
+	if err := w.WriteStmt(lr); err != nil {
+		return err
+	}
+
+	return w.WriteStmt(Stmt{Kind: StmtInst, Name: "ld", Line: s.Line, Col: s.Col, Args: []Operand{r, r}})
+}
+
+// flushPool places the pending literals at the current address.
+func (w *Writer) flushPool() error {
+	if w.cur.kind != SectData {
+		return errorf(CodePool, "literal pool outside .data")
+	}
+
+	for _, j := range w.pool {
+		w.equ[literal(w.nlit)] = &Expr{Val: w.pc}
+		w.nlit++
+
+		if err := w.writeValue(j, 4); err != nil {
+			return err
+		}
+	}
+
+	w.pool = nil
+	return nil
+}
+
+// endPool places any pending literals in a data section of their own.
+func (w *Writer) endPool() error {
+	if len(w.pool) == 0 {
+		return nil
+	}
+
+	w.enter(SectData)
+	return w.flushPool()
+}
@@ -0,0 +1,162 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// Section kinds. Text is the code; data and bss are laid out in
+// memory by the loader.
+const (
+	SectText = iota
+	SectData
+	SectBss
+)
+
+var sectNames = []string{".text", ".data", ".bss"}
+
+// Limits of the address spaces. Data and bss sections must fit in the
+// MemSize bytes of memory; code lives in an address space of its own,
+// bounded by MaxCode.
+const (
+	MemSize = 8192
+	MaxCode = 1 << 24
+)
+
+type section struct {
+	kind int
+	addr uint32
+	buf  bytes.Buffer
+}
+
+// fixup is a value of n bytes at off in s that is patched by Write.
+// pos is where the value appears in the source.
+type fixup struct {
+	s   *section
+	off int
+	n   int
+	pos Symbol
+}
+
+func (s *section) end() uint32 {
+	return s.addr + uint32(s.buf.Len())
+}
+
+// grow checks that the current section has room for n more bytes.
+func (w *Writer) grow(n uint32) error {
+	limit := uint32(MemSize)
+	if w.cur == w.text {
+		limit = MaxCode
+	}
+
+	if w.pc > limit || n > limit-w.pc {
+		return errorf(CodeTooBig, "%s at %08x grows past %08x", sectNames[w.cur.kind], w.pc, limit)
+	}
+
+	return nil
+}
+
+// use makes s the current section.
+func (w *Writer) use(s *section) {
+	w.cur = s
+	w.buf = &s.buf
+	w.pc = s.end()
+}
+
+// enter switches to the most recent section of kind if nothing has
+// been placed after it, and otherwise starts a new one after every
+// other data and bss section.
+func (w *Writer) enter(kind int) {
+	if kind == SectText {
+		w.use(w.text)
+		return
+	}
+
+	for i := len(w.sects) - 1; i >= 0; i-- {
+		if s := w.sects[i]; s.kind == kind {
+			if s.end() == w.top() {
+				w.use(s)
+				return
+			}
+
+			break
+		}
+	}
+
+	w.org(kind, w.top())
+}
+
+// org starts a new section of kind at addr.
+func (w *Writer) org(kind int, addr uint32) {
+	s := &section{kind: kind, addr: addr}
+	w.sects = append(w.sects, s)
+	w.use(s)
+}
+
+// top returns the end of the highest data or bss section, or where
+// the first goes if there is none.
+func (w *Writer) top() uint32 {
+	t := w.dbase
+	for _, j := range w.sects {
+		if e := j.end(); e > t {
+			t = e
+		}
+	}
+
+	return t
+}
+
+// sections returns the data and bss sections sorted by address.
+func (w *Writer) sections() ([]Section, error) {
+	var r []Section
+
+	for _, j := range w.sects {
+		if j.buf.Len() == 0 {
+			continue
+		}
+
+		if j.addr > MemSize || uint32(j.buf.Len()) > MemSize-j.addr {
+			return nil, errorf(CodeTooBig, "%s at %08x ends past %08x", sectNames[j.kind], j.addr, MemSize)
+		}
+
+		s := Section{Kind: j.kind, Addr: j.addr, Size: uint32(j.buf.Len())}
+		if j.kind == SectData {
+			s.Data = j.buf.Bytes()
+		}
+
+		r = append(r, s)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Addr < r[j].Addr
+	})
+
+	for i := 1; i < len(r); i++ {
+		if r[i-1].Addr+r[i-1].Size > r[i].Addr {
+			return nil, errorf(CodeOverlap, "%s at %08x overlaps %s at %08x",
+				sectNames[r[i].Kind], r[i].Addr, sectNames[r[i-1].Kind], r[i-1].Addr)
+		}
+	}
+
+	return r, nil
+}
+
+// codeSize returns the size of the code of prog, rounded up to a word,
+// by encoding it with a scratch Writer. Labels do not change the size
+// of instructions, so it is the size of the code w writes.
+func (w *Writer) codeSize(prog []Stmt) uint32 {
+	s := NewWriter(io.Discard)
+	s.opts = w.opts
+	s.opts.VonNeumann = false
+
+	for k, v := range w.equ {
+		s.equ[k] = v
+	}
+
+	for _, j := range prog {
+		s.WriteStmt(j)
+	}
+
+	return (uint32(s.text.buf.Len()) + 3) &^ 3
+}
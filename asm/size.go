@@ -0,0 +1,113 @@
+package asm
+
+import (
+	"sort"
+)
+
+// SymbolSize is the extent of a label: the bytes from its address up
+// to the next label or the end of its section. Used reports whether
+// any expression refers to the label or execution starts there.
+type SymbolSize struct {
+	Name string
+	Kind int
+	Addr uint32
+	Size uint32
+	Used bool
+}
+
+// Sizes returns the extent of every label, ordered by section kind
+// and address. It is only meaningful once the whole program has been
+// written.
+func (w *Writer) Sizes() []SymbolSize {
+	var r []SymbolSize
+
+	var entry uint32
+	if w.entry != nil {
+		entry, _ = w.entry.eval(w.Value)
+	}
+
+	for k, v := range w.lab {
+		s := SymbolSize{Name: k, Kind: w.labk[k], Addr: v}
+		s.Used = len(w.refs[k]) > 0 || s.Kind == SectText && v == entry
+
+		if sect := w.sectionAt(s.Kind, v); sect != nil {
+			s.Size = sect.end() - v
+
+			for k2, v2 := range w.lab {
+				if k2 != k && w.labk[k2] == s.Kind && v2 > v && v2-v < s.Size {
+					s.Size = v2 - v
+				}
+			}
+		}
+
+		r = append(r, s)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		a, b := r[i], r[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+
+		return a.Addr < b.Addr || a.Addr == b.Addr && a.Name < b.Name
+	})
+
+	return r
+}
+
+// sectionAt returns the section of kind holding addr.
+func (w *Writer) sectionAt(kind int, addr uint32) *section {
+	if kind == SectText {
+		return w.text
+	}
+
+	for i := len(w.sects) - 1; i >= 0; i-- {
+		if s := w.sects[i]; s.kind == kind && s.addr <= addr && addr <= s.end() {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// reference notes every name used by the expressions of p, with the
+// line using it.
+func (w *Writer) reference(p []Stmt) {
+	var line int
+
+	use := func(name string) {
+		if r := w.refs[name]; len(r) == 0 || r[len(r)-1] != line {
+			w.refs[name] = append(r, line)
+		}
+	}
+
+	var walk func(e *Expr)
+	walk = func(e *Expr) {
+		if e == nil {
+			return
+		}
+
+		if e.Name != "" {
+			use(e.Name)
+		}
+
+		walk(e.X)
+		walk(e.Y)
+	}
+
+	for _, j := range p {
+		line = j.Line
+
+		for _, k := range j.Args {
+			if k.Kind == Reg && k.Sym.Type == Id {
+				use(k.Sym.Val)
+			}
+
+			walk(k.Expr)
+		}
+
+		for _, k := range j.Exprs {
+			walk(k)
+		}
+	}
+}
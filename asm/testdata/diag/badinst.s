@@ -0,0 +1 @@
+	frob %1
@@ -0,0 +1 @@
+	lr $zz %1
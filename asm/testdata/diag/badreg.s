@@ -0,0 +1 @@
+	p %x
@@ -0,0 +1 @@
+	p r1
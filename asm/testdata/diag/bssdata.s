@@ -0,0 +1,2 @@
+.bss
+.word $1
@@ -0,0 +1,3 @@
+A = B
+B = A
+	lr A %0
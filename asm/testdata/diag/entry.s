@@ -0,0 +1,2 @@
+.entry nowhere
+	nop
@@ -0,0 +1,9 @@
+.data
+.byte later
+.word later
+.byte $1, early, later
+.text
+early:
+	.space $100
+later:
+	nop
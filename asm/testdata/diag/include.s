@@ -0,0 +1,2 @@
+.include "missing.s"
+	nop
@@ -0,0 +1,9 @@
+.macro inc r
+    addi \r $1 \x
+.endm
+    inc %1 %2
+    inc %1
+	nop
+.endm
+.macro open
+	nop
@@ -0,0 +1,4 @@
+.macro bad r
+    frob \r
+.endm
+	bad %1
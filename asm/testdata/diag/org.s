@@ -0,0 +1,4 @@
+	nop
+	nop
+.org $0
+	nop
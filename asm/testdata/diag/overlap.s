@@ -0,0 +1,8 @@
+.data
+.org $10
+.word $1
+.word $2
+.org $14
+.word $3
+.text
+	nop
@@ -0,0 +1,2 @@
+	.pool
+	nop
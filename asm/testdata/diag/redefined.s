@@ -0,0 +1,4 @@
+a:
+	nop
+a:
+	nop
@@ -0,0 +1 @@
+	p %9
@@ -0,0 +1,5 @@
+.macro many a
+.scratch t0 t1 t2 t3 t4 t5 t6
+    addi \a $1 \t0
+.endm
+    many %0
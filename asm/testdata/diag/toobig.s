@@ -0,0 +1,4 @@
+.data
+.space $3000
+.text
+	nop
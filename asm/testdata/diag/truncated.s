@@ -0,0 +1,4 @@
+.data
+.byte $100
+.text
+	nop
@@ -0,0 +1 @@
+	j nowhere
@@ -0,0 +1 @@
+	lr %1 %2
@@ -0,0 +1,2 @@
+	exit
+	nop
@@ -0,0 +1,44 @@
+package asm
+
+import "sort"
+
+// Pos is a line of a source file. File is empty for the file being
+// assembled.
+type Pos struct {
+	File string
+	Line int
+}
+
+// Xref is the cross reference of a label or constant: the line that
+// defines it and the lines that refer to it, in order.
+type Xref struct {
+	Name string
+	Def  Pos
+	Refs []Pos
+}
+
+// Xrefs returns the cross reference of every label and constant, by
+// name. It is only meaningful once the whole program has been written.
+func (w *Writer) Xrefs() []Xref {
+	var r []Xref
+
+	for k, v := range w.labl {
+		refs := append([]int(nil), w.refs[k]...)
+		sort.Ints(refs)
+
+		x := Xref{Name: k, Def: w.origin(v)}
+		for i, j := range refs {
+			if i == 0 || j != refs[i-1] {
+				x.Refs = append(x.Refs, w.origin(j))
+			}
+		}
+
+		r = append(r, x)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+
+	return r
+}
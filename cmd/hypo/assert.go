@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rtcall/hypo/cpu"
+)
+
+// An assertFile gives the expected final state of a run, as JSON:
+//
+//	{
+//		"regs":   {"0": 1, "3": 42},
+//		"mem":    {"0x100": 7},
+//		"output": "^hello",
+//		"exit":   3,
+//		"fault":  "out of bounds"
+//	}
+//
+// Register numbers are decimal and addresses may be in any base Go
+// accepts. Output and fault are regular expressions; without fault
+// the program must exit normally, with status exit if given. Every
+// field is optional.
+type assertFile struct {
+	Regs   map[string]uint32 `json:"regs"`
+	Mem    map[string]uint32 `json:"mem"`
+	Output *string           `json:"output"`
+	Exit   *uint32           `json:"exit"`
+	Fault  *string           `json:"fault"`
+
+	regs   map[int]uint32
+	mem    map[uint32]uint32
+	output *regexp.Regexp
+	fault  *regexp.Regexp
+}
+
+// isAssertFile reports whether the -assert value s names a file
+// rather than being a list of terms, which always contain '='.
+func isAssertFile(s string) bool {
+	return s != "" && !strings.Contains(s, "=")
+}
+
+// loadAsserts reads the assertion file path for a machine of nreg
+// registers.
+func loadAsserts(path string, nreg int) (*assertFile, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &assertFile{regs: make(map[int]uint32), mem: make(map[uint32]uint32)}
+	if err := json.Unmarshal(buf, a); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for k, v := range a.Regs {
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 0 || n >= nreg {
+			return nil, fmt.Errorf("%s: bad register '%s'", path, k)
+		}
+
+		a.regs[n] = v
+	}
+
+	for k, v := range a.Mem {
+		n, err := strconv.ParseUint(k, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad address '%s'", path, k)
+		}
+
+		a.mem[uint32(n)] = v
+	}
+
+	if a.Output != nil {
+		if a.output, err = regexp.Compile(*a.Output); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if a.Fault != nil {
+		if a.fault, err = regexp.Compile(*a.Fault); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	return a, nil
+}
+
+// guest returns the register and memory checks of a, as used by
+// -check.
+func (a *assertFile) guest() func(cpu.Guest) error {
+	return func(g cpu.Guest) error {
+		if d := a.state(g); len(d) > 0 {
+			return fmt.Errorf("%s", d[0])
+		}
+
+		return nil
+	}
+}
+
+// state returns a line for each register or word of memory that does
+// not hold the expected value.
+func (a *assertFile) state(g cpu.Guest) []string {
+	var d []string
+
+	regs := make([]int, 0, len(a.regs))
+	for k := range a.regs {
+		regs = append(regs, k)
+	}
+
+	sort.Ints(regs)
+
+	for _, j := range regs {
+		v, err := g.Reg(j)
+		if err != nil {
+			d = append(d, fmt.Sprintf("%%%d: %s", j, err))
+		} else if v != a.regs[j] {
+			d = append(d, fmt.Sprintf("%%%d: got %d, want %d", j, v, a.regs[j]))
+		}
+	}
+
+	addrs := make([]uint32, 0, len(a.mem))
+	for k := range a.mem {
+		addrs = append(addrs, k)
+	}
+
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i] < addrs[j]
+	})
+
+	for _, j := range addrs {
+		v, err := g.Load(j)
+		if err != nil {
+			d = append(d, fmt.Sprintf("%08x: %s", j, err))
+		} else if v != a.mem[j] {
+			d = append(d, fmt.Sprintf("%08x: got %d, want %d", j, v, a.mem[j]))
+		}
+	}
+
+	return d
+}
+
+// diff returns a line for every way the finished run of c, which
+// ended with fault and printed out, differs from a.
+func (a *assertFile) diff(c *cpu.Cpu, fault error, out string) []string {
+	var d []string
+
+	switch {
+	case a.fault == nil && fault != nil:
+		d = append(d, fmt.Sprintf("fault: got %q, want exit", fault))
+	case a.fault != nil && fault == nil:
+		d = append(d, fmt.Sprintf("fault: got exit, want match of %q", *a.Fault))
+	case a.fault != nil && !a.fault.MatchString(fault.Error()):
+		d = append(d, fmt.Sprintf("fault: got %q, want match of %q", fault, *a.Fault))
+	case a.Exit != nil && fault == nil && c.ExitCode() != *a.Exit:
+		d = append(d, fmt.Sprintf("exit: got status %d, want %d", c.ExitCode(), *a.Exit))
+	}
+
+	if a.output != nil && !a.output.MatchString(out) {
+		d = append(d, fmt.Sprintf("output: got %q, want match of %q", out, *a.Output))
+	}
+
+	return append(d, a.state(c.Guest())...)
+}
+
+// runAsserts runs c until it exits or faults and returns how the
+// result differs from a, given the buffer collecting guest output.
+func runAsserts(c *cpu.Cpu, a *assertFile, out *bytes.Buffer) []string {
+	var fault error
+
+	for c.State() && fault == nil {
+		fault = c.Step()
+	}
+
+	return a.diff(c, fault, out.String())
+}
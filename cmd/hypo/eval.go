@@ -0,0 +1,780 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A value is an int64, string, bool, nil for None, *list, *function
+// or *builtin.
+type value interface{}
+
+type list struct {
+	elems []value
+}
+
+type function struct {
+	name   string
+	params []string
+	body   []stmt
+	env    *env
+}
+
+// builtin is a function provided by the host.
+type builtin struct {
+	name string
+	fn   func(args []value) (value, error)
+}
+
+// env holds the variables of a module or function call, looking names
+// up in the enclosing environment if not found.
+type env struct {
+	vars map[string]value
+	up   *env
+}
+
+func (e *env) get(name string) (value, bool) {
+	for ; e != nil; e = e.up {
+		if v, ok := e.vars[name]; ok {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// maxDepth bounds the nesting of function calls.
+const maxDepth = 200
+
+// interp runs programs, printing to out.
+type interp struct {
+	globals *env
+	out     io.Writer
+	depth   int
+}
+
+func newInterp(out io.Writer) *interp {
+	in := &interp{globals: &env{vars: make(map[string]value)}, out: out}
+
+	in.define("print", func(args []value) (value, error) {
+		s := make([]string, len(args))
+		for i, j := range args {
+			s[i] = str(j)
+		}
+
+		fmt.Fprintln(in.out, strings.Join(s, " "))
+		return nil, nil
+	})
+
+	in.define("len", func(args []value) (value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len takes 1 argument")
+		}
+
+		switch x := args[0].(type) {
+		case string:
+			return int64(len(x)), nil
+		case *list:
+			return int64(len(x.elems)), nil
+		}
+
+		return nil, fmt.Errorf("len of %s", typeName(args[0]))
+	})
+
+	in.define("range", func(args []value) (value, error) {
+		n, err := ints(args)
+		if err != nil || len(n) < 1 || len(n) > 3 {
+			return nil, fmt.Errorf("range takes 1 to 3 ints")
+		}
+
+		lo, hi, step := int64(0), n[0], int64(1)
+		if len(n) > 1 {
+			lo, hi = n[0], n[1]
+		}
+
+		if len(n) > 2 {
+			step = n[2]
+		}
+
+		if step == 0 {
+			return nil, fmt.Errorf("range step is zero")
+		}
+
+		l := &list{}
+		for i := lo; step > 0 && i < hi || step < 0 && i > hi; i += step {
+			l.elems = append(l.elems, i)
+		}
+
+		return l, nil
+	})
+
+	in.define("str", func(args []value) (value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("str takes 1 argument")
+		}
+
+		return str(args[0]), nil
+	})
+
+	in.define("int", func(args []value) (value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("int takes 1 argument")
+		}
+
+		switch x := args[0].(type) {
+		case int64:
+			return x, nil
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+
+			return int64(0), nil
+		case string:
+			v, err := strconv.ParseInt(x, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad int %q", x)
+			}
+
+			return v, nil
+		}
+
+		return nil, fmt.Errorf("int of %s", typeName(args[0]))
+	})
+
+	in.define("hex", func(args []value) (value, error) {
+		n, err := ints(args)
+		if err != nil || len(n) != 1 {
+			return nil, fmt.Errorf("hex takes 1 int")
+		}
+
+		if n[0] < 0 {
+			return fmt.Sprintf("-0x%x", -n[0]), nil
+		}
+
+		return fmt.Sprintf("0x%x", n[0]), nil
+	})
+
+	in.define("fail", func(args []value) (value, error) {
+		s := make([]string, len(args))
+		for i, j := range args {
+			s[i] = str(j)
+		}
+
+		return nil, errors.New(strings.Join(s, " "))
+	})
+
+	return in
+}
+
+// define makes fn a global function called name.
+func (in *interp) define(name string, fn func(args []value) (value, error)) {
+	in.globals.vars[name] = &builtin{name, fn}
+}
+
+// run parses and executes src.
+func (in *interp) run(src string) error {
+	prog, err := parse(src)
+	if err != nil {
+		return err
+	}
+
+	// A return at the top level ends the script.
+	ctl, _, err := in.block(prog, in.globals)
+	if err == nil && (ctl == ctlBreak || ctl == ctlContinue) {
+		err = fmt.Errorf("%s outside loop", ctlWords[ctl])
+	}
+
+	return err
+}
+
+// How a block of statements ended.
+const (
+	ctlNone = iota
+	ctlBreak
+	ctlContinue
+	ctlReturn
+)
+
+var ctlWords = []string{"", "break", "continue", "return"}
+
+// lineError is an error raised at a line of the script.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("%d: %s", e.line, e.err)
+}
+
+// at attributes err to line unless it already has a line.
+func at(line int, err error) error {
+	var le *lineError
+	if err == nil || errors.As(err, &le) {
+		return err
+	}
+
+	return &lineError{line, err}
+}
+
+func (in *interp) block(body []stmt, e *env) (int, value, error) {
+	for _, j := range body {
+		if ctl, v, err := in.stmt(j, e); err != nil || ctl != ctlNone {
+			return ctl, v, err
+		}
+	}
+
+	return ctlNone, nil, nil
+}
+
+func (in *interp) stmt(s stmt, e *env) (int, value, error) {
+	switch s := s.(type) {
+	case *exprStmt:
+		_, err := in.eval(s.x, e)
+		return ctlNone, nil, at(s.line, err)
+	case *assignStmt:
+		return ctlNone, nil, at(s.line, in.assign(s, e))
+	case *ifStmt:
+		for i, j := range s.conds {
+			v, err := in.eval(j, e)
+			if err != nil {
+				return ctlNone, nil, at(s.line, err)
+			}
+
+			if truth(v) {
+				return in.block(s.bodies[i], e)
+			}
+		}
+
+		return in.block(s.els, e)
+	case *whileStmt:
+		for {
+			v, err := in.eval(s.cond, e)
+			if err != nil {
+				return ctlNone, nil, at(s.line, err)
+			}
+
+			if !truth(v) {
+				return ctlNone, nil, nil
+			}
+
+			ctl, r, err := in.block(s.body, e)
+			if err != nil || ctl == ctlReturn {
+				return ctl, r, err
+			}
+
+			if ctl == ctlBreak {
+				return ctlNone, nil, nil
+			}
+		}
+	case *forStmt:
+		v, err := in.eval(s.x, e)
+		if err != nil {
+			return ctlNone, nil, at(s.line, err)
+		}
+
+		var elems []value
+		switch x := v.(type) {
+		case *list:
+			elems = append(elems, x.elems...)
+		case string:
+			for i := range x {
+				elems = append(elems, x[i:i+1])
+			}
+		default:
+			return ctlNone, nil, at(s.line, fmt.Errorf("cannot loop over %s", typeName(v)))
+		}
+
+		for _, j := range elems {
+			e.vars[s.name] = j
+
+			ctl, r, err := in.block(s.body, e)
+			if err != nil || ctl == ctlReturn {
+				return ctl, r, err
+			}
+
+			if ctl == ctlBreak {
+				break
+			}
+		}
+
+		return ctlNone, nil, nil
+	case *defStmt:
+		e.vars[s.name] = &function{s.name, s.params, s.body, e}
+		return ctlNone, nil, nil
+	case *returnStmt:
+		var v value
+		if s.x != nil {
+			var err error
+			if v, err = in.eval(s.x, e); err != nil {
+				return ctlNone, nil, at(s.line, err)
+			}
+		}
+
+		return ctlReturn, v, nil
+	case *assertStmt:
+		v, err := in.eval(s.x, e)
+		if err != nil || truth(v) {
+			return ctlNone, nil, at(s.line, err)
+		}
+
+		msg := "assertion failed"
+		if s.msg != nil {
+			m, err := in.eval(s.msg, e)
+			if err != nil {
+				return ctlNone, nil, at(s.line, err)
+			}
+
+			msg += ": " + str(m)
+		}
+
+		return ctlNone, nil, at(s.line, errors.New(msg))
+	case *ctlStmt:
+		switch s.word {
+		case "break":
+			return ctlBreak, nil, nil
+		case "continue":
+			return ctlContinue, nil, nil
+		}
+
+		return ctlNone, nil, nil
+	}
+
+	panic("unknown statement")
+}
+
+func (in *interp) assign(s *assignStmt, e *env) error {
+	v, err := in.eval(s.x, e)
+	if err != nil {
+		return err
+	}
+
+	if s.op != "=" {
+		old, err := in.eval(s.target, e)
+		if err != nil {
+			return err
+		}
+
+		// As in Starlark, += extends a list in place.
+		if l, ok := old.(*list); ok && s.op == "+=" {
+			if r, ok := v.(*list); ok {
+				l.elems = append(l.elems, r.elems...)
+				return nil
+			}
+		}
+
+		if v, err = binary(s.op[:1], old, v); err != nil {
+			return err
+		}
+	}
+
+	switch t := s.target.(type) {
+	case *nameExpr:
+		e.vars[t.name] = v
+		return nil
+	case *indexExpr:
+		x, err := in.eval(t.x, e)
+		if err != nil {
+			return err
+		}
+
+		l, ok := x.(*list)
+		if !ok {
+			return fmt.Errorf("cannot assign to an element of %s", typeName(x))
+		}
+
+		i, err := in.eval(t.i, e)
+		if err != nil {
+			return err
+		}
+
+		n, err := index(i, len(l.elems))
+		if err != nil {
+			return err
+		}
+
+		l.elems[n] = v
+		return nil
+	}
+
+	return fmt.Errorf("cannot assign")
+}
+
+func (in *interp) eval(x expr, e *env) (value, error) {
+	switch x := x.(type) {
+	case *litExpr:
+		return x.v, nil
+	case *nameExpr:
+		if v, ok := e.get(x.name); ok {
+			return v, nil
+		}
+
+		return nil, fmt.Errorf("undefined: %s", x.name)
+	case *listExpr:
+		l := &list{}
+		for _, j := range x.elems {
+			v, err := in.eval(j, e)
+			if err != nil {
+				return nil, err
+			}
+
+			l.elems = append(l.elems, v)
+		}
+
+		return l, nil
+	case *unExpr:
+		v, err := in.eval(x.x, e)
+		if err != nil {
+			return nil, err
+		}
+
+		if x.op == "not" {
+			return !truth(v), nil
+		}
+
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("bad operand %s for -", typeName(v))
+		}
+
+		return -n, nil
+	case *binExpr:
+		l, err := in.eval(x.x, e)
+		if err != nil {
+			return nil, err
+		}
+
+		switch x.op {
+		case "and":
+			if !truth(l) {
+				return l, nil
+			}
+
+			return in.eval(x.y, e)
+		case "or":
+			if truth(l) {
+				return l, nil
+			}
+
+			return in.eval(x.y, e)
+		}
+
+		r, err := in.eval(x.y, e)
+		if err != nil {
+			return nil, err
+		}
+
+		return binary(x.op, l, r)
+	case *indexExpr:
+		v, err := in.eval(x.x, e)
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := in.eval(x.i, e)
+		if err != nil {
+			return nil, err
+		}
+
+		switch v := v.(type) {
+		case *list:
+			n, err := index(i, len(v.elems))
+			if err != nil {
+				return nil, err
+			}
+
+			return v.elems[n], nil
+		case string:
+			n, err := index(i, len(v))
+			if err != nil {
+				return nil, err
+			}
+
+			return v[n : n+1], nil
+		}
+
+		return nil, fmt.Errorf("cannot index %s", typeName(v))
+	case *attrExpr:
+		v, err := in.eval(x.x, e)
+		if err != nil {
+			return nil, err
+		}
+
+		if l, ok := v.(*list); ok && x.name == "append" {
+			return &builtin{"append", func(args []value) (value, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("append takes 1 argument")
+				}
+
+				l.elems = append(l.elems, args[0])
+				return nil, nil
+			}}, nil
+		}
+
+		return nil, fmt.Errorf("%s has no attribute '%s'", typeName(v), x.name)
+	case *callExpr:
+		fn, err := in.eval(x.fn, e)
+		if err != nil {
+			return nil, err
+		}
+
+		args := make([]value, len(x.args))
+		for i, j := range x.args {
+			if args[i], err = in.eval(j, e); err != nil {
+				return nil, err
+			}
+		}
+
+		v, err := in.call(fn, args)
+		return v, at(x.line, err)
+	}
+
+	panic("unknown expression")
+}
+
+func (in *interp) call(fn value, args []value) (value, error) {
+	switch f := fn.(type) {
+	case *builtin:
+		return f.fn(args)
+	case *function:
+		if len(args) != len(f.params) {
+			return nil, fmt.Errorf("%s takes %d arguments, got %d", f.name, len(f.params), len(args))
+		}
+
+		if in.depth >= maxDepth {
+			return nil, fmt.Errorf("calls nested too deeply")
+		}
+
+		e := &env{vars: make(map[string]value), up: f.env}
+		for i, j := range f.params {
+			e.vars[j] = args[i]
+		}
+
+		in.depth++
+		ctl, v, err := in.block(f.body, e)
+		in.depth--
+
+		if err == nil && (ctl == ctlBreak || ctl == ctlContinue) {
+			err = fmt.Errorf("%s outside loop", ctlWords[ctl])
+		}
+
+		return v, err
+	}
+
+	return nil, fmt.Errorf("cannot call %s", typeName(fn))
+}
+
+// binary applies an operator other than and and or.
+func binary(op string, l, r value) (value, error) {
+	switch op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case *list:
+			for _, j := range r.elems {
+				if equal(l, j) {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		case string:
+			if s, ok := l.(string); ok {
+				return strings.Contains(r, s), nil
+			}
+		}
+	}
+
+	switch l := l.(type) {
+	case int64:
+		r, ok := r.(int64)
+		if !ok {
+			break
+		}
+
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "//", "%":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+
+			q, m := l/r, l%r
+			if m != 0 && (m < 0) != (r < 0) {
+				q, m = q-1, m+r
+			}
+
+			if op == "%" {
+				return m, nil
+			}
+
+			return q, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case string:
+		r, ok := r.(string)
+		if !ok {
+			break
+		}
+
+		switch op {
+		case "+":
+			return l + r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case *list:
+		if r, ok := r.(*list); ok && op == "+" {
+			return &list{append(append([]value{}, l.elems...), r.elems...)}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("bad operands %s %s %s", typeName(l), op, typeName(r))
+}
+
+func equal(l, r value) bool {
+	if a, ok := l.(*list); ok {
+		b, ok := r.(*list)
+		if !ok || len(a.elems) != len(b.elems) {
+			return false
+		}
+
+		for i := range a.elems {
+			if !equal(a.elems[i], b.elems[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return l == r
+}
+
+// index returns the position of element i of a sequence of n, which
+// counts from the end if negative.
+func index(i value, n int) (int, error) {
+	k, ok := i.(int64)
+	if !ok {
+		return 0, fmt.Errorf("index is %s, not int", typeName(i))
+	}
+
+	if k < 0 {
+		k += int64(n)
+	}
+
+	if k < 0 || k >= int64(n) {
+		return 0, fmt.Errorf("index %d out of range", i)
+	}
+
+	return int(k), nil
+}
+
+func truth(v value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case string:
+		return v != ""
+	case *list:
+		return len(v.elems) > 0
+	}
+
+	return true
+}
+
+func typeName(v value) string {
+	switch v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case string:
+		return "string"
+	case *list:
+		return "list"
+	}
+
+	return "function"
+}
+
+// str formats v as print does.
+func str(v value) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	case *list:
+		s := make([]string, len(v.elems))
+		for i, j := range v.elems {
+			if t, ok := j.(string); ok {
+				s[i] = strconv.Quote(t)
+			} else {
+				s[i] = str(j)
+			}
+		}
+
+		return "[" + strings.Join(s, ", ") + "]"
+	case *function:
+		return "<function " + v.name + ">"
+	case *builtin:
+		return "<builtin " + v.name + ">"
+	}
+
+	return "?"
+}
+
+// ints converts args, which must all be ints.
+func ints(args []value) ([]int64, error) {
+	r := make([]int64, len(args))
+	for i, j := range args {
+		n, ok := j.(int64)
+		if !ok {
+			return nil, fmt.Errorf("argument %d is %s, not int", i+1, typeName(j))
+		}
+
+		r[i] = n
+	}
+
+	return r, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// interpret runs the script src, returning what it printed.
+func interpret(src string) (string, error) {
+	var b bytes.Buffer
+	err := newInterp(&b).run(src)
+	return b.String(), err
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"print", "print(1, 'a', True, None, [1, 'b', [None]])", "1 a True None [1, \"b\", [None]]\n"},
+		{"print nothing", "print()", "\n"},
+		{"arith", "print(7 + 2, 7 - 2, 7 * 2, 7 // 2, 7 % 2, -7)", "9 5 14 3 1 -7\n"},
+		{"floor", "print(-7 // 2, -7 % 2, 7 // -2, 7 % -2, -7 // -2, -7 % -2)", "-4 1 -4 -1 3 -1\n"},
+		{"precedence", "print(1 + 2 * 3 - 4 // 2, (1 + 2) * 3)", "5 9\n"},
+		{"wrap", "print(0x7fffffffffffffff + 1)", "-9223372036854775808\n"},
+		{"literals", "print(0x10, 0o10, 0b10, 10)", "16 8 2 10\n"},
+		{"compare", "print(1 < 2, 2 <= 1, 'a' < 'b', 'b' >= 'a', 3 > 3, 3 >= 3)", "True False True True False True\n"},
+		{"equal", "print(1 == 1, 1 == '1', 1 == True, None == None, [1, [2]] == [1, [2]], [1] != [2])", "True False False True True True\n"},
+		{"in", "print(2 in [1, 2], 3 in [1, 2], 'ell' in 'hello', 'x' not in 'hello', [1] in [[1]])", "True False True True True\n"},
+		{"strings", "print('ab' + 'cd', 'hello'[1], 'hello'[-1], len('hello'))", "abcd e o 5\n"},
+		{"and or", "print(0 or 'x', 1 and 2, None and f(), 1 or f(), not 0, not [1])", "x 2 None 1 True False\n"},
+		{"truth", "for v in [0, 1, '', 'a', [], [0], None, True, False, print]:\n    print(not not v)",
+			"False\nTrue\nFalse\nTrue\nFalse\nTrue\nFalse\nTrue\nFalse\nTrue\n"},
+		{"assign", "x = 1\nx += 2\nx *= 3\nx -= 1\nprint(x)", "8\n"},
+		{"string +=", "s = 'a'\ns += 'b'\nprint(s)", "ab\n"},
+		{"list", "l = [1, 2]\nl.append(3)\nl[0] = 5\nl[-1] += 1\nprint(l, len(l), l[1])", "[5, 2, 4] 3 2\n"},
+		{"list +", "a = [1]\nb = a + [2]\nprint(a, b)", "[1] [1, 2]\n"},
+		{"list +=", "a = [1]\nb = a\na += [2]\nprint(a, b)", "[1, 2] [1, 2]\n"},
+		{"list += in element", "a = [[1], 2]\nb = a[0]\na[0] += [3]\nprint(a, b)", "[[1, 3], 2] [1, 3]\n"},
+		{"list alias", "a = [1]\nb = a\nb.append(2)\nprint(a)", "[1, 2]\n"},
+		{"if", "x = 2\nif x == 1:\n    print('one')\nelif x == 2:\n    print('two')\nelse:\n    print('other')", "two\n"},
+		{"else", "if False: print(1)\nelse: print(2)", "2\n"},
+		{"while", "i = 0\nwhile i < 3:\n    print(i)\n    i += 1", "0\n1\n2\n"},
+		{"while break", "i = 0\nwhile True:\n    i += 1\n    if i == 3:\n        break\nprint(i)", "3\n"},
+		{"while continue", "i = 0\nn = 0\nwhile i < 5:\n    i += 1\n    if i % 2 == 0:\n        continue\n    n += i\nprint(n)", "9\n"},
+		{"for list", "for x in [1, 'a']:\n    print(x)", "1\na\n"},
+		{"for string", "for c in 'ab':\n    print(c)", "a\nb\n"},
+		{"for break", "for i in range(10):\n    if i == 2:\n        break\n    print(i)\nprint(i)", "0\n1\n2\n"},
+		{"for continue", "for i in range(4):\n    if i % 2:\n        continue\n    print(i)", "0\n2\n"},
+		{"for copy", "l = [1, 2]\nfor x in l:\n    l.append(x)\nprint(l)", "[1, 2, 1, 2]\n"},
+		{"nested loops", "for i in range(2):\n    for j in range(2):\n        if j == 1:\n            break\n        print(i, j)", "0 0\n1 0\n"},
+		{"def", "def add(a, b):\n    return a + b\nprint(add(1, 2))", "3\n"},
+		{"no return", "def f():\n    pass\nprint(f())", "None\n"},
+		{"bare return", "def f():\n    return\n    print('no')\nprint(f())", "None\n"},
+		{"return in loop", "def f():\n    for i in range(5):\n        while True:\n            return i + 10\nprint(f())", "10\n"},
+		{"recursion", "def fib(n):\n    if n < 2:\n        return n\n    return fib(n - 1) + fib(n - 2)\nprint(fib(15))", "610\n"},
+		{"functions are values", "def f(x):\n    return x * 2\ng = f\nprint(g(4), f, len)", "8 <function f> <builtin len>\n"},
+		{"top-level return", "print(1)\nreturn\nprint(2)", "1\n"},
+		{"assert", "assert 1 == 1\nassert [1], 'msg'\nprint('ok')", "ok\n"},
+		{"comments", "# a\nx = 1 # b\n\n   # c\nprint(x)", "1\n"},
+		{"one line bodies", "def f(x): return x + 1\nif f(1) == 2: print('yes')", "yes\n"},
+		{"continuation", "x = [1,\n     2]\ny = 1 + \\\n    2\nprint(x, y)", "[1, 2] 3\n"},
+	}
+
+	for _, j := range tests {
+		got, err := interpret(j.src)
+		if err != nil {
+			t.Errorf("%s: %s", j.name, err)
+		} else if got != j.want {
+			t.Errorf("%s: got %q, want %q", j.name, got, j.want)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"read global", "x = 1\ndef f():\n    return x\nx = 2\nprint(f())", "2\n"},
+		{"local shadows", "x = 1\ndef f():\n    x = 2\n    return x\nprint(f(), x)", "2 1\n"},
+		{"params are local", "x = 1\ndef f(x):\n    x += 1\n    return x\nprint(f(5), x)", "6 1\n"},
+		{"for variable is local", "i = 9\ndef f():\n    for i in range(3):\n        pass\n    return i\nprint(f(), i)", "2 9\n"},
+		{"for variable outlives loop", "for i in range(3):\n    pass\nprint(i)", "2\n"},
+		{"closure", "def outer(a):\n    def inner(b):\n        return a + b\n    return inner\nadd1 = outer(1)\nprint(add1(2))", "3\n"},
+		{"closure sees later globals", "def f():\n    return g()\ndef g():\n    return 7\nprint(f())", "7\n"},
+		{"calls do not share locals", "def f(n):\n    x = n\n    if n > 0:\n        f(n - 1)\n    return x\nprint(f(3))", "3\n"},
+		{"mutate global list", "l = []\ndef f():\n    l.append(1)\nf()\nf()\nprint(l)", "[1, 1]\n"},
+		{"builtins shadowed", "def len(x):\n    return 0\nprint(len('abc'))", "0\n"},
+	}
+
+	for _, j := range tests {
+		got, err := interpret(j.src)
+		if err != nil {
+			t.Errorf("%s: %s", j.name, err)
+		} else if got != j.want {
+			t.Errorf("%s: got %q, want %q", j.name, got, j.want)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"print(len(''), len('abc'), len([]), len([1, [2, 3]]))", "0 3 0 2\n"},
+		{"print(range(3), range(1, 3), range(0, 10, 4), range(3, 0, -1), range(0), range(3, 1))", "[0, 1, 2] [1, 2] [0, 4, 8] [3, 2, 1] [] []\n"},
+		{"print(str(1) + str(None) + str(True) + str('s'), str([1, 's']))", "1NoneTrues [1, \"s\"]\n"},
+		{"print(int(5), int(True), int(False), int('42'), int('-0x10'), int(' 1 ' == ' 1 '))", "5 1 0 42 -16 1\n"},
+		{"print(hex(0), hex(255), hex(-16))", "0x0 0xff -0x10\n"},
+	}
+
+	for _, j := range tests {
+		got, err := interpret(j.src)
+		if err != nil {
+			t.Errorf("%s: %s", j.src, err)
+		} else if got != j.want {
+			t.Errorf("%s: got %q, want %q", j.src, got, j.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		src, out, want string
+	}{
+		{"print(x)", "", "1: undefined: x"},
+		{"x = 1\ny = x + 'a'", "", "2: bad operands int + string"},
+		{"print('a' * 2)", "", "1: bad operands string * int"},
+		{"[1] < [2]", "", "1: bad operands list < list"},
+		{"x = -'a'", "", "1: bad operand string for -"},
+		{"print(1 // 0)", "", "1: division by zero"},
+		{"print(1 % 0)", "", "1: division by zero"},
+		{"print((1 < 2) < 3)", "", "1: bad operands bool < int"},
+		{"1 in 5", "", "1: bad operands int in int"},
+		{"[1][1]", "", "1: index 1 out of range"},
+		{"'ab'[-3]", "", "1: index -3 out of range"},
+		{"[1]['a']", "", "1: index is string, not int"},
+		{"5[0]", "", "1: cannot index int"},
+		{"s = 'ab'\ns[0] = 'c'", "", "2: cannot assign to an element of string"},
+		{"(1).append(2)", "", "1: int has no attribute 'append'"},
+		{"[].pop()", "", "1: list has no attribute 'pop'"},
+		{"[].append(1, 2)", "", "1: append takes 1 argument"},
+		{"5()", "", "1: cannot call int"},
+		{"for x in 5:\n    pass", "", "1: cannot loop over int"},
+		{"def f(a):\n    return a\nf()", "", "3: f takes 1 arguments, got 0"},
+		{"def f():\n    return f()\nf()", "", "2: calls nested too deeply"},
+		{"def f():\n    print('in f')\n    return 1 + None\nprint('before')\nf()", "before\nin f\n", "3: bad operands int + None"},
+		{"break", "", "break outside loop"},
+		{"continue", "", "continue outside loop"},
+		{"def f():\n    break\nf()", "", "3: break outside loop"},
+		{"assert 1 == 2", "", "1: assertion failed"},
+		{"x = 3\nassert x == 2, 'x is ' + str(x)", "", "2: assertion failed: x is 3"},
+		{"print('a')\nfail('bad', 1)\nprint('b')", "a\n", "2: bad 1"},
+		{"len(1)", "", "1: len of int"},
+		{"len('a', 'b')", "", "1: len takes 1 argument"},
+		{"range()", "", "1: range takes 1 to 3 ints"},
+		{"range('a')", "", "1: range takes 1 to 3 ints"},
+		{"range(1, 2, 0)", "", "1: range step is zero"},
+		{"str()", "", "1: str takes 1 argument"},
+		{"int('x')", "", "1: bad int \"x\""},
+		{"int(None)", "", "1: int of None"},
+		{"int()", "", "1: int takes 1 argument"},
+		{"hex('a')", "", "1: hex takes 1 int"},
+		{"x = 1 +", "", "1: unexpected end of line"},
+	}
+
+	for _, j := range tests {
+		out, err := interpret(j.src)
+		if err == nil {
+			t.Errorf("%q succeeded, want %s", j.src, j.want)
+			continue
+		}
+
+		if err.Error() != j.want {
+			t.Errorf("%q: %s, want %s", j.src, err, j.want)
+		}
+
+		if out != j.out {
+			t.Errorf("%q printed %q, want %q", j.src, out, j.out)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The language of -script is a small subset of Python, in the manner
+// of Starlark: int, string, bool, None and list values, variables,
+// if/elif/else, while, for over lists and strings, def with return,
+// break, continue, pass and assert. Blocks are indented. Integers are
+// 64 bits; // and % round toward negative infinity. There are no
+// floats, dicts, classes, keyword arguments or imports.
+//
+// What it has works as in Starlark, except that:
+//
+//   - integers wrap at 64 bits rather than growing without bound;
+//   - while loops, and if and for at the top level, are allowed;
+//   - globals may be assigned more than once;
+//   - a function reads a global of the same name until it assigns
+//     a local one, where Starlark reports the local as unbound;
+//   - assert is a statement, and return at the top level ends the
+//     script;
+//   - values are never frozen, so functions may change global lists;
+//   - for loops over the bytes of a string as well as a list;
+//   - range returns a list;
+//   - hex is a builtin, and int also accepts 0x, 0o and 0b prefixes
+//     in strings.
+//
+// Of the rest of Starlark it lacks tuples, slices, comprehensions,
+// lambda, conditional expressions, default and variadic parameters,
+// string and list methods other than append, the operators / and
+// unary +, the bitwise operators, string % formatting, string and list
+// repetition, triple-quoted and raw strings, and every builtin but
+// print, len, range, str, int and fail. Escapes in strings are \n,
+// \t, \\ and the quotes.
+
+// Kinds of tokens.
+const (
+	tEOF = iota
+	tName
+	tInt
+	tStr
+	tOp
+	tNewline
+	tIndent
+	tDedent
+)
+
+type token struct {
+	kind int
+	val  string
+	line int
+}
+
+// ops holds the operators and punctuation, two character ones first.
+var ops = []string{"==", "!=", "<=", ">=", "//", "+=", "-=", "*=", "+", "-", "*", "%", "<", ">", "=", "(", ")", "[", "]", ",", ":", "."}
+
+// tokenize splits src into tokens, with tIndent and tDedent around
+// each indented block and tNewline ending each logical line. Newlines
+// inside brackets do not end a line.
+func tokenize(src string) ([]token, error) {
+	var r []token
+
+	indent := []int{0}
+	depth := 0
+	line := 1
+	bol := true
+
+	for i := 0; i < len(src); {
+		if bol && depth == 0 {
+			n := 0
+			for ; i < len(src) && (src[i] == ' ' || src[i] == '\t'); i++ {
+				if src[i] == '\t' {
+					n += 8 - n%8
+				} else {
+					n++
+				}
+			}
+
+			if i == len(src) {
+				break
+			}
+
+			// Blank lines and comments do not change the indentation.
+			if c := src[i]; c == '\n' || c == '\r' || c == '#' {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+
+				if i < len(src) {
+					i++
+					line++
+				}
+
+				continue
+			}
+
+			bol = false
+
+			if n > indent[len(indent)-1] {
+				indent = append(indent, n)
+				r = append(r, token{tIndent, "", line})
+			}
+
+			for n < indent[len(indent)-1] {
+				indent = indent[:len(indent)-1]
+				r = append(r, token{tDedent, "", line})
+			}
+
+			if n != indent[len(indent)-1] {
+				return nil, fmt.Errorf("%d: inconsistent indentation", line)
+			}
+		}
+
+		c := src[i]
+
+		switch {
+		case c == '\n':
+			if depth == 0 {
+				r = append(r, token{tNewline, "", line})
+				bol = true
+			}
+
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			line++
+			i += 2
+		case isLetter(c):
+			j := i
+			for j < len(src) && (isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+
+			r = append(r, token{tName, src[i:j], line})
+			i = j
+		case isDigit(c):
+			j := i
+			for j < len(src) && (isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+
+			// As in Starlark, 017 is not octal but an error.
+			n := src[i:j]
+			if _, err := strconv.ParseInt(n, 0, 64); err != nil || len(n) > 1 && n[0] == '0' && isDigit(n[1]) {
+				return nil, fmt.Errorf("%d: bad number '%s'", line, n)
+			}
+
+			r = append(r, token{tInt, n, line})
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := readQuoted(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", line, err)
+			}
+
+			r = append(r, token{tStr, s, line})
+			i += n
+		default:
+			op := ""
+			for _, j := range ops {
+				if strings.HasPrefix(src[i:], j) {
+					op = j
+					break
+				}
+			}
+
+			switch op {
+			case "":
+				return nil, fmt.Errorf("%d: unexpected character '%c'", line, c)
+			case "(", "[":
+				depth++
+			case ")", "]":
+				if depth > 0 {
+					depth--
+				}
+			}
+
+			r = append(r, token{tOp, op, line})
+			i += len(op)
+		}
+	}
+
+	if !bol {
+		r = append(r, token{tNewline, "", line})
+	}
+
+	for len(indent) > 1 {
+		indent = indent[:len(indent)-1]
+		r = append(r, token{tDedent, "", line})
+	}
+
+	return append(r, token{tEOF, "", line}), nil
+}
+
+// readQuoted reads the string literal at the start of s, returning its
+// value and length. Strings end on the line they start on.
+func readQuoted(s string) (string, int, error) {
+	var b strings.Builder
+
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == q:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			default:
+				return "", 0, fmt.Errorf("bad escape '\\%c'", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Expressions.
+type (
+	expr interface{}
+
+	litExpr struct {
+		v value
+	}
+
+	nameExpr struct {
+		name string
+		line int
+	}
+
+	binExpr struct {
+		op   string
+		x, y expr
+		line int
+	}
+
+	unExpr struct {
+		op   string
+		x    expr
+		line int
+	}
+
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+
+	indexExpr struct {
+		x, i expr
+		line int
+	}
+
+	attrExpr struct {
+		x    expr
+		name string
+		line int
+	}
+
+	listExpr struct {
+		elems []expr
+	}
+)
+
+// Statements. Each records its line for errors.
+type (
+	stmt interface{}
+
+	assignStmt struct {
+		target expr
+		op     string
+		x      expr
+		line   int
+	}
+
+	exprStmt struct {
+		x    expr
+		line int
+	}
+
+	ifStmt struct {
+		conds  []expr
+		bodies [][]stmt
+		els    []stmt
+		line   int
+	}
+
+	whileStmt struct {
+		cond expr
+		body []stmt
+		line int
+	}
+
+	forStmt struct {
+		name string
+		x    expr
+		body []stmt
+		line int
+	}
+
+	defStmt struct {
+		name   string
+		params []string
+		body   []stmt
+		line   int
+	}
+
+	returnStmt struct {
+		x    expr
+		line int
+	}
+
+	assertStmt struct {
+		x, msg expr
+		line   int
+	}
+
+	// ctlStmt is break, continue or pass.
+	ctlStmt struct {
+		word string
+		line int
+	}
+)
+
+// parser builds statements from tokens. Errors are raised with bail
+// and turned into return values by parse.
+type parser struct {
+	toks []token
+	pos  int
+}
+
+type bailout struct {
+	err error
+}
+
+// parse returns the statements of src.
+func parse(src string) (prog []stmt, err error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+
+	defer func() {
+		if e := recover(); e != nil {
+			b, ok := e.(bailout)
+			if !ok {
+				panic(e)
+			}
+
+			prog, err = nil, b.err
+		}
+	}()
+
+	for p.peek().kind != tEOF {
+		prog = append(prog, p.stmt())
+	}
+
+	return prog, nil
+}
+
+func (p *parser) bail(format string, args ...any) {
+	panic(bailout{fmt.Errorf("%d: %s", p.peek().line, fmt.Sprintf(format, args...))})
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+// is reports whether the next token is the operator or keyword s.
+func (p *parser) is(s string) bool {
+	t := p.peek()
+	return (t.kind == tOp || t.kind == tName) && t.val == s
+}
+
+// accept consumes the operator or keyword s if it is next.
+func (p *parser) accept(s string) bool {
+	if p.is(s) {
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.accept(s) {
+		p.bail("expected '%s' got %s", s, describe(p.peek()))
+	}
+}
+
+func (p *parser) newline() {
+	if p.peek().kind != tNewline {
+		p.bail("expected end of line got %s", describe(p.peek()))
+	}
+
+	p.next()
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tEOF:
+		return "end of file"
+	case tNewline:
+		return "end of line"
+	case tIndent:
+		return "indentation"
+	case tDedent:
+		return "end of block"
+	case tStr:
+		return strconv.Quote(t.val)
+	}
+
+	return "'" + t.val + "'"
+}
+
+// keywords cannot be used as names.
+var keywords = map[string]bool{
+	"and": true, "assert": true, "break": true, "continue": true, "def": true,
+	"elif": true, "else": true, "for": true, "if": true, "in": true,
+	"not": true, "or": true, "pass": true, "return": true, "while": true,
+	"True": true, "False": true, "None": true,
+}
+
+func (p *parser) name() string {
+	t := p.peek()
+	if t.kind != tName || keywords[t.val] {
+		p.bail("expected name got %s", describe(t))
+	}
+
+	p.next()
+	return t.val
+}
+
+func (p *parser) stmt() stmt {
+	line := p.peek().line
+
+	switch {
+	case p.accept("if"):
+		s := &ifStmt{line: line}
+		s.conds = append(s.conds, p.expr())
+		s.bodies = append(s.bodies, p.block())
+
+		for p.accept("elif") {
+			s.conds = append(s.conds, p.expr())
+			s.bodies = append(s.bodies, p.block())
+		}
+
+		if p.accept("else") {
+			s.els = p.block()
+		}
+
+		return s
+	case p.accept("while"):
+		return &whileStmt{p.expr(), p.block(), line}
+	case p.accept("for"):
+		name := p.name()
+		p.expect("in")
+		return &forStmt{name, p.expr(), p.block(), line}
+	case p.accept("def"):
+		s := &defStmt{name: p.name(), line: line}
+		p.expect("(")
+
+		for !p.accept(")") {
+			s.params = append(s.params, p.name())
+			if !p.is(")") {
+				p.expect(",")
+			}
+		}
+
+		s.body = p.block()
+		return s
+	}
+
+	s := p.simple()
+	p.newline()
+	return s
+}
+
+// simple parses a statement that fits on one line.
+func (p *parser) simple() stmt {
+	line := p.peek().line
+
+	switch {
+	case p.accept("pass"):
+		return &ctlStmt{"pass", line}
+	case p.accept("break"):
+		return &ctlStmt{"break", line}
+	case p.accept("continue"):
+		return &ctlStmt{"continue", line}
+	case p.accept("return"):
+		s := &returnStmt{line: line}
+		if p.peek().kind != tNewline {
+			s.x = p.expr()
+		}
+
+		return s
+	case p.accept("assert"):
+		s := &assertStmt{x: p.expr(), line: line}
+		if p.accept(",") {
+			s.msg = p.expr()
+		}
+
+		return s
+	}
+
+	x := p.expr()
+	for _, j := range []string{"=", "+=", "-=", "*="} {
+		if p.accept(j) {
+			switch x.(type) {
+			case *nameExpr, *indexExpr:
+			default:
+				p.bail("cannot assign to this expression")
+			}
+
+			return &assignStmt{x, j, p.expr(), line}
+		}
+	}
+
+	return &exprStmt{x, line}
+}
+
+// block parses the body of a compound statement: an indented block,
+// or a simple statement on the same line.
+func (p *parser) block() []stmt {
+	p.expect(":")
+
+	if p.peek().kind != tNewline {
+		s := p.simple()
+		p.newline()
+		return []stmt{s}
+	}
+
+	p.next()
+	if p.peek().kind != tIndent {
+		p.bail("expected an indented block")
+	}
+
+	p.next()
+
+	var r []stmt
+	for p.peek().kind != tDedent && p.peek().kind != tEOF {
+		r = append(r, p.stmt())
+	}
+
+	p.next()
+	return r
+}
+
+func (p *parser) expr() expr {
+	x := p.and()
+	for p.is("or") {
+		line := p.next().line
+		x = &binExpr{"or", x, p.and(), line}
+	}
+
+	return x
+}
+
+func (p *parser) and() expr {
+	x := p.not()
+	for p.is("and") {
+		line := p.next().line
+		x = &binExpr{"and", x, p.not(), line}
+	}
+
+	return x
+}
+
+func (p *parser) not() expr {
+	if p.is("not") {
+		line := p.next().line
+		return &unExpr{"not", p.not(), line}
+	}
+
+	return p.compare()
+}
+
+// compare parses a comparison. As in Starlark, comparisons do not
+// chain: a < b < c is an error rather than a < b and b < c.
+func (p *parser) compare() expr {
+	x := p.sum()
+	line := p.peek().line
+
+	switch {
+	case p.is("==") || p.is("!=") || p.is("<") || p.is("<=") || p.is(">") || p.is(">="):
+		op := p.next().val
+		x = &binExpr{op, x, p.sum(), line}
+	case p.accept("in"):
+		x = &binExpr{"in", x, p.sum(), line}
+	case p.is("not") && p.toks[p.pos+1].val == "in":
+		p.next()
+		p.next()
+		x = &unExpr{"not", &binExpr{"in", x, p.sum(), line}, line}
+	default:
+		return x
+	}
+
+	switch {
+	case p.is("==") || p.is("!=") || p.is("<") || p.is("<=") || p.is(">") || p.is(">=") || p.is("in"),
+		p.is("not") && p.toks[p.pos+1].val == "in":
+		p.bail("comparisons do not chain; use and")
+	}
+
+	return x
+}
+
+func (p *parser) sum() expr {
+	x := p.product()
+	for p.is("+") || p.is("-") {
+		t := p.next()
+		x = &binExpr{t.val, x, p.product(), t.line}
+	}
+
+	return x
+}
+
+func (p *parser) product() expr {
+	x := p.unary()
+	for p.is("*") || p.is("//") || p.is("%") {
+		t := p.next()
+		x = &binExpr{t.val, x, p.unary(), t.line}
+	}
+
+	return x
+}
+
+func (p *parser) unary() expr {
+	if p.is("-") {
+		line := p.next().line
+		return &unExpr{"-", p.unary(), line}
+	}
+
+	return p.postfix()
+}
+
+func (p *parser) postfix() expr {
+	x := p.atom()
+
+	for {
+		line := p.peek().line
+
+		switch {
+		case p.accept("("):
+			c := &callExpr{fn: x, line: line}
+			for !p.accept(")") {
+				c.args = append(c.args, p.expr())
+				if !p.is(")") {
+					p.expect(",")
+				}
+			}
+
+			x = c
+		case p.accept("["):
+			x = &indexExpr{x, p.expr(), line}
+			p.expect("]")
+		case p.accept("."):
+			x = &attrExpr{x, p.name(), line}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *parser) atom() expr {
+	t := p.peek()
+
+	switch {
+	case t.kind == tInt:
+		p.next()
+		v, _ := strconv.ParseInt(t.val, 0, 64)
+		return &litExpr{v}
+	case t.kind == tStr:
+		p.next()
+		return &litExpr{t.val}
+	case p.accept("True"):
+		return &litExpr{true}
+	case p.accept("False"):
+		return &litExpr{false}
+	case p.accept("None"):
+		return &litExpr{nil}
+	case p.accept("("):
+		x := p.expr()
+		p.expect(")")
+		return x
+	case p.accept("["):
+		l := &listExpr{}
+		for !p.accept("]") {
+			l.elems = append(l.elems, p.expr())
+			if !p.is("]") {
+				p.expect(",")
+			}
+		}
+
+		return l
+	case t.kind == tName && !keywords[t.val]:
+		p.next()
+		return &nameExpr{t.val, t.line}
+	}
+
+	p.bail("unexpected %s", describe(t))
+	return nil
+}
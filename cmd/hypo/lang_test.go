@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// tokens formats the tokens of src compactly, as kind:value with
+// blocks and line ends as {, } and ;.
+func tokens(src string) (string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+
+	var s []string
+	for _, j := range toks {
+		switch j.kind {
+		case tName:
+			s = append(s, "n:"+j.val)
+		case tInt:
+			s = append(s, "i:"+j.val)
+		case tStr:
+			s = append(s, fmt.Sprintf("s:%q", j.val))
+		case tOp:
+			s = append(s, j.val)
+		case tNewline:
+			s = append(s, ";")
+		case tIndent:
+			s = append(s, "{")
+		case tDedent:
+			s = append(s, "}")
+		case tEOF:
+			s = append(s, "EOF")
+		}
+	}
+
+	return strings.Join(s, " "), nil
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"", "EOF"},
+		{"x = 1", "n:x = i:1 ; EOF"},
+		{"x=0x1f+0o7-0b10", "n:x = i:0x1f + i:0o7 - i:0b10 ; EOF"},
+		{"a//b%c", "n:a // n:b % n:c ; EOF"},
+		{"a <= b >= c == d != e < f > g", "n:a <= n:b >= n:c == n:d != n:e < n:f > n:g ; EOF"},
+		{"x += 1\nx -= 1\nx *= 2\n", "n:x += i:1 ; n:x -= i:1 ; n:x *= i:2 ; EOF"},
+		{`'a' "b\n" 'it\'s' "\\\t"`, `s:"a" s:"b\n" s:"it's" s:"\\\t" ; EOF`},
+		{"# only a comment\n", "EOF"},
+		{"x # trailing\n", "n:x ; EOF"},
+		{"if x:\n    y\nz\n", "n:if n:x : ; { n:y ; } n:z ; EOF"},
+		{"if x:\n    y\n", "n:if n:x : ; { n:y ; } EOF"},
+		{"if x:\n  if y:\n    z\nw", "n:if n:x : ; { n:if n:y : ; { n:z ; } } n:w ; EOF"},
+		{"if x:\n\n    # comment\n    y\n", "n:if n:x : ; { n:y ; } EOF"},
+		{"if x:\n\ty\n        z\n", "n:if n:x : ; { n:y ; n:z ; } EOF"},
+		{"f(1,\n  2)\n", "n:f ( i:1 , i:2 ) ; EOF"},
+		{"[1,\n\n 2]", "[ i:1 , i:2 ] ; EOF"},
+		{"x = 1 + \\\n    2\n", "n:x = i:1 + i:2 ; EOF"},
+		{"l.append(1)", "n:l . n:append ( i:1 ) ; EOF"},
+		{"x\r\ny\r\n", "n:x ; n:y ; EOF"},
+	}
+
+	for _, j := range tests {
+		got, err := tokens(j.src)
+		if err != nil {
+			t.Errorf("tokenize(%q): %s", j.src, err)
+		} else if got != j.want {
+			t.Errorf("tokenize(%q) = %s, want %s", j.src, got, j.want)
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"x = 1x", "1: bad number '1x'"},
+		{"x = 017", "1: bad number '017'"},
+		{"x = 0x", "1: bad number '0x'"},
+		{"x = 99999999999999999999", "1: bad number '99999999999999999999'"},
+		{"\nx = 'abc", "2: unterminated string"},
+		{"x = 'ab\nc'", "1: unterminated string"},
+		{`x = "\q"`, `1: bad escape '\q'`},
+		{"x = $", "1: unexpected character '$'"},
+		{"x = a & b", "1: unexpected character '&'"},
+		{"if x:\n    y\n  z\n", "3: inconsistent indentation"},
+	}
+
+	for _, j := range tests {
+		_, err := tokenize(j.src)
+		if err == nil {
+			t.Errorf("tokenize(%q) succeeded, want %s", j.src, j.want)
+		} else if err.Error() != j.want {
+			t.Errorf("tokenize(%q): %s, want %s", j.src, err, j.want)
+		}
+	}
+}
+
+// show formats the statements prog, with expressions fully
+// parenthesized, to check how they were parsed.
+func show(prog []stmt) string {
+	var s []string
+	for _, j := range prog {
+		s = append(s, showStmt(j))
+	}
+
+	return strings.Join(s, "; ")
+}
+
+func showStmt(s stmt) string {
+	switch s := s.(type) {
+	case *assignStmt:
+		return showExpr(s.target) + " " + s.op + " " + showExpr(s.x)
+	case *exprStmt:
+		return showExpr(s.x)
+	case *ifStmt:
+		r := ""
+		for i, j := range s.conds {
+			if i > 0 {
+				r += " elif "
+			} else {
+				r += "if "
+			}
+
+			r += showExpr(j) + " {" + show(s.bodies[i]) + "}"
+		}
+
+		if s.els != nil {
+			r += " else {" + show(s.els) + "}"
+		}
+
+		return r
+	case *whileStmt:
+		return "while " + showExpr(s.cond) + " {" + show(s.body) + "}"
+	case *forStmt:
+		return "for " + s.name + " in " + showExpr(s.x) + " {" + show(s.body) + "}"
+	case *defStmt:
+		return "def " + s.name + "(" + strings.Join(s.params, ", ") + ") {" + show(s.body) + "}"
+	case *returnStmt:
+		if s.x == nil {
+			return "return"
+		}
+
+		return "return " + showExpr(s.x)
+	case *assertStmt:
+		if s.msg == nil {
+			return "assert " + showExpr(s.x)
+		}
+
+		return "assert " + showExpr(s.x) + ", " + showExpr(s.msg)
+	case *ctlStmt:
+		return s.word
+	}
+
+	return "?"
+}
+
+func showExpr(x expr) string {
+	switch x := x.(type) {
+	case *litExpr:
+		if s, ok := x.v.(string); ok {
+			return fmt.Sprintf("%q", s)
+		}
+
+		return str(x.v)
+	case *nameExpr:
+		return x.name
+	case *binExpr:
+		return "(" + showExpr(x.x) + " " + x.op + " " + showExpr(x.y) + ")"
+	case *unExpr:
+		return "(" + x.op + " " + showExpr(x.x) + ")"
+	case *callExpr:
+		var a []string
+		for _, j := range x.args {
+			a = append(a, showExpr(j))
+		}
+
+		return showExpr(x.fn) + "(" + strings.Join(a, ", ") + ")"
+	case *indexExpr:
+		return showExpr(x.x) + "[" + showExpr(x.i) + "]"
+	case *attrExpr:
+		return showExpr(x.x) + "." + x.name
+	case *listExpr:
+		var a []string
+		for _, j := range x.elems {
+			a = append(a, showExpr(j))
+		}
+
+		return "[" + strings.Join(a, ", ") + "]"
+	}
+
+	return "?"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"a // b % c * d", "(((a // b) % c) * d)"},
+		{"-a * -b", "((- a) * (- b))"},
+		{"--a", "(- (- a))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"a or b and not c", "(a or (b and (not c)))"},
+		{"not a == b", "(not (a == b))"},
+		{"a < b + 1", "(a < (b + 1))"},
+		{"(a < b) < c", "((a < b) < c)"},
+		{"x in l and y not in l", "((x in l) and (not (y in l)))"},
+		{"f(a, b)[0].append(1)", "f(a, b)[0].append(1)"},
+		{"f()", "f()"},
+		{"f(1,)", "f(1)"},
+		{"[]", "[]"},
+		{"[1, [2, 3],]", "[1, [2, 3]]"},
+		{"True and False or None", "((True and False) or None)"},
+		{"'a' + \"b\"", `("a" + "b")`},
+		{"x = 1", "x = 1"},
+		{"l[0] += 1", "l[0] += 1"},
+		{"x *= 2", "x *= 2"},
+		{"if a: b", "if a {b}"},
+		{"if a:\n    b\nelif c:\n    d\nelse:\n    e", "if a {b} elif c {d} else {e}"},
+		{"while x: x -= 1", "while x {x -= 1}"},
+		{"for i in range(3):\n    pass\n    continue\n    break", "for i in range(3) {pass; continue; break}"},
+		{"def f(a, b):\n    return a + b", "def f(a, b) {return (a + b)}"},
+		{"def f():\n    return", "def f() {return}"},
+		{"def f(a,): pass", "def f(a) {pass}"},
+		{"assert x", "assert x"},
+		{"assert x, 'msg'", `assert x, "msg"`},
+	}
+
+	for _, j := range tests {
+		prog, err := parse(j.src)
+		if err != nil {
+			t.Errorf("parse(%q): %s", j.src, err)
+		} else if got := show(prog); got != j.want {
+			t.Errorf("parse(%q) = %s, want %s", j.src, got, j.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"x = ", "1: unexpected end of line"},
+		{"x y", "1: expected end of line got 'y'"},
+		{"x = y = 1", "1: expected end of line got '='"},
+		{"f(1 2)", "1: expected ',' got '2'"},
+		{"[1 2]", "1: expected ',' got '2'"},
+		{"(1", "1: expected ')' got end of line"},
+		{"a[1", "1: expected ']' got end of line"},
+		{"1 = x", "1: cannot assign to this expression"},
+		{"f() = x", "1: cannot assign to this expression"},
+		{"if x\n    y", "1: expected ':' got end of line"},
+		{"if x:\ny", "2: expected an indented block"},
+		{"\n\nwhile x:\n", "4: expected an indented block"},
+		{"for 1 in l: pass", "1: expected name got '1'"},
+		{"for x on l: pass", "1: expected 'in' got 'on'"},
+		{"def if(): pass", "1: expected name got 'if'"},
+		{"def f(a b): pass", "1: expected ',' got 'b'"},
+		{"x = if", "1: unexpected 'if'"},
+		{"a < b < c", "1: comparisons do not chain; use and"},
+		{"a == b in c", "1: comparisons do not chain; use and"},
+		{"a in b not in c", "1: comparisons do not chain; use and"},
+		{"l.1", "1: expected name got '1'"},
+		{"else: x", "1: unexpected 'else'"},
+		{"x = )", "1: unexpected ')'"},
+		{"    x = 1", "1: unexpected indentation"},
+	}
+
+	for _, j := range tests {
+		_, err := parse(j.src)
+		if err == nil {
+			t.Errorf("parse(%q) succeeded, want %s", j.src, j.want)
+		} else if err.Error() != j.want {
+			t.Errorf("parse(%q): %s, want %s", j.src, err, j.want)
+		}
+	}
+}
@@ -1,35 +1,1120 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rtcall/hypo/asm"
 
 	"github.com/rtcall/hypo/cpu"
 )
 
+// parseCosts parses a list of mnemonic=cycles pairs separated by
+// commas.
+func parseCosts(c *cpu.Cpu, s string) error {
+	for _, j := range strings.Split(s, ",") {
+		if j == "" {
+			continue
+		}
+
+		name, val, _ := strings.Cut(j, "=")
+
+		op, ok := asm.Opcode(name)
+		if !ok {
+			return fmt.Errorf("bad instruction '%s'", name)
+		}
+
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad cost '%s'", val)
+		}
+
+		c.SetCost(op, n)
+	}
+
+	return nil
+}
+
+// parseRange parses a range of hex addresses written lo:hi.
+func parseRange(s string) (*cpu.Range, error) {
+	lo, hi, ok := strings.Cut(s, ":")
+	l, lerr := strconv.ParseUint(lo, 16, 32)
+	h, herr := strconv.ParseUint(hi, 16, 32)
+
+	if !ok || lerr != nil || herr != nil || h < l {
+		return nil, fmt.Errorf("bad range '%s'", s)
+	}
+
+	return &cpu.Range{Lo: uint32(l), Hi: uint32(h)}, nil
+}
+
+// parseProtect applies a comma separated list of protections written
+// lo:hi=prot, where prot holds any of the letters r, w and x, or is -
+// for none.
+func parseProtect(c *cpu.Cpu, s string) error {
+	for _, j := range strings.Split(s, ",") {
+		r, p, ok := strings.Cut(j, "=")
+		if !ok {
+			return fmt.Errorf("bad protection '%s'", j)
+		}
+
+		rng, err := parseRange(r)
+		if err != nil {
+			return err
+		}
+
+		var prot uint32
+		for _, k := range p {
+			switch {
+			case k == 'r':
+				prot |= cpu.ProtR
+			case k == 'w':
+				prot |= cpu.ProtW
+			case k == 'x':
+				prot |= cpu.ProtX
+			case k != '-':
+				return fmt.Errorf("bad protection '%s'", j)
+			}
+		}
+
+		if err := c.Protect(*rng, prot); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseWatch sets the watchpoints of a comma separated list written
+// lo:hi or lo:hi=kind, where kind is r, w or rw, the default.
+func parseWatch(c *cpu.Cpu, s string) error {
+	for _, j := range strings.Split(s, ",") {
+		r, k, ok := strings.Cut(j, "=")
+		if !ok {
+			k = "rw"
+		}
+
+		kind, ok := map[string]int{
+			"r":  cpu.WatchRead,
+			"w":  cpu.WatchWrite,
+			"rw": cpu.WatchRead | cpu.WatchWrite,
+		}[k]
+		if !ok {
+			return fmt.Errorf("bad watchpoint '%s'", j)
+		}
+
+		rng, err := parseRange(r)
+		if err != nil {
+			return err
+		}
+
+		c.Watch(*rng, kind)
+	}
+
+	return nil
+}
+
+// parseFilter builds a trace filter from the -trace flags.
+func parseFilter(pc, mem, regs, ops string) (f cpu.Filter, err error) {
+	if pc != "" {
+		if f.Pc, err = parseRange(pc); err != nil {
+			return f, err
+		}
+	}
+
+	if mem != "" {
+		if f.Mem, err = parseRange(mem); err != nil {
+			return f, err
+		}
+	}
+
+	for _, j := range strings.Split(regs, ",") {
+		if j == "" {
+			continue
+		}
+
+		r, err := strconv.Atoi(strings.TrimPrefix(j, "%"))
+		if err != nil || r < 0 || r > 31 {
+			return f, fmt.Errorf("bad register '%s'", j)
+		}
+
+		f.Regs |= 1 << r
+	}
+
+	for _, j := range strings.Split(ops, ",") {
+		if j == "" {
+			continue
+		}
+
+		op, ok := asm.Opcode(j)
+		if !ok {
+			return f, fmt.Errorf("bad instruction '%s'", j)
+		}
+
+		f.Ops = append(f.Ops, op)
+	}
+
+	return f, nil
+}
+
+// source holds the lines of source files named in the line table.
+var source = make(map[string][]string)
+
+// line returns the text of the source line at pc, if known.
+func line(c *cpu.Cpu, pc uint32) (asm.Line, string) {
+	l, ok := c.Source(pc)
+	if !ok {
+		return l, ""
+	}
+
+	s, ok := source[l.File]
+	if !ok {
+		if b, err := os.ReadFile(l.File); err == nil {
+			s = strings.Split(string(b), "\n")
+		}
+		source[l.File] = s
+	}
+
+	if l.Line < 1 || l.Line > len(s) {
+		return l, ""
+	}
+
+	return l, strings.TrimSpace(s[l.Line-1])
+}
+
+// parseArgs parses a list of hex words for the input block.
+func parseArgs(s string) ([]uint32, error) {
+	var r []uint32
+
+	for _, j := range strings.Split(s, ",") {
+		if j == "" {
+			continue
+		}
+
+		v, err := strconv.ParseUint(j, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad argument '%s'", j)
+		}
+
+		r = append(r, uint32(v))
+	}
+
+	return r, nil
+}
+
+// parseAsserts builds an assertion from a list of %r=v and addr=v
+// terms, checking a register, one of nreg, or the word at addr. Values
+// and addresses are hex.
+func parseAsserts(s string, nreg int) (func(cpu.Guest) error, error) {
+	type term struct {
+		reg  bool
+		n, v uint32
+	}
+
+	var t []term
+	for _, j := range strings.Split(s, ",") {
+		if j == "" {
+			continue
+		}
+
+		lhs, rhs, ok := strings.Cut(j, "=")
+		v, err := strconv.ParseUint(rhs, 16, 32)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("bad assertion '%s'", j)
+		}
+
+		x := term{v: uint32(v)}
+		if strings.HasPrefix(lhs, "%") {
+			n, err := strconv.Atoi(strings.TrimPrefix(lhs, "%"))
+			if err != nil || n < 0 || n >= nreg {
+				return nil, fmt.Errorf("bad register '%s'", lhs)
+			}
+
+			x.reg, x.n = true, uint32(n)
+		} else {
+			n, err := strconv.ParseUint(lhs, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad address '%s'", lhs)
+			}
+
+			x.n = uint32(n)
+		}
+
+		t = append(t, x)
+	}
+
+	return func(g cpu.Guest) error {
+		for _, j := range t {
+			var v uint32
+			var err error
+
+			if j.reg {
+				v, err = g.Reg(int(j.n))
+			} else {
+				v, err = g.Load(j.n)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if v != j.v {
+				if j.reg {
+					return fmt.Errorf("%%%d is %x, want %x", j.n, v, j.v)
+				}
+
+				return fmt.Errorf("%08x is %x, want %x", j.n, v, j.v)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// runCheck model checks c with inputs given as regs:values.
+func runCheck(c *cpu.Cpu, depth int, inputs string, steps uint64, asserts string) error {
+	r, v, ok := strings.Cut(inputs, ":")
+	regs, rerr := strconv.Atoi(r)
+	vals, verr := strconv.ParseUint(v, 10, 32)
+
+	if !ok || rerr != nil || verr != nil {
+		return fmt.Errorf("bad inputs '%s'", inputs)
+	}
+
+	var a func(cpu.Guest) error
+	var err error
+
+	if isAssertFile(asserts) {
+		f, err := loadAsserts(asserts, c.NumRegs())
+		if err != nil {
+			return err
+		}
+
+		a = f.guest()
+	} else if a, err = parseAsserts(asserts, c.NumRegs()); err != nil {
+		return err
+	}
+
+	c.SetOutput(io.Discard)
+	c.SetErrorOutput(io.Discard)
+	c.RegisterHypercall(cpu.AssertHypercall, cpu.Assert)
+
+	n, err := c.Check(cpu.Check{Regs: regs, Values: uint32(vals), Depth: depth, Steps: steps, Assert: a})
+	fmt.Printf("%d paths explored\n", n)
+	return err
+}
+
+// isSet reports whether the flag name was given on the command line.
+func isSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// acceptUART listens on the TCP address addr and returns the first
+// connection made to it.
+func acceptUART(addr string) (net.Conn, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer l.Close()
+
+	fmt.Fprintf(os.Stderr, "uart: waiting for a connection on %s\n", l.Addr())
+	return l.Accept()
+}
+
+// multicore lists the flags that -cores supports. The others set up
+// tracing, devices and services of a single Cpu.
+var multicore = map[string]bool{
+	"cores": true, "quantum": true, "sched-seed": true, "regs": true,
+	"raw": true, "base": true, "args": true, "root": true,
+	"von-neumann": true, "wx": true, "protect": true, "budget": true,
+}
+
+// runMachine runs m, with the standard syscalls, and returns the exit
+// status of core 0. Each core gets regs registers and the start state
+// start, and is then set up by setup.
+func runMachine(m *cpu.Machine, regs int, start cpu.Start, vn bool, setup func(*cpu.Cpu) error, root string) (uint32, error) {
+	for _, j := range m.Cores {
+		if err := j.SetRegs(regs); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := m.SetStart(start); err != nil {
+		return 0, err
+	}
+
+	if vn {
+		if err := m.SetVonNeumann(); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, j := range m.Cores {
+		if err := setup(j); err != nil {
+			return 0, err
+		}
+	}
+
+	sys, err := cpu.NewSyscalls(root)
+	if err != nil {
+		return 0, err
+	}
+
+	defer sys.Close()
+
+	for _, j := range m.Cores {
+		j.SetSyscallHandler(sys.Handle)
+	}
+
+	if err := m.Run(); err != nil {
+		return 0, err
+	}
+
+	return m.Cores[0].ExitCode(), nil
+}
+
+// verifyRun re-executes the image at path and checks it against the
+// retirement log at logPath.
+func verifyRun(logPath, path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	c, err := cpu.New(buf)
+	if err != nil {
+		return err
+	}
+
+	c.SetOutput(io.Discard)
+	c.SetErrorOutput(io.Discard)
+
+	if err := c.Map(cpu.TimerBase, cpu.TimerSize, cpu.NewTimer(cpu.TimerIRQ)); err != nil {
+		return err
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	return cpu.VerifyRetire(&c, bufio.NewReader(f))
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("usage: %s file\n", os.Args[0])
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "verify-run" {
+		if len(os.Args) != 4 {
+			fmt.Printf("usage: %s verify-run log file\n", os.Args[0])
+			exit(1)
+		}
+
+		if err := verifyRun(os.Args[2], os.Args[3]); err != nil {
+			fmt.Printf("%s: %s\n", os.Args[3], err)
+			exit(1)
+		}
+
+		fmt.Println("ok")
+		return
+	}
+
+	ring := flag.Int("ring", 0, "keep the last n steps and print them on fault")
+	sample := flag.Int("sample", 0, "trace every nth step to stderr")
+	raw := flag.Bool("raw", false, "load a headerless image")
+	base := flag.Uint("base", 0, "load address of a headerless image")
+	strict := flag.Bool("strict", false, "normalize line endings and trailing whitespace in output")
+	stats := flag.Bool("stats", false, "print step and cycle counts to stderr")
+	cost := flag.String("cost", "", "cycle costs as a list of mnemonic=n")
+	verbose := flag.Bool("v", false, "print the source line of each instruction to stderr")
+	step := flag.Bool("step", false, "step interactively by source line")
+	tracePc := flag.String("trace-pc", "", "only trace instructions at addresses lo:hi")
+	traceMem := flag.String("trace-mem", "", "only trace instructions accessing memory in lo:hi")
+	traceReg := flag.String("trace-reg", "", "only trace instructions writing one of these registers")
+	traceOp := flag.String("trace-op", "", "only trace these instructions")
+	dumpMem := flag.String("dump-mem", "", "dump memory at addresses lo:hi on exit")
+	cosim := flag.String("cosim", "", "run in lockstep with the simulator started by this command")
+	retire := flag.String("retire", "", "write a retirement log to this file")
+	check := flag.Int("check", 0, "model check every path with up to n yields")
+	checkInputs := flag.String("check-inputs", "1:2", "inputs per yield as registers:values")
+	checkSteps := flag.Uint64("check-steps", 100000, "maximum steps per checked path")
+	assert := flag.String("assert", "", "check a list of %r=v and addr=v, or an assertion file, at exit")
+	funcs := flag.Bool("funcs-report", false, "print per-routine step and call counts to stderr")
+	scriptPath := flag.String("script", "", "drive the machine with the script in this file")
+	inPath := flag.String("in", "", "read guest input from this file instead of stdin, or nothing with -keyboard")
+	outPath := flag.String("out", "", "also write guest output to this file")
+	errPath := flag.String("err", "", "also write guest error output to this file")
+	explain := flag.Bool("explain", false, "explain each executed instruction on stderr")
+	fsPath := flag.String("fs", "", "give the guest a file system preloaded from this tar or zip file")
+	fsOut := flag.String("fs-out", "", "write the guest file system to this tar file after the run")
+	scr := flag.Bool("screen", false, "map a framebuffer and draw it on the terminal")
+	keys := flag.Bool("keyboard", false, "map a keyboard reading keys from the terminal in raw mode")
+	disk := flag.String("disk", "", "map a disk backed by this image file")
+	seed := flag.Int64("seed", 0, "seed of the random number device, instead of the clock")
+	gpio := flag.Bool("gpio", false, "map a GPIO device and print its pins on stderr when they change")
+	wav := flag.String("wav", "", "map a beeper and record its tones to this WAV file")
+	netDev := flag.Bool("net", false, "map a device giving the program TCP connections")
+	root := flag.String("root", "", "let sys open the files below this directory")
+	uart := flag.String("uart", "", "wait for a TCP connection on this address and bridge it to the UART")
+	vn := flag.Bool("von-neumann", false, "load the code into memory and fetch instructions from there")
+	wx := flag.Bool("wx", false, "fault on writes to code and on executing any other memory (needs -von-neumann)")
+	smc := flag.Bool("smc", false, "report stores into code that has already run at exit, and in the -sample trace (needs -von-neumann)")
+	protect := flag.String("protect", "", "protect memory, as a list of lo:hi=rwx")
+	watch := flag.String("watch", "", "pause on accesses to memory, as a list of lo:hi=rw")
+	budget := flag.Uint64("budget", 0, "fault after executing this many instructions")
+	threads := flag.Bool("threads", false, "switch between green threads on yield, with hypercalls 7400 to 7403")
+	cores := flag.Int("cores", 1, "run on this many cores sharing memory")
+	quantum := flag.Int("quantum", 1, "instructions each core runs before the next, with -cores")
+	schedSeed := flag.Uint64("sched-seed", 0, "interleave cores in a random order given by this seed, with -cores")
+	regs := flag.Int("regs", cpu.NumRegs, "number of registers of the machine (8, 16 or 32)")
+	args := flag.String("args", "", "hex words of the input block, comma separated")
+	flag.Parse()
+
+	if len(flag.Args()) == 0 {
+		fmt.Printf("usage: %s [options] file [arg ...]\n", os.Args[0])
+		fmt.Printf("       %s verify-run log file\n", os.Args[0])
+		flag.PrintDefaults()
+		exit(1)
 	}
 
-	buf, err := os.ReadFile(os.Args[1])
+	// Without von Neumann mode no code is in memory, so -wx would
+	// protect nothing.
+	if *wx && !*vn {
+		fmt.Println("error: -wx needs -von-neumann")
+		exit(1)
+	}
+
+	if *smc && !*vn {
+		fmt.Println("error: -smc needs -von-neumann")
+		exit(1)
+	}
+
+	// The keyboard reads stdin as keys are typed, leaving nothing for
+	// the lines that -step and -watch wait for.
+	if *keys && (*step || *watch != "") {
+		fmt.Println("error: -keyboard cannot be used with -step or -watch")
+		exit(1)
+	}
+
+	if *cores > 1 {
+		flag.Visit(func(f *flag.Flag) {
+			if !multicore[f.Name] {
+				fmt.Printf("error: -%s is not supported with -cores\n", f.Name)
+				exit(1)
+			}
+		})
+	}
+
+	buf, err := os.ReadFile(flag.Arg(0))
 	if err != nil {
 		fmt.Printf("error: %s\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 
-	c, err := cpu.New(buf)
+	// setup applies the flags configuring a Cpu that has its start
+	// state, or each core of a multicore machine.
+	setup := func(c *cpu.Cpu) error {
+		if *wx {
+			c.SetWX()
+		}
+
+		if *protect != "" {
+			if err := parseProtect(c, *protect); err != nil {
+				return err
+			}
+		}
+
+		c.SetBudget(*budget)
+		return nil
+	}
+
+	var c cpu.Cpu
+	if *raw {
+		c, err = cpu.NewRaw(buf, uint32(*base))
+	} else {
+		c, err = cpu.New(buf)
+	}
+
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	if err := c.SetRegs(*regs); err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	// The file and the arguments after it are passed as strings, unless
+	// -args gives the input block instead.
+	start := cpu.Start{}
+	if *args == "" || flag.NArg() > 1 {
+		start.Argv = flag.Args()
+	}
+
+	start.Args, err = parseArgs(*args)
+	if err == nil {
+		err = c.SetStart(start)
+	}
+
+	if err == nil && *vn {
+		err = c.SetVonNeumann()
+	}
+
+	if err == nil {
+		err = setup(&c)
+	}
+
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	if *cores > 1 {
+		var m *cpu.Machine
+		if *raw {
+			m, err = cpu.NewRawMachine(buf, uint32(*base), *cores)
+		} else {
+			m, err = cpu.NewMachine(buf, *cores)
+		}
+
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		m.Quantum = *quantum
+		m.Seed = *schedSeed
+
+		code, err := runMachine(m, *regs, start, *vn, setup, *root)
+		if err != nil {
+			fmt.Printf("fatal: %s\n", err)
+			exit(1)
+		}
+
+		exit(int(code & 0xff))
+	}
+
+	// Exit with the status of the guest, once everything else deferred
+	// has run. Shells see its low eight bits.
+	defer func() {
+		if code := c.ExitCode(); code != 0 {
+			exit(int(code & 0xff))
+		}
+	}()
+
+	c.RegisterHypercall(cpu.MapHypercall, cpu.MemoryMap)
+
+	if err := c.Map(cpu.TimerBase, cpu.TimerSize, cpu.NewTimer(cpu.TimerIRQ)); err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	if err := c.Map(cpu.DMABase, cpu.DMASize, cpu.NewDMA(cpu.DMAIRQ)); err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	rng := uint64(time.Now().UnixNano())
+	if isSet("seed") {
+		rng = uint64(*seed)
+	}
+
+	if err := c.Map(cpu.RNGBase, cpu.RNGSize, cpu.NewRNG(rng)); err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	sys, err := cpu.NewSyscalls(*root)
 	if err != nil {
 		fmt.Printf("error: %s\n", err)
-		os.Exit(1)
+		exit(1)
+	}
+
+	defer sys.Close()
+	c.SetSyscallHandler(sys.Handle)
+
+	fs := cpu.NewFS()
+	if *fsPath != "" {
+		if err := loadFS(fs, *fsPath); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *fsPath != "" || *fsOut != "" {
+		fs.Register(&c)
+	}
+
+	if *threads {
+		cpu.NewThreads().Register(&c)
+	}
+
+	if *watch != "" {
+		if err := parseWatch(&c, *watch); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if err := parseCosts(&c, *cost); err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	filt, err := parseFilter(*tracePc, *traceMem, *traceReg, *traceOp)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	var dump *cpu.Range
+	if *dumpMem != "" {
+		if dump, err = parseRange(*dumpMem); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	c.SetFilter(filt)
+	c.SetFuncProfile(*funcs)
+	if *explain {
+		c.SetExplain(os.Stderr)
+	}
+	c.SetRing(*ring)
+	c.SetSample(*sample, os.Stderr)
+
+	if *smc {
+		var w io.Writer
+		if *sample > 0 {
+			w = os.Stderr
+		}
+
+		c.TrackSelfModify(w)
+	}
+
+	out := cpu.NewStrictWriter(os.Stdout)
+	defer out.Flush()
+
+	var guest io.Writer = os.Stdout
+	if *strict {
+		guest = out
 	}
 
+	if *outPath != "" {
+		f := create(*outPath)
+		defer f.Close()
+		guest = io.MultiWriter(guest, f)
+	}
+
+	var asserts *assertFile
+	var terms func(cpu.Guest) error
+	var got bytes.Buffer
+
+	if *check == 0 && isAssertFile(*assert) {
+		if asserts, err = loadAsserts(*assert, *regs); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		guest = io.MultiWriter(guest, &got)
+	} else if *check == 0 && *assert != "" {
+		if terms, err = parseAsserts(*assert, *regs); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	c.SetOutput(guest)
+
+	if *inPath != "" {
+		f, err := os.Open(*inPath)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		defer f.Close()
+		c.SetInput(bufio.NewReader(f))
+	} else if *keys {
+		// Only the keyboard reads the terminal.
+		c.SetInput(strings.NewReader(""))
+	}
+
+	if *uart != "" {
+		conn, err := acceptUART(*uart)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		defer conn.Close()
+
+		if err := c.Map(cpu.UARTBase, cpu.UARTSize, cpu.NewUART(conn, cpu.UARTIRQ)); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *scr {
+		fb := newScreen(os.Stdout)
+		defer fb.draw(true)
+
+		if err := c.Map(cpu.FramebufferBase, cpu.FramebufferSize, fb); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *disk != "" {
+		f, err := os.OpenFile(*disk, os.O_RDWR, 0)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		defer f.Close()
+
+		st, err := f.Stat()
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		if err := c.Map(cpu.DiskBase, cpu.DiskSize, cpu.NewDisk(f, st.Size(), cpu.DiskIRQ)); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *gpio {
+		p := cpu.NewGPIO(cpu.GPIOIRQ)
+		p.Watch(func(pins uint32) {
+			fmt.Fprintf(os.Stderr, "gpio: %032b\n", pins)
+		})
+
+		if err := c.Map(cpu.GPIOBase, cpu.GPIOSize, p); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	beeper := cpu.NewBeeper()
+	if *wav != "" {
+		if err := c.Map(cpu.BeeperBase, cpu.BeeperSize, beeper); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *netDev {
+		if err := c.Map(cpu.NetBase, cpu.NetSize, cpu.NewNet(cpu.NetIRQ)); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *keys {
+		if err := rawMode(); err != nil {
+			fmt.Printf("error: raw mode: %s\n", err)
+			exit(1)
+		}
+
+		defer restoreTerm()
+
+		if err := c.Map(cpu.KeyboardBase, cpu.KeyboardSize, cpu.NewKeyboard(os.Stdin, cpu.KeyboardIRQ)); err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if *errPath != "" {
+		f := create(*errPath)
+		defer f.Close()
+		c.SetErrorOutput(io.MultiWriter(os.Stderr, f))
+	}
+
+	var log *bufio.Writer
+	if *retire != "" {
+		f := create(*retire)
+		defer f.Close()
+		log = bufio.NewWriter(f)
+		c.SetRetireLog(log)
+	}
+
+	defer func() {
+		if log != nil {
+			log.Flush()
+		}
+	}()
+
+	if *scriptPath != "" {
+		f, err := os.Open(*scriptPath)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			exit(1)
+		}
+
+		var img *asm.Image
+		if !*raw {
+			img, _ = asm.ReadImage(buf)
+		}
+
+		err = runScript(&c, img, f, os.Stdout)
+		f.Close()
+
+		if err != nil {
+			out.Flush()
+			fmt.Printf("%s:%s\n", *scriptPath, err)
+			exit(1)
+		}
+
+		return
+	}
+
+	if *check > 0 {
+		if err := runCheck(&c, *check, *checkInputs, *checkSteps, *assert); err != nil {
+			fmt.Printf("check: %s\n", err)
+			exit(1)
+		}
+
+		return
+	}
+
+	if asserts != nil {
+		d := runAsserts(&c, asserts, &got)
+		out.Flush()
+
+		if log != nil {
+			log.Flush()
+		}
+
+		for _, j := range d {
+			fmt.Printf("assert: %s\n", j)
+		}
+
+		if len(d) > 0 {
+			exit(1)
+		}
+
+		return
+	}
+
+	if *cosim != "" {
+		if err := runCosim(&c, *cosim, flag.Arg(0)); err != nil {
+			out.Flush()
+			if log != nil {
+				log.Flush()
+			}
+			fmt.Printf("cosim: %s\n", err)
+			exit(1)
+		}
+
+		return
+	}
+
+	in := bufio.NewReader(os.Stdin)
+
 	for c.State() {
-		if err := c.Step(); err != nil {
+		if *verbose || *step {
+			if l, s := line(&c, c.Pc()); l.File != "" {
+				fmt.Fprintf(os.Stderr, "%08x %s:%d: %s\n", c.Pc(), l.File, l.Line, s)
+			} else {
+				fmt.Fprintf(os.Stderr, "%08x\n", c.Pc())
+			}
+		}
+
+		if *step {
+			if s, err := in.ReadString('\n'); err != nil || strings.TrimSpace(s) == "q" {
+				break
+			}
+		}
+
+		next := c.Step
+		if *step {
+			next = c.StepLine
+		}
+
+		if err := next(); err != nil {
+			out.Flush()
+			if log != nil {
+				log.Flush()
+			}
+			restoreTerm()
 			fmt.Printf("fatal: %s\n\n", err)
+			c.WritePanic(os.Stdout)
+			fmt.Println("")
 			c.WriteTrace(os.Stdout)
-			os.Exit(1)
+			if *stats {
+				writeStats(&c)
+			}
+			if *funcs {
+				c.WriteFuncs(os.Stderr)
+			}
+			if *smc {
+				c.WriteSelfModified(os.Stderr)
+			}
+			saveFS(fs, *fsOut)
+			saveWAV(beeper, *wav)
+			exit(1)
+		}
+
+		// Pause on a watchpoint until a line is read, as -step does.
+		if h, ok := c.Watched(); ok {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "watch: %s\n", h)
+
+			if s, err := in.ReadString('\n'); err != nil || strings.TrimSpace(s) == "q" {
+				break
+			}
+		}
+	}
+
+	if *stats {
+		writeStats(&c)
+	}
+
+	saveFS(fs, *fsOut)
+	saveWAV(beeper, *wav)
+
+	if *funcs {
+		c.WriteFuncs(os.Stderr)
+	}
+
+	if *smc {
+		c.WriteSelfModified(os.Stderr)
+	}
+
+	if dump != nil {
+		out.Flush()
+		c.WriteMem(os.Stdout, dump.Lo, dump.Hi)
+	}
+
+	if terms != nil {
+		if err := terms(c.Guest()); err != nil {
+			out.Flush()
+			fmt.Printf("assert: %s\n", err)
+			exit(1)
 		}
 	}
 }
+
+// loadFS adds the files of the zip or tar archive at path to fs.
+func loadFS(fs *cpu.FS, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".zip") {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		return fs.LoadZip(f, fi.Size())
+	}
+
+	return fs.LoadTar(f)
+}
+
+// saveFS writes fs to a tar archive at path, if not empty.
+func saveFS(fs *cpu.FS, path string) {
+	if path == "" {
+		return
+	}
+
+	f := create(path)
+	defer f.Close()
+
+	if err := fs.WriteTar(f); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+}
+
+// saveWAV writes the recording of b to the WAV file at path, if any.
+func saveWAV(b *cpu.Beeper, path string) {
+	if path == "" {
+		return
+	}
+
+	f := create(path)
+	defer f.Close()
+
+	if err := b.WriteWAV(f); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+}
+
+// create creates the file at path, exiting on failure.
+func create(path string) *os.File {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		exit(1)
+	}
+
+	return f
+}
+
+func writeStats(c *cpu.Cpu) {
+	fmt.Fprintf(os.Stderr, "steps: %d\n", c.Steps())
+	fmt.Fprintf(os.Stderr, "cycles: %d\n", c.Cycles())
+}
+
+// runCosim starts the reference simulator command with the image path
+// as its last argument and runs c in lockstep with it.
+func runCosim(c *cpu.Cpu, command, path string) error {
+	f := strings.Fields(command)
+	if len(f) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	cmd := exec.Command(f[0], append(f[1:], path)...)
+	cmd.Stderr = os.Stderr
+
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	ref := cpu.NewLineReference(w, r)
+	err = cpu.Lockstep(c, ref)
+
+	ref.Close()
+	w.Close()
+
+	if werr := cmd.Wait(); err == nil && werr != nil {
+		err = werr
+	}
+
+	return err
+}
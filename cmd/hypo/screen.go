@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rtcall/hypo/cpu"
+)
+
+// frame is the shortest time between two redraws of a screen.
+const frame = time.Second / 30
+
+// screen is a framebuffer that redraws itself on a terminal as the
+// guest writes to it.
+type screen struct {
+	*cpu.Framebuffer
+	w    io.Writer
+	last time.Time
+}
+
+// newScreen clears the terminal w and returns a screen drawing on it.
+func newScreen(w io.Writer) *screen {
+	fmt.Fprint(w, "\x1b[2J")
+	return &screen{Framebuffer: cpu.NewFramebuffer(), w: w}
+}
+
+// Tick redraws the screen at most once a frame, and only if it changed.
+func (s *screen) Tick(g cpu.Guest) {
+	if time.Since(s.last) >= frame {
+		s.draw(false)
+	}
+}
+
+// draw redraws the screen if it changed or if force is set, with a
+// border around it.
+func (s *screen) draw(force bool) {
+	lines, dirty := s.Lines()
+	if !dirty && !force {
+		return
+	}
+
+	s.last = time.Now()
+
+	b := bufio.NewWriter(s.w)
+	edge := "+" + strings.Repeat("-", cpu.FramebufferCols) + "+\n"
+
+	fmt.Fprint(b, "\x1b[H", edge)
+	for _, j := range lines {
+		fmt.Fprintf(b, "|%s|\n", j)
+	}
+
+	fmt.Fprint(b, edge)
+	b.Flush()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rtcall/hypo/asm"
+	"github.com/rtcall/hypo/cpu"
+)
+
+// A script drives the machine with a program in the language of
+// lang.go. Besides print, len, range, str, int, hex and fail, it has
+// these functions, where loc is an address or a label of the image's
+// symbol table:
+//
+//	breakpoint(loc)      make run stop before executing loc
+//	watch(kind, lo, hi)  make run stop after a kind ("r", "w" or "rw") access in lo:hi
+//	run()                run until a breakpoint, watchpoint, yield or exit, and
+//	                     return "break", "watch", "yield" or "exit"
+//	watched()            the access that last stopped run, or None
+//	step(n)              execute n instructions, or one without n
+//	reg(n)               the value of register n
+//	set_reg(n, v)        set register n
+//	load(loc)            the word at loc
+//	store(loc, v)        store the word v at loc
+//	addr(label)          the address of label
+//	pc()                 the address of the next instruction
+//	halted()             whether the machine has exited
+//	exit_code()          the status it exited with
+//
+// Words are unsigned; stored values keep their low 32 bits. A fault,
+// failed assert, error or call to fail stops the script.
+type script struct {
+	c      *cpu.Cpu
+	img    *asm.Image
+	breaks map[uint32]bool
+	hit    *cpu.Hit
+}
+
+func runScript(c *cpu.Cpu, img *asm.Image, r io.Reader, out io.Writer) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s := &script{c: c, img: img, breaks: make(map[uint32]bool)}
+	in := newInterp(out)
+	g := c.Guest()
+
+	in.define("breakpoint", func(args []value) (value, error) {
+		a, err := s.locArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		s.breaks[a] = true
+		return nil, nil
+	})
+
+	in.define("watch", func(args []value) (value, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("watch takes a kind, lo and hi")
+		}
+
+		kind, ok := map[value]int{
+			"r":  cpu.WatchRead,
+			"w":  cpu.WatchWrite,
+			"rw": cpu.WatchRead | cpu.WatchWrite,
+		}[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("bad watch kind %s", str(args[0]))
+		}
+
+		lo, err := s.loc(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		hi, err := s.loc(args[2])
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("bad watch range")
+		}
+
+		s.c.Watch(cpu.Range{Lo: lo, Hi: hi}, kind)
+		return nil, nil
+	})
+
+	in.define("run", func(args []value) (value, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("run takes no arguments")
+		}
+
+		return s.run()
+	})
+
+	in.define("watched", func(args []value) (value, error) {
+		if s.hit == nil {
+			return nil, nil
+		}
+
+		return s.hit.String(), nil
+	})
+
+	in.define("step", func(args []value) (value, error) {
+		n, err := ints(args)
+		if err != nil || len(n) > 1 {
+			return nil, fmt.Errorf("step takes an optional count")
+		}
+
+		k := int64(1)
+		if len(n) > 0 {
+			k = n[0]
+		}
+
+		for ; k > 0 && s.c.State(); k-- {
+			if err := s.c.Step(); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	in.define("reg", func(args []value) (value, error) {
+		n, err := ints(args)
+		if err != nil || len(n) != 1 {
+			return nil, fmt.Errorf("reg takes a register number")
+		}
+
+		v, err := g.Reg(int(n[0]))
+		return int64(v), err
+	})
+
+	in.define("set_reg", func(args []value) (value, error) {
+		n, err := ints(args)
+		if err != nil || len(n) != 2 {
+			return nil, fmt.Errorf("set_reg takes a register number and a value")
+		}
+
+		return nil, g.SetReg(int(n[0]), uint32(n[1]))
+	})
+
+	in.define("load", func(args []value) (value, error) {
+		a, err := s.locArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := g.Load(a)
+		return int64(v), err
+	})
+
+	in.define("store", func(args []value) (value, error) {
+		a, err := s.locArg(args, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := args[1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("store of %s", typeName(args[1]))
+		}
+
+		return nil, g.Store(a, uint32(v))
+	})
+
+	in.define("addr", func(args []value) (value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("addr takes a label")
+		}
+
+		l, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("addr of %s", typeName(args[0]))
+		}
+
+		a, err := s.loc(l)
+		return int64(a), err
+	})
+
+	in.define("pc", func(args []value) (value, error) {
+		return int64(s.c.Pc()), nil
+	})
+
+	in.define("halted", func(args []value) (value, error) {
+		return !s.c.State(), nil
+	})
+
+	in.define("exit_code", func(args []value) (value, error) {
+		return int64(s.c.ExitCode()), nil
+	})
+
+	return in.run(string(src))
+}
+
+// run runs the machine until it stops, returning why.
+func (s *script) run() (value, error) {
+	s.hit = nil
+
+	for first := true; s.c.State(); first = false {
+		if !first && s.breaks[s.c.Pc()] {
+			return "break", nil
+		}
+
+		if err := s.c.Step(); err != nil {
+			return nil, err
+		}
+
+		if h, ok := s.c.Watched(); ok {
+			s.hit = &h
+			return "watch", nil
+		}
+
+		if s.c.Yielded() {
+			return "yield", nil
+		}
+	}
+
+	return "exit", nil
+}
+
+// locArg resolves the first of the n arguments args must have.
+func (s *script) locArg(args []value, n int) (uint32, error) {
+	if len(args) != n {
+		return 0, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+
+	return s.loc(args[0])
+}
+
+// loc resolves an address or a label.
+func (s *script) loc(v value) (uint32, error) {
+	switch a := v.(type) {
+	case int64:
+		if a < 0 || a > 0xffffffff {
+			return 0, fmt.Errorf("bad address %d", a)
+		}
+
+		return uint32(a), nil
+	case string:
+		if s.img != nil {
+			for _, j := range s.img.Objects {
+				if j.Name == a {
+					return j.Addr, nil
+				}
+			}
+		}
+
+		return 0, fmt.Errorf("no label '%s'", a)
+	}
+
+	return 0, fmt.Errorf("location is %s, not int or label", typeName(v))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rtcall/hypo/asm"
+	"github.com/rtcall/hypo/cpu"
+)
+
+// scriptProg counts %0 up to 3 in buf, yielding after each store.
+const scriptProg = `
+main:
+    lr $0 %0
+loop:
+    addi %0 $1 %0
+    lr buf %1
+    st %1 %0
+    yield
+    lr $3 %2
+    blt %0 %2 loop
+done:
+    exit %0
+
+.data
+buf: .word $0
+`
+
+// runTestScript runs src against a fresh machine running scriptProg,
+// returning what it printed.
+func runTestScript(t *testing.T, src string) (string, error) {
+	var img bytes.Buffer
+
+	w := asm.NewWriter(&img)
+	w.SetDebug("prog.s")
+
+	if _, err := w.Gen(strings.NewReader(scriptProg), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cpu.New(img.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetOutput(io.Discard)
+
+	m, err := asm.ReadImage(img.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = runScript(&c, m, strings.NewReader(src), &out)
+	return out.String(), err
+}
+
+func TestScript(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"run to yield", "print(run(), reg(0), load('buf'), halted())", "yield 1 1 False\n"},
+		{"run to exit", "while run() == 'yield':\n    pass\nprint(halted(), exit_code(), load(0))", "True 3 3\n"},
+		{"run after exit", "while run() != 'exit':\n    pass\nprint(run())", "exit\n"},
+		{"breakpoint", "breakpoint('done')\nr = run()\nwhile r == 'yield':\n    r = run()\nprint(r, pc() == addr('done'), reg(0))\nprint(run(), exit_code())",
+			"break True 3\nexit 3\n"},
+		{"breakpoint at address", "breakpoint(addr('loop'))\nprint(run(), run(), pc() == addr('loop'))", "break yield False\n"},
+		{"watch", "watch('w', 'buf', addr('buf') + 4)\nprint(watched())\nprint(run(), pc())\nprint(watched())",
+			"None\nwatch 22\npc 00000013: 4-byte write at 00000000: 00000001\n"},
+		{"watch read ignores writes", "watch('r', 0, 4)\nprint(run(), run(), run(), run())", "yield yield yield exit\n"},
+		{"step", "step(3)\nprint(pc(), reg(0), reg(1))\nstep()\nprint(pc(), load('buf'))", "19 1 0\n22 1\n"},
+		{"step past exit", "step(100)\nprint(halted())", "True\n"},
+		{"set_reg", "step(4)\nset_reg(0, 2)\nprint(run(), reg(0), load('buf'))\nwhile run() != 'exit':\n    pass\nprint(reg(0), load('buf'))", "yield 2 1\n3 3\n"},
+		{"store", "store('buf', -1)\nprint(load('buf'), load(0) == 0xffffffff)", "4294967295 True\n"},
+		{"labels", "print(addr('main'), addr('loop'), addr('buf'))", "0 6 0\n"},
+	}
+
+	for _, j := range tests {
+		got, err := runTestScript(t, j.src)
+		if err != nil {
+			t.Errorf("%s: %s", j.name, err)
+		} else if got != j.want {
+			t.Errorf("%s: got %q, want %q", j.name, got, j.want)
+		}
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"breakpoint('nope')", "1: no label 'nope'"},
+		{"breakpoint()", "1: expected 1 arguments, got 0"},
+		{"breakpoint([1])", "1: location is list, not int or label"},
+		{"load(-1)", "1: bad address -1"},
+		{"load(0x100000000)", "1: bad address 4294967296"},
+		{"watch('x', 0, 4)", "1: bad watch kind x"},
+		{"watch('r', 8, 4)", "1: bad watch range"},
+		{"watch('r', 0)", "1: watch takes a kind, lo and hi"},
+		{"run(1)", "1: run takes no arguments"},
+		{"step('a')", "1: step takes an optional count"},
+		{"step(1, 2)", "1: step takes an optional count"},
+		{"reg()", "1: reg takes a register number"},
+		{"set_reg(1)", "1: set_reg takes a register number and a value"},
+		{"store('buf')", "1: expected 2 arguments, got 1"},
+		{"store('buf', 'a')", "1: store of string"},
+		{"addr(1)", "1: addr of int"},
+		{"addr()", "1: addr takes a label"},
+		{"print('a')\nstep(3)\nset_reg(1, 0x7fffffff)\nstep()\nprint('b')", "4: illegal write 00000001 (at 7fffffff)"},
+	}
+
+	for _, j := range tests {
+		_, err := runTestScript(t, j.src)
+		if err == nil {
+			t.Errorf("%q succeeded, want %s", j.src, j.want)
+		} else if err.Error() != j.want {
+			t.Errorf("%q: %s, want %s", j.src, err, j.want)
+		}
+	}
+}
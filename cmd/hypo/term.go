@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+)
+
+// restoreTerm undoes rawMode. It does nothing if the terminal is not in
+// raw mode.
+var restoreTerm = func() {}
+
+// rawMode makes the terminal on stdin pass every key on as it is
+// typed, without echoing it, until restoreTerm is called. Signals such
+// as ^C still work, and restore the terminal before exiting.
+func rawMode() error {
+	saved, err := stty("-g")
+	if err != nil {
+		return err
+	}
+
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return err
+	}
+
+	var once sync.Once
+	restoreTerm = func() {
+		once.Do(func() { stty(strings.TrimSpace(saved)) })
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	go func() {
+		<-sig
+		exit(130)
+	}()
+
+	return nil
+}
+
+// exit restores the terminal and exits with code. hypo calls it rather
+// than os.Exit, which skips deferred calls.
+func exit(code int) {
+	restoreTerm()
+	os.Exit(code)
+}
+
+// stty runs stty on the terminal on stdin and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+
+	b, err := cmd.Output()
+	return string(b), err
+}
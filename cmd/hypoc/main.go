@@ -1,19 +1,196 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/rtcall/hypo/asm"
 )
 
+// writeSizes prints the size of each symbol and the total held by
+// symbols that nothing refers to.
+func writeSizes(s []asm.SymbolSize) {
+	var dead, n uint32
+
+	for _, j := range s {
+		note := ""
+		if !j.Used {
+			note = " (unreferenced)"
+			dead += j.Size
+			n++
+		}
+
+		fmt.Printf("%-6s %08x %6d %s%s\n", []string{".text", ".data", ".bss"}[j.Kind], j.Addr, j.Size, j.Name, note)
+	}
+
+	fmt.Printf("unreferenced: %d bytes in %d symbols\n", dead, n)
+}
+
+// list collects the values of a repeatable flag.
+type list []string
+
+func (d *list) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *list) Set(s string) error {
+	*d = append(*d, s)
+	return nil
+}
+
+// joined returns args with each -DNAME=value and -Idir among the flags
+// rewritten as -D=NAME=value and -I=dir, which package flag accepts as
+// well as -D NAME=value and -I dir. Values of other flags and the
+// arguments from the first that is not a flag are left alone.
+func joined(args []string) []string {
+	r := append([]string(nil), args...)
+
+	for i := 0; i < len(r); i++ {
+		j := r[i]
+		if j == "--" || len(j) < 2 || j[0] != '-' {
+			break
+		}
+
+		if (strings.HasPrefix(j, "-D") || strings.HasPrefix(j, "-I")) && len(j) > 2 && j[2] != '=' {
+			r[i] = j[:2] + "=" + j[2:]
+			continue
+		}
+
+		// Skip the value of a flag given as a separate argument.
+		name := strings.TrimLeft(j, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+
+		if f := flag.Lookup(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+			}
+		}
+	}
+
+	return r
+}
+
+// define makes each NAME=value of d a constant in w. A NAME without
+// value is defined as $1.
+func define(w *asm.Writer, d list) error {
+	for _, j := range d {
+		name, val, ok := strings.Cut(j, "=")
+		if !ok {
+			val = "$1"
+		}
+
+		e, err := asm.ParseExpr(val)
+		if err != nil {
+			return fmt.Errorf("-D%s: %s", j, err)
+		}
+
+		if err := w.Define(name, e); err != nil {
+			return fmt.Errorf("-D%s: %s", j, err)
+		}
+	}
+
+	return nil
+}
+
+// writeLiveness prints the register usage of every routine of m,
+// named after the labels of w, and how it breaks the standard ABI.
+func writeLiveness(m *asm.Image, w *asm.Writer) {
+	names := make(map[uint32]string)
+	for _, j := range w.Sizes() {
+		if _, ok := names[j.Addr]; !ok && j.Kind == asm.SectText {
+			names[j.Addr] = j.Name
+		}
+	}
+
+	regs := func(r []byte) string {
+		s := make([]string, len(r))
+		for i, j := range r {
+			s[i] = fmt.Sprintf("%%%d", j)
+		}
+
+		return strings.Join(s, " ")
+	}
+
+	for _, j := range asm.Liveness(m.Code, 0, m.Entry, asm.StdABI) {
+		name, ok := names[j.Addr]
+		if !ok {
+			name = fmt.Sprintf("%08x", j.Addr)
+		}
+
+		fmt.Printf("%-16s in: %-12s out: %-12s clobbers: %s\n", name, regs(j.LiveIn), regs(j.LiveOut), regs(j.Clobbers))
+
+		for _, k := range j.Problems {
+			fmt.Printf("%s: warning: %s\n", name, k)
+		}
+	}
+}
+
+// writeXrefs prints the definition line and referring lines of
+// every label and constant.
+func writeXrefs(x []asm.Xref) {
+	for _, j := range x {
+		refs := make([]string, len(j.Refs))
+		for i, k := range j.Refs {
+			refs[i] = position(k)
+		}
+
+		fmt.Println(strings.TrimRight(fmt.Sprintf("%-16s %5s  %s", j.Name, position(j.Def), strings.Join(refs, " ")), " "))
+	}
+}
+
+func position(p asm.Pos) string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d", p.File, p.Line)
+	}
+
+	return strconv.Itoa(p.Line)
+}
+
 func main() {
+	var defs, dirs list
+	flag.Var(&defs, "D", "define NAME=value as a constant, as in -DNAME=$10 (repeatable)")
+	flag.Var(&dirs, "I", "search dir for .include files (repeatable)")
+
 	outPath := flag.String("o", "out", "output path")
-	flag.Parse()
+	format := flag.String("f", "hyp", "output format (hyp, ihex, bin, elf)")
+	debug := flag.Bool("g", false, "include source line information")
+	opt := flag.Bool("O", false, "enable the peephole optimizer")
+	strict := flag.Bool("strict", true, "reject identifiers used as registers")
+	fold := flag.Bool("i", false, "accept mnemonics and directives in any case")
+	regs := flag.Int("regs", asm.NumRegs, "number of registers of the target machine (8, 16 or 32)")
+	size := flag.Bool("size", false, "print the size of every symbol")
+	xref := flag.Bool("xref", false, "print where every label is defined and referenced")
+	live := flag.Bool("live", false, "print the registers each routine reads, returns and clobbers")
+	inline := flag.Int("inline", 0, "inline calls to leaf routines of up to n bytes")
+	isa := flag.Bool("isa", false, "print the instruction set as JSON and exit")
+	vn := flag.Bool("von-neumann", false, "place data after the code, for hypo -von-neumann")
+
+	flag.CommandLine.Parse(joined(os.Args[1:]))
+
+	if *isa {
+		if err := asm.WriteISA(os.Stdout); err != nil {
+			fmt.Printf("%s\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
 
 	if len(flag.Args()) == 0 {
-		fmt.Printf("usage: %s [-o path] file\n", os.Args[0])
+		fmt.Printf("usage: %s [-o path] [-f format] [-g] [-O] [-i] [-strict=false] [-Dname=value] [-I dir] file\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "hyp", "ihex", "bin", "elf":
+	default:
+		fmt.Printf("%s: unknown format\n", *format)
 		os.Exit(1)
 	}
 
@@ -36,11 +213,77 @@ func main() {
 
 	defer in.Close()
 
-	_, err = asm.Gen(in, f, os.Stderr)
+	var out bytes.Buffer
+
+	w := asm.NewWriter(&out)
+	w.SetFile(inPath)
+
+	if *debug {
+		w.SetDebug(inPath)
+	}
+
+	w.SetOptimize(*opt)
+	w.SetInline(*inline)
+
+	if err := define(w, defs); err != nil {
+		f.Close()
+		os.Remove(*outPath)
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	w.SetOptions(asm.Options{
+		Strict:       *strict,
+		FoldCase:     *fold,
+		Regs:         *regs,
+		IncludePaths: dirs,
+		VonNeumann:   *vn,
+	})
+
+	_, err = w.Gen(in, os.Stderr)
 	if err != nil {
 		f.Close()
 		os.Remove(*outPath)
 		fmt.Printf("%s: %s\n", inPath, err)
 		os.Exit(1)
 	}
+
+	m, err := asm.ReadImage(out.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	if *size {
+		writeSizes(w.Sizes())
+	}
+
+	if *xref {
+		writeXrefs(w.Xrefs())
+	}
+
+	if *live {
+		writeLiveness(m, w)
+	}
+
+	if (*format == "bin" || *format == "ihex") && len(m.Sections) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s output omits data and bss sections\n", inPath, *format)
+	}
+
+	switch *format {
+	case "hyp":
+		_, err = f.Write(out.Bytes())
+	case "bin":
+		_, err = f.Write(m.Code)
+	case "ihex":
+		err = asm.WriteHex(f, m.Code)
+	case "elf":
+		m.Objects = w.Objects()
+		err = asm.WriteELF(f, m)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(*outPath)
+		fmt.Printf("%s: %s\n", *outPath, err)
+		os.Exit(1)
+	}
 }
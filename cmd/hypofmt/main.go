@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+func main() {
+	write := flag.Bool("w", false, "write the result to the source file")
+	list := flag.Bool("l", false, "list files whose formatting differs")
+	flag.Parse()
+
+	if len(flag.Args()) == 0 {
+		fmt.Printf("usage: %s [-w] [-l] file...\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	status := 0
+
+	for _, path := range flag.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			status = 1
+			continue
+		}
+
+		out, err := asm.Format(src)
+		if err != nil {
+			fmt.Printf("%s: %s\n", path, err)
+			status = 1
+			continue
+		}
+
+		if *list {
+			if !bytes.Equal(src, out) {
+				fmt.Println(path)
+				status = 1
+			}
+
+			continue
+		}
+
+		if *write {
+			if !bytes.Equal(src, out) {
+				if err := os.WriteFile(path, out, 0644); err != nil {
+					fmt.Printf("error: %s\n", err)
+					status = 1
+				}
+			}
+
+			continue
+		}
+
+		os.Stdout.Write(out)
+	}
+
+	os.Exit(status)
+}
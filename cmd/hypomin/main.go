@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rtcall/hypo/asm"
+	"github.com/rtcall/hypo/cpu"
+)
+
+// unit is a single instruction in the image, by offset and length.
+type unit struct {
+	off, n int
+}
+
+func decode(code []byte) (u []unit) {
+	for i := 0; i < len(code); {
+		n := asm.Size(code[i])
+		if n == 0 || i+n > len(code) {
+			n = 1
+		}
+
+		u = append(u, unit{i, n})
+		i += n
+	}
+
+	return u
+}
+
+// run executes buf for at most limit steps and returns the fault, if
+// any. Running out of steps is not a fault.
+func run(buf []byte, limit int) error {
+	c, err := cpu.New(buf)
+	if err != nil {
+		return err
+	}
+
+	c.SetOutput(io.Discard)
+	c.SetErrorOutput(io.Discard)
+
+	for i := 0; c.State() && i < limit; i++ {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// build returns m encoded with every instruction not in keep
+// replaced by nops.
+func build(m *asm.Image, all, keep []unit) []byte {
+	code := append([]byte(nil), m.Code...)
+	k := make(map[int]bool)
+
+	for _, u := range keep {
+		k[u.off] = true
+	}
+
+	for _, u := range all {
+		if !k[u.off] {
+			for i := u.off; i < u.off+u.n; i++ {
+				code[i] = asm.OpNop
+			}
+		}
+	}
+
+	n := *m
+	n.Code = code
+	return n.Bytes()
+}
+
+// ddmin reduces u to a smaller set for which fails still holds.
+func ddmin(u []unit, fails func([]unit) bool) []unit {
+	n := 2
+
+	for len(u) >= 2 {
+		chunk := (len(u) + n - 1) / n
+		reduced := false
+
+		for i := 0; i < len(u); i += chunk {
+			end := i + chunk
+			if end > len(u) {
+				end = len(u)
+			}
+
+			c := append(append([]unit(nil), u[:i]...), u[end:]...)
+			if fails(c) {
+				u = c
+				if n > 2 {
+					n--
+				}
+				reduced = true
+				break
+			}
+		}
+
+		if !reduced {
+			if n >= len(u) {
+				break
+			}
+
+			n *= 2
+			if n > len(u) {
+				n = len(u)
+			}
+		}
+	}
+
+	if len(u) == 1 && fails(nil) {
+		return nil
+	}
+
+	return u
+}
+
+func main() {
+	outPath := flag.String("o", "min", "output path")
+	steps := flag.Int("steps", 1000000, "maximum steps per run")
+	flag.Parse()
+
+	if len(flag.Args()) == 0 {
+		fmt.Printf("usage: %s [-o path] [-steps n] file\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	buf, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		os.Exit(1)
+	}
+
+	fault := run(buf, *steps)
+	if fault == nil {
+		fmt.Printf("%s: program does not fault\n", flag.Arg(0))
+		os.Exit(1)
+	}
+
+	m, err := asm.ReadImage(buf)
+	if err != nil {
+		fmt.Printf("%s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	all := decode(m.Code)
+	keep := ddmin(all, func(k []unit) bool {
+		err := run(build(m, all, k), *steps)
+		return err != nil && err.Error() == fault.Error()
+	})
+
+	if err := os.WriteFile(*outPath, build(m, all, keep), 0644); err != nil {
+		fmt.Printf("error: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("fault: %s\n", fault)
+	fmt.Printf("kept %d of %d instructions\n", len(keep), len(all))
+}
@@ -0,0 +1,41 @@
+package cpu
+
+// The atomic instructions read and write a memory word in a single
+// step, so no other guest code can run between the two accesses.
+
+// cas handles 'cas %a %e %n': if the word at %a equals %e, it is
+// replaced by %n. Either way %e receives the word that was read, and Z
+// is set only if the word was replaced.
+func cas(c *Cpu, a []uint32) {
+	addr, want := c.readReg(byte(a[0])), c.readReg(byte(a[1]))
+
+	v, err := c.readImm(addr)
+	if c.err = err; err != nil {
+		return
+	}
+
+	c.status &= FlagI | FlagU
+	if v == want {
+		if c.err = c.writeImm(addr, c.readReg(byte(a[2]))); c.err != nil {
+			return
+		}
+
+		c.status |= FlagZ
+	}
+
+	c.writeReg(byte(a[1]), v)
+}
+
+// swap handles 'swap %a %r', which exchanges %r with the word at %a.
+func swap(c *Cpu, a []uint32) {
+	addr := c.readReg(byte(a[0]))
+
+	v, err := c.readImm(addr)
+	if c.err = err; err != nil {
+		return
+	}
+
+	if c.err = c.writeImm(addr, c.readReg(byte(a[1]))); c.err == nil {
+		c.writeReg(byte(a[1]), v)
+	}
+}
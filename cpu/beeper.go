@@ -0,0 +1,99 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// BeeperBase is where hypo maps a Beeper.
+const BeeperBase = 0xf0000800
+
+// Beeper registers, as offsets from the base of a Beeper.
+const (
+	BeeperFreq     = 0x0 // the frequency of the tone in Hz, 0 for silence
+	BeeperDuration = 0x4 // write a duration in milliseconds to sound the tone
+)
+
+// BeeperSize is the size of the registers of a Beeper.
+const BeeperSize = 0x8
+
+// SampleRate is the number of samples a second recorded by a Beeper.
+const SampleRate = 22050
+
+// maxRecording bounds the length of a Beeper recording, in samples.
+const maxRecording = 10 * 60 * SampleRate
+
+// Beeper is a square wave sound Device. Each duration written appends
+// a tone of the current frequency to a recording, which WriteWAV
+// saves; tones follow each other whatever the time between writes.
+type Beeper struct {
+	freq    uint32
+	samples []byte
+}
+
+// NewBeeper returns a Beeper with an empty recording.
+func NewBeeper() *Beeper {
+	return &Beeper{}
+}
+
+func (b *Beeper) Read32(addr uint32) (uint32, error) {
+	if addr == BeeperFreq {
+		return b.freq, nil
+	}
+
+	return 0, nil
+}
+
+func (b *Beeper) Write32(addr, val uint32) error {
+	switch addr {
+	case BeeperFreq:
+		b.freq = val
+	case BeeperDuration:
+		return b.tone(uint64(val) * SampleRate / 1000)
+	}
+
+	return nil
+}
+
+// tone appends n samples of a square wave at the current frequency.
+func (b *Beeper) tone(n uint64) error {
+	if uint64(len(b.samples))+n > maxRecording {
+		return fmt.Errorf("beeper: recording longer than %d seconds", maxRecording/SampleRate)
+	}
+
+	for i := uint64(0); i < n; i++ {
+		v := byte(128)
+		if b.freq != 0 && b.freq < SampleRate/2 {
+			v = 96
+			if i*uint64(b.freq)*2/SampleRate%2 == 0 {
+				v = 160
+			}
+		}
+
+		b.samples = append(b.samples, v)
+	}
+
+	return nil
+}
+
+// WriteWAV writes the recording to w as a mono 8-bit WAV file.
+func (b *Beeper) WriteWAV(w io.Writer) error {
+	n := uint32(len(b.samples))
+
+	hdr := []any{
+		[]byte("RIFF"), 36 + n, []byte("WAVE"),
+		[]byte("fmt "), uint32(16), uint16(1), uint16(1),
+		uint32(SampleRate), uint32(SampleRate), uint16(1), uint16(8),
+		[]byte("data"), n,
+	}
+
+	for _, j := range hdr {
+		if err := binary.Write(w, binary.LittleEndian, j); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(b.samples)
+	return err
+}
@@ -0,0 +1,100 @@
+package cpu
+
+import "fmt"
+
+// The MMIO window holds the addresses from IOBase up to IOEnd, far
+// above memory. Word loads and stores in the window go to the Device
+// mapped at the address.
+const (
+	IOBase = 0xf0000000
+	IOEnd  = 0xffff0000
+)
+
+// Device is a peripheral mapped into the MMIO window. Addresses passed
+// to it are offsets from the start of its range. A non-nil error
+// faults the guest.
+type Device interface {
+	Read32(addr uint32) (uint32, error)
+	Write32(addr, val uint32) error
+}
+
+// Ticker is implemented by devices that act on their own, such as
+// timers. Their Tick method runs after every instruction, with a Guest
+// that accesses memory at physical addresses in any mode, as DMA does.
+type Ticker interface {
+	Tick(g Guest)
+}
+
+// mapping is a Device mapped at a range of the MMIO window.
+type mapping struct {
+	Range
+	dev Device
+}
+
+// Map makes d handle the size bytes of the MMIO window starting at
+// base, which must be word aligned and must not overlap another
+// device.
+func (c *Cpu) Map(base, size uint32, d Device) error {
+	if base < IOBase || base >= IOEnd || size == 0 || size > IOEnd-base {
+		return fmt.Errorf("device at %08x+%x is outside the MMIO window", base, size)
+	}
+
+	if base%4 != 0 || size%4 != 0 {
+		return fmt.Errorf("device at %08x+%x is not word aligned", base, size)
+	}
+
+	r := Range{base, base + size}
+	for _, j := range c.devs {
+		if r.Lo < j.Hi && j.Lo < r.Hi {
+			return fmt.Errorf("device at %08x overlaps device at %08x", base, j.Lo)
+		}
+	}
+
+	c.devs = append(c.devs, mapping{r, d})
+	if t, ok := d.(Ticker); ok {
+		c.ticks = append(c.ticks, t)
+	}
+
+	return nil
+}
+
+// device returns the device mapped at addr and the offset of addr in
+// its range.
+func (c *Cpu) device(addr uint32) (Device, uint32, bool) {
+	for _, j := range c.devs {
+		if j.Contains(addr) {
+			return j.dev, addr - j.Lo, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+// ioRead performs an n byte load from the MMIO window.
+func (c *Cpu) ioRead(addr, n uint32) (uint32, error) {
+	d, off, ok := c.device(addr)
+	if !ok || n != 4 || addr%4 != 0 {
+		return 0, fmt.Errorf("illegal read %08x", addr)
+	}
+
+	c.access(addr, n)
+	return d.Read32(off)
+}
+
+// ioWrite performs an n byte store of v to the MMIO window.
+func (c *Cpu) ioWrite(addr, v, n uint32) error {
+	d, off, ok := c.device(addr)
+	if !ok || n != 4 || addr%4 != 0 {
+		return fmt.Errorf("illegal write %08x (at %08x)", v, addr)
+	}
+
+	c.access(addr, n)
+	return d.Write32(off, v)
+}
+
+// tick runs the Tick method of every device that has one.
+func (c *Cpu) tick() {
+	for _, j := range c.ticks {
+		j.Tick(Guest{c, true})
+	}
+}
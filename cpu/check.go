@@ -0,0 +1,117 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AssertHypercall is the conventional hypercall number for Assert.
+const AssertHypercall = 0xa55e
+
+// Assert is a Hypercall that faults the guest if register 0 is zero.
+func Assert(g Guest) error {
+	if v, _ := g.Reg(0); v == 0 {
+		return errors.New("assertion failed")
+	}
+
+	return nil
+}
+
+// Check bounds the paths explored by Cpu.Check.
+type Check struct {
+	Regs   int    // registers given an input at each yield
+	Values uint32 // inputs range over 0 to Values-1
+	Depth  int    // maximum number of yields along a path
+	Steps  uint64 // maximum number of steps along a path
+
+	// Assert, if not nil, is checked on every path that exits.
+	Assert func(g Guest) error
+}
+
+// Violation is a path on which the guest faulted or an assertion
+// failed. Inputs holds the values the guest was resumed with at each
+// yield.
+type Violation struct {
+	Inputs [][]uint32
+	Err    error
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s (inputs %v)", v.Err, v.Inputs)
+}
+
+// Check explores every execution of c in which each yield is resumed
+// with every combination of inputs allowed by k, backtracking with
+// Snapshot. Paths that reach the depth or step bound are cut off
+// without being checked. It returns the number of paths explored and
+// a *Violation for the first failing one.
+func (c *Cpu) Check(k Check) (int, error) {
+	if k.Regs < 0 || k.Regs > c.nreg {
+		return 0, fmt.Errorf("bad register count %d", k.Regs)
+	}
+
+	return c.explore(&k, nil, c.cost.steps+k.Steps)
+}
+
+func (c *Cpu) explore(k *Check, inputs [][]uint32, limit uint64) (int, error) {
+	c.yield = false
+
+	for c.State() && !c.yield {
+		if c.cost.steps >= limit {
+			return 1, nil
+		}
+
+		if err := c.Step(); err != nil {
+			return 1, &Violation{inputs, err}
+		}
+	}
+
+	if !c.yield {
+		if k.Assert != nil {
+			if err := k.Assert(Guest{c: c}); err != nil {
+				return 1, &Violation{inputs, err}
+			}
+		}
+
+		return 1, nil
+	}
+
+	if len(inputs) >= k.Depth {
+		return 1, nil
+	}
+
+	s := c.Snapshot()
+	vals := make([]uint32, k.Regs)
+	n := 0
+
+	for {
+		c.Restore(s)
+		copy(c.reg[:], vals)
+
+		in := append(inputs[:len(inputs):len(inputs)], append([]uint32(nil), vals...))
+		m, err := c.explore(k, in, limit)
+		n += m
+
+		if err != nil {
+			return n, err
+		}
+
+		if !next(vals, k.Values) {
+			return n, nil
+		}
+	}
+}
+
+// next advances vals to the next combination of values below max,
+// reporting false once every combination has been seen.
+func next(vals []uint32, max uint32) bool {
+	for i := range vals {
+		if vals[i]++; vals[i] < max {
+			return true
+		}
+
+		vals[i] = 0
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Regs is the architectural state compared during co-simulation.
+type Regs struct {
+	Pc  uint32
+	Reg [MaxRegs]uint32
+}
+
+// Reference is an external model of the machine. Step executes one
+// instruction and returns the resulting state.
+type Reference interface {
+	Step() (Regs, error)
+}
+
+// Regs returns the current architectural state of c.
+func (c *Cpu) Regs() Regs {
+	return Regs{c.pc, c.reg}
+}
+
+// Lockstep runs c and ref one instruction at a time until c halts,
+// returning an error at the first step where their states differ.
+func Lockstep(c *Cpu, ref Reference) error {
+	for n := uint64(1); c.State(); n++ {
+		pc := c.pc
+
+		if err := c.Step(); err != nil {
+			return err
+		}
+
+		r, err := ref.Step()
+		if err != nil {
+			return fmt.Errorf("reference: %s", err)
+		}
+
+		if g := c.Regs(); r != g {
+			return fmt.Errorf("step %d (pc %08x): %s", n, pc, diffRegs(g, r))
+		}
+	}
+
+	return nil
+}
+
+func diffRegs(g, r Regs) string {
+	var d []string
+
+	if g.Pc != r.Pc {
+		d = append(d, fmt.Sprintf("pc %08x != %08x", g.Pc, r.Pc))
+	}
+
+	for i := range g.Reg {
+		if g.Reg[i] != r.Reg[i] {
+			d = append(d, fmt.Sprintf("%%%d %08x != %08x", i, g.Reg[i], r.Reg[i]))
+		}
+	}
+
+	return strings.Join(d, ", ")
+}
+
+// LineReference speaks a line protocol to an external simulator. For
+// each step it writes "s\n" to w and expects a line of hex words on r:
+// the pc followed by the registers from 0, nine words in all for the
+// standard machine. Registers not given are taken as zero.
+type LineReference struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func NewLineReference(w io.Writer, r io.Reader) *LineReference {
+	return &LineReference{w, bufio.NewReader(r)}
+}
+
+func (l *LineReference) Step() (s Regs, err error) {
+	if _, err = io.WriteString(l.w, "s\n"); err != nil {
+		return s, err
+	}
+
+	line, err := l.r.ReadString('\n')
+	if err != nil {
+		return s, err
+	}
+
+	f := strings.Fields(line)
+	if len(f) < 2 || len(f) > 1+len(s.Reg) {
+		return s, fmt.Errorf("bad state '%s'", strings.TrimSpace(line))
+	}
+
+	v := make([]uint32, len(f))
+	for i, j := range f {
+		n, err := strconv.ParseUint(j, 16, 32)
+		if err != nil {
+			return s, fmt.Errorf("bad value '%s'", j)
+		}
+
+		v[i] = uint32(n)
+	}
+
+	s.Pc = v[0]
+	copy(s.Reg[:], v[1:])
+	return s, nil
+}
+
+// Close tells the simulator to quit.
+func (l *LineReference) Close() error {
+	_, err := io.WriteString(l.w, "q\n")
+	return err
+}
@@ -0,0 +1,64 @@
+package cpu
+
+import "errors"
+
+// ErrBudget is the fault of a Cpu that has executed its budget of
+// instructions.
+var ErrBudget = errors.New("instruction budget exceeded")
+
+type costs struct {
+	op     map[byte]uint64
+	hcall  map[uint32]uint64
+	steps  uint64
+	cycles uint64
+	budget uint64
+}
+
+// SetCost sets the number of cycles charged for executing op. By
+// default every instruction costs one cycle.
+func (c *Cpu) SetCost(op byte, n uint64) {
+	if c.cost.op == nil {
+		c.cost.op = make(map[byte]uint64)
+	}
+
+	c.cost.op[op] = n
+}
+
+// SetHypercallCost sets the cycles charged for servicing hypercall n,
+// on top of the cost of the hcall instruction itself. The default is
+// zero.
+func (c *Cpu) SetHypercallCost(n uint32, cycles uint64) {
+	if c.cost.hcall == nil {
+		c.cost.hcall = make(map[uint32]uint64)
+	}
+
+	c.cost.hcall[n] = cycles
+}
+
+// SetBudget limits c to n instructions in all: once it has executed
+// n, Step faults with ErrBudget instead of running another, so that a
+// program looping forever cannot hang the host. Zero, the default,
+// removes the limit.
+func (c *Cpu) SetBudget(n uint64) {
+	c.cost.budget = n
+}
+
+// Steps returns the number of instructions executed.
+func (c *Cpu) Steps() uint64 {
+	return c.cost.steps
+}
+
+// Cycles returns the number of cycles charged so far.
+func (c *Cpu) Cycles() uint64 {
+	return c.cost.cycles
+}
+
+func (c *Cpu) charge(op byte) {
+	c.cost.steps++
+
+	if n, ok := c.cost.op[op]; ok {
+		c.cost.cycles += n
+	} else {
+		c.cost.cycles++
+	}
+}
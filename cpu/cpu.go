@@ -1,45 +1,145 @@
 package cpu
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
+	"math/bits"
+	"os"
 
 	"github.com/rtcall/hypo/asm"
 )
 
 type Cpu struct {
-	reg   [8]uint32
-	mem   [8192]byte
-	pc    uint32
-	flags uint32
-	err   error
-	buf   *bytes.Reader
+	reg    [MaxRegs]uint32
+	nreg   int
+	mem    *[8192]byte
+	pc     uint32
+	flags  uint32
+	err    error
+	fetch  uint32
+	base   uint32
+	img    asm.Image
+	tr     tracer
+	out    io.Writer
+	errOut io.Writer
+	in     io.Reader
+	hcall  map[uint32]Hypercall
+	yield  bool
+	cost   costs
+	last   uint32
+	acc    []Range
+	wrote  uint32
+	prof   *profile
+	jumped bool
+	args   [8]uint32
+	expl   io.Writer
+	input  Range
+	stack  Range
+	heap   uint32
+	status uint32
+	devs   []mapping
+	ticks  []Ticker
+	irq    uint32
+	sys    SyscallHandler
+	code   uint32
+	vn     bool
+	prot   []protection
+	cr     [NumCr]uint32
+	thr    *Threads
+	hook   hooks
+	watch  []watchpoint
+	hit    *Hit
+	smc    *selfModify
 }
 
+// New returns a Cpu running the image buf. The code in buf is not
+// copied, so any number of Cpus may share one loaded image; buf must
+// not be modified while they run.
 func New(buf []byte) (c Cpu, err error) {
-	c.buf = bytes.NewReader(buf)
+	m, err := asm.ReadImage(buf)
+	if err != nil {
+		return c, err
+	}
+
+	c.img = *m
+	c.mem = new([8192]byte)
+	c.out = os.Stdout
+	c.errOut = os.Stderr
+	c.in = os.Stdin
+	c.nreg = NumRegs
+
+	for _, j := range m.Sections {
+		if uint64(j.Addr)+uint64(j.Size) > uint64(len(c.mem)) {
+			return c, fmt.Errorf("section at %08x does not fit in memory", j.Addr)
+		}
 
-	hdr := make([]byte, len(asm.Hdr))
-	if _, err = c.buf.Read(hdr); err != nil {
-		return c, errors.New("could not read header")
-	} else if !bytes.Equal(hdr, asm.Hdr) {
-		return c, errors.New("bad header")
+		copy(c.mem[j.Addr:], j.Data)
 	}
 
-	return c, nil
+	c.seek(m.Entry)
+	return c, c.SetStart(Start{})
+}
+
+// NewRaw returns a Cpu running code that has no header, as written
+// by 'hypoc -f bin'. The first byte of code is at address base, where
+// execution starts.
+func NewRaw(code []byte, base uint32) (c Cpu, err error) {
+	c.img.Code = code
+	c.mem = new([8192]byte)
+	c.out = os.Stdout
+	c.errOut = os.Stderr
+	c.in = os.Stdin
+	c.nreg = NumRegs
+	c.base = base
+	c.seek(base)
+	return c, c.SetStart(Start{})
+}
+
+// SetOutput sets the destination of the p instruction. The default
+// is os.Stdout.
+func (c *Cpu) SetOutput(w io.Writer) {
+	c.out = w
+}
+
+// SetInput sets the source of the g instruction. The default is
+// os.Stdin.
+func (c *Cpu) SetInput(r io.Reader) {
+	c.in = r
+}
+
+// SetErrorOutput sets the destination of the pe instruction. The
+// default is os.Stderr.
+func (c *Cpu) SetErrorOutput(w io.Writer) {
+	c.errOut = w
 }
 
-func (c *Cpu) read(ins any) {
-	if err := binary.Read(c.buf, binary.LittleEndian, ins); err != nil {
-		c.err = errors.New("bad read")
+// read fetches the next len(p) bytes of instructions.
+func (c *Cpu) read(p []byte) {
+	t, at := c.text()
+
+	off := uint64(c.fetch) - uint64(at)
+	if c.fetch < at || off+uint64(len(p)) > uint64(len(t)) {
+		c.err = fmt.Errorf("instruction at %08x extends past end of code", c.last)
+		return
+	}
+
+	if c.vn {
+		if c.err = c.guard(c.fetch, uint32(len(p)), ProtX); c.err != nil {
+			return
+		}
+
+		if c.smc != nil {
+			c.fetched(c.fetch, uint32(len(p)))
+		}
 	}
+
+	copy(p, t[off:])
+	c.fetch += uint32(len(p))
 }
 
 func (c *Cpu) checkReg(r byte) error {
-	if r > byte(len(c.reg)) {
+	if int(r) >= c.nreg {
 		c.err = fmt.Errorf("invalid register %02x", r)
 		c.flags |= 1
 	}
@@ -58,294 +158,660 @@ func (c *Cpu) readReg(r byte) uint32 {
 func (c *Cpu) writeReg(r byte, i uint32) {
 	if c.checkReg(r) == nil {
 		c.reg[r] = i
+		c.wrote |= 1 << r
+
+		if c.hook.reg != nil {
+			c.hook.reg(int(r), i)
+		}
 	}
 }
 
 func (c *Cpu) readImm(addr uint32) (uint32, error) {
-	if addr > uint32(len(c.mem)) {
+	return c.load(addr, 4)
+}
+
+func (c *Cpu) writeImm(addr, imm uint32) error {
+	return c.store(addr, imm, 4)
+}
+
+// load reads the n byte little-endian value at addr, or from the device
+// mapped there.
+func (c *Cpu) load(addr, n uint32) (uint32, error) {
+	if addr < IOBase || addr >= IOEnd {
+		a, err := c.translate(addr, n, false)
+		if err != nil {
+			return 0, err
+		}
+
+		addr = a
+	} else if c.User() {
+		return 0, c.port(addr)
+	}
+
+	return c.loadPhys(addr, n)
+}
+
+// loadPhys is load at the physical address addr.
+func (c *Cpu) loadPhys(addr, n uint32) (uint32, error) {
+	if addr >= IOBase && addr < IOEnd {
+		return c.ioRead(addr, n)
+	}
+
+	if addr > uint32(len(c.mem))-n {
 		return 0, fmt.Errorf("illegal read %08x", addr)
 	}
 
-	i := c.mem[addr:4]
-	return uint32(i[3])<<24 | uint32(i[2])<<16 | uint32(i[1])<<8 | uint32(i[0]), nil
+	if err := c.guard(addr, n, ProtR); err != nil {
+		return 0, err
+	}
+
+	c.access(addr, n)
+
+	var v uint32
+	for i := n; i > 0; i-- {
+		v = v<<8 | uint32(c.mem[addr+i-1])
+	}
+
+	if c.watch != nil {
+		c.watched(addr, n, v, false)
+	}
+
+	return v, nil
 }
 
-func (c *Cpu) writeImm(addr, imm uint32) error {
-	if addr > uint32(len(c.mem)) {
-		return fmt.Errorf("illegal write %08x (at %08x)", imm, addr)
+// store writes the low n bytes of v at addr, little-endian, or to the
+// device mapped there.
+func (c *Cpu) store(addr, v, n uint32) error {
+	if addr < IOBase || addr >= IOEnd {
+		a, err := c.translate(addr, n, true)
+		if err != nil {
+			return err
+		}
+
+		addr = a
+	} else if c.User() {
+		return c.port(addr)
+	}
+
+	return c.storePhys(addr, v, n)
+}
+
+// storePhys is store at the physical address addr.
+func (c *Cpu) storePhys(addr, v, n uint32) error {
+	if addr >= IOBase && addr < IOEnd {
+		return c.ioWrite(addr, v, n)
+	}
+
+	if addr > uint32(len(c.mem))-n {
+		return fmt.Errorf("illegal write %08x (at %08x)", v, addr)
+	}
+
+	if err := c.guard(addr, n, ProtW); err != nil {
+		return err
+	}
+
+	c.access(addr, n)
+
+	for i := uint32(0); i < n; i++ {
+		c.mem[addr+i] = byte(v >> (8 * i))
+	}
+
+	if c.smc != nil {
+		c.modified(addr, n)
+	}
+
+	if c.hook.mem != nil {
+		c.hook.mem(addr, v&(1<<(8*n)-1), n)
+	}
+
+	if c.watch != nil {
+		c.watched(addr, n, v&(1<<(8*n)-1), true)
 	}
 
-	c.mem[addr] = byte(imm)
-	c.mem[addr+1] = byte(imm >> 8)
-	c.mem[addr+2] = byte(imm >> 16)
-	c.mem[addr+3] = byte(imm >> 24)
 	return nil
 }
 
+// inCode reports whether pc addresses a byte instructions can be
+// fetched from.
+func (c *Cpu) inCode(pc uint32) bool {
+	t, at := c.text()
+	return pc >= at && pc-at < uint32(len(t))
+}
+
+// jump continues execution at pc, faulting if pc is outside the
+// loaded code.
 func (c *Cpu) jump(pc uint32) error {
-	if _, err := c.buf.Seek(int64(pc+uint32(len(asm.Hdr))), io.SeekStart); err != nil {
-		return err
+	c.jumped = true
+
+	if !c.inCode(pc) {
+		c.err = fmt.Errorf("pc %08x out of bounds", pc)
+		return c.err
 	}
 
-	c.pc = pc
+	c.seek(pc)
 	return nil
 }
 
-var ops = map[byte]func(*Cpu) int{
-	asm.OpNop: func(*Cpu) int {
-		return 0
-	},
-	asm.OpLd: func(c *Cpu) int {
-		var ins struct {
-			R1, R2 byte
-		}
+// seek sets pc without checking it; pc may be just past the code,
+// where the next Step faults.
+func (c *Cpu) seek(pc uint32) {
+	c.fetch = pc
+	c.pc = pc
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+// EOF is the value g reads at the end of the input.
+const EOF = 0xffffffff
 
-		i, err := c.readImm(c.readReg(ins.R2))
+// ops executes each opcode. Its operands are decoded by exec from the
+// instruction table of package asm.
+var ops = map[byte]func(*Cpu) int{
+	asm.OpNop: exec(asm.OpNop, func(*Cpu, []uint32) {}),
+	asm.OpLd: exec(asm.OpLd, func(c *Cpu, a []uint32) {
+		i, err := c.readImm(c.readReg(byte(a[1])))
 		c.err = err
 
-		if err != nil {
-			c.writeReg(ins.R1, i)
-		}
-
-		return 2
-	},
-	asm.OpLr: func(c *Cpu) int {
-		var ins struct {
-			I uint32
-			R byte
-		}
+		if err == nil {
+			c.writeReg(byte(a[0]), i)
+		}
+	}),
+	asm.OpLr: exec(asm.OpLr, func(c *Cpu, a []uint32) {
+		c.writeReg(byte(a[1]), a[0])
+	}),
+	asm.OpSt: exec(asm.OpSt, func(c *Cpu, a []uint32) {
+		c.err = c.writeImm(c.readReg(byte(a[0])), c.readReg(byte(a[1])))
+	}),
+	asm.OpAdd:  alu(asm.OpAdd, add),
+	asm.OpSub:  alu(asm.OpSub, sub),
+	asm.OpAddi: alu(asm.OpAddi, add),
+	asm.OpSubi: alu(asm.OpSubi, sub),
+	asm.OpP: exec(asm.OpP, func(c *Cpu, a []uint32) {
+		fmt.Fprint(c.out, string(rune(c.readReg(byte(a[0])))))
+	}),
+	asm.OpPe: exec(asm.OpPe, func(c *Cpu, a []uint32) {
+		fmt.Fprint(c.errOut, string(rune(c.readReg(byte(a[0])))))
+	}),
+	asm.OpG: exec(asm.OpG, func(c *Cpu, a []uint32) {
+		var b [1]byte
+
+		v := uint32(EOF)
+		if _, err := io.ReadFull(c.in, b[:]); err == nil {
+			v = uint32(b[0])
+		} else if err != io.EOF {
+			c.err = fmt.Errorf("input: %s", err)
+			return
+		}
+
+		c.writeReg(byte(a[0]), v)
+	}),
+	asm.OpEi: super(asm.OpEi, func(c *Cpu, a []uint32) {
+		c.SetInterrupts(true)
+	}),
+	asm.OpDi: super(asm.OpDi, func(c *Cpu, a []uint32) {
+		c.SetInterrupts(false)
+	}),
+	asm.OpIret: super(asm.OpIret, func(c *Cpu, a []uint32) {
+		c.iret()
+	}),
+	asm.OpSys: exec(asm.OpSys, syscall),
+	asm.OpMtcr: super(asm.OpMtcr, func(c *Cpu, a []uint32) {
+		c.err = c.SetCr(int(a[1]), c.readReg(byte(a[0])))
+	}),
+	asm.OpMfcr: super(asm.OpMfcr, func(c *Cpu, a []uint32) {
+		if a[0] >= NumCr {
+			c.err = fmt.Errorf("invalid control register %x", a[0])
+			return
+		}
+
+		c.writeReg(byte(a[1]), c.cr[a[0]])
+	}),
+	asm.OpBeq: branch(asm.OpBeq, func(a, b uint32) bool { return a == b }),
+	asm.OpBne: branch(asm.OpBne, func(a, b uint32) bool { return a != b }),
+	asm.OpBgt: branch(asm.OpBgt, func(a, b uint32) bool { return a > b }),
+	asm.OpBlt: branch(asm.OpBlt, func(a, b uint32) bool { return a < b }),
+	asm.OpJ: exec(asm.OpJ, func(c *Cpu, a []uint32) {
+		c.jump(a[0])
+	}),
+	asm.OpJr: exec(asm.OpJr, func(c *Cpu, a []uint32) {
+		c.jump(c.readReg(byte(a[0])))
+	}),
+	asm.OpCall: exec(asm.OpCall, func(c *Cpu, a []uint32) {
+		if c.push(c.pc + 4); c.err == nil {
+			c.jump(a[0])
+		}
+	}),
+	asm.OpExit: exec(asm.OpExit, func(c *Cpu, a []uint32) {
+		c.exit(0)
+	}),
+	asm.OpExitr: exec(asm.OpExitr, func(c *Cpu, a []uint32) {
+		c.exit(c.readReg(byte(a[0])))
+	}),
+	asm.OpExiti: exec(asm.OpExiti, func(c *Cpu, a []uint32) {
+		c.exit(a[0])
+	}),
+	asm.OpHcall: super(asm.OpHcall, func(c *Cpu, a []uint32) {
+		f, ok := c.hcall[a[0]]
+		if !ok {
+			c.err = fmt.Errorf("no hypercall %08x", a[0])
+			return
+		}
+
+		c.err = f(Guest{c: c})
+		c.cost.cycles += c.cost.hcall[a[0]]
+	}),
+	asm.OpYield: exec(asm.OpYield, func(c *Cpu, a []uint32) {
+		c.yield = true
+	}),
+	asm.OpBeqr: branch(asm.OpBeqr, func(a, b uint32) bool { return a == b }),
+	asm.OpBner: branch(asm.OpBner, func(a, b uint32) bool { return a != b }),
+	asm.OpBgtr: branch(asm.OpBgtr, func(a, b uint32) bool { return a > b }),
+	asm.OpBltr: branch(asm.OpBltr, func(a, b uint32) bool { return a < b }),
+	asm.OpBr: exec(asm.OpBr, func(c *Cpu, a []uint32) {
+		c.jump(c.last + a[0])
+	}),
+	asm.OpCallr: exec(asm.OpCallr, func(c *Cpu, a []uint32) {
+		if c.push(c.pc + 4); c.err == nil {
+			c.jump(c.last + a[0])
+		}
+	}),
+	asm.OpPush: exec(asm.OpPush, func(c *Cpu, a []uint32) {
+		c.push(c.readReg(byte(a[0])))
+	}),
+	asm.OpPop: exec(asm.OpPop, func(c *Cpu, a []uint32) {
+		if v := c.pop(); c.err == nil {
+			c.writeReg(byte(a[0]), v)
+		}
+	}),
+	asm.OpRet: exec(asm.OpRet, func(c *Cpu, a []uint32) {
+		if pc := c.pop(); c.err == nil {
+			c.jump(pc)
+		}
+	}),
+	asm.OpShl:  alu(asm.OpShl, shl),
+	asm.OpShr:  alu(asm.OpShr, shr),
+	asm.OpSar:  alu(asm.OpSar, sar),
+	asm.OpShli: alu(asm.OpShli, shl),
+	asm.OpShri: alu(asm.OpShri, shr),
+	asm.OpSari: alu(asm.OpSari, sar),
+	asm.OpBcs:  test(asm.OpBcs, FlagC),
+	asm.OpBvs:  test(asm.OpBvs, FlagV),
+	asm.OpBz:   test(asm.OpBz, FlagZ),
+	asm.OpLb:   load(asm.OpLb, 1, true),
+	asm.OpLbu:  load(asm.OpLbu, 1, false),
+	asm.OpLh:   load(asm.OpLh, 2, true),
+	asm.OpLhu:  load(asm.OpLhu, 2, false),
+	asm.OpSb:   store(asm.OpSb, 1),
+	asm.OpSh:   store(asm.OpSh, 2),
+	asm.OpCas:  exec(asm.OpCas, cas),
+	asm.OpSwap: exec(asm.OpSwap, swap),
+	asm.OpClz: unary(asm.OpClz, func(a uint32) uint32 {
+		return uint32(bits.LeadingZeros32(a))
+	}),
+	asm.OpCtz: unary(asm.OpCtz, func(a uint32) uint32 {
+		return uint32(bits.TrailingZeros32(a))
+	}),
+	asm.OpPopc: unary(asm.OpPopc, func(a uint32) uint32 {
+		return uint32(bits.OnesCount32(a))
+	}),
+	asm.OpRol:  alu(asm.OpRol, rol),
+	asm.OpRor:  alu(asm.OpRor, ror),
+	asm.OpRoli: alu(asm.OpRoli, rol),
+	asm.OpRori: alu(asm.OpRori, ror),
+	asm.OpSlt:  set(asm.OpSlt, func(a, b uint32) bool { return int32(a) < int32(b) }),
+	asm.OpSltu: set(asm.OpSltu, func(a, b uint32) bool { return a < b }),
+	asm.OpSeq:  set(asm.OpSeq, func(a, b uint32) bool { return a == b }),
+	asm.OpCmov: exec(asm.OpCmov, func(c *Cpu, a []uint32) {
+		if c.readReg(byte(a[0])) != 0 {
+			c.writeReg(byte(a[2]), c.readReg(byte(a[1])))
+		}
+	}),
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+// shl, shr and sar shift a by the low five bits of b: left, right
+// filling with zeros and right filling with the sign bit. They never
+// carry or overflow.
+func shl(a, b uint32) (uint32, bool, bool) {
+	return a << (b & 31), false, false
+}
 
-		c.writeReg(ins.R, ins.I)
-		return 5
-	},
-	asm.OpSt: func(c *Cpu) int {
-		var ins struct {
-			R1, R2 byte
-		}
+func shr(a, b uint32) (uint32, bool, bool) {
+	return a >> (b & 31), false, false
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+func sar(a, b uint32) (uint32, bool, bool) {
+	return uint32(int32(a) >> (b & 31)), false, false
+}
 
-		c.err = c.writeImm(c.readReg(ins.R1), c.readReg(ins.R2))
-		return 2
-	},
-	asm.OpAdd: func(c *Cpu) int {
-		var ins struct {
-			R1, R2, R3 byte
-		}
+// rol and ror rotate a left or right by b bits, modulo 32.
+func rol(a, b uint32) (uint32, bool, bool) {
+	return bits.RotateLeft32(a, int(b&31)), false, false
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+func ror(a, b uint32) (uint32, bool, bool) {
+	return bits.RotateLeft32(a, -int(b&31)), false, false
+}
 
-		c.writeReg(ins.R3, c.readReg(ins.R1)+c.readReg(ins.R2))
-		return 3
-	},
-	asm.OpSub: func(c *Cpu) int {
-		var ins struct {
-			R1, R2, R3 byte
-		}
+// exec returns the handler of the instruction op, which reads the
+// operands listed by asm.Operands and passes them to f in source
+// order: register numbers for Reg operands and values for Addr
+// operands. The handler returns the length of the operands, or 0 if
+// they could not be read or f jumped, as Step expects.
+func exec(op byte, f func(c *Cpu, a []uint32)) func(*Cpu) int {
+	params, _ := asm.Operands(op)
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+	return func(c *Cpu) int {
+		n := 0
+		a := c.args[:0]
 
-		c.writeReg(ins.R3, c.readReg(ins.R1)-c.readReg(ins.R2))
-		return 3
-	},
-	asm.OpAddi: func(c *Cpu) int {
-		var ins struct {
-			R1 byte
-			I  uint32
-			R2 byte
-		}
+		for _, j := range params {
+			var b [4]byte
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+			size := 1
+			if j == asm.Addr {
+				size = 4
+			}
 
-		c.writeReg(ins.R2, c.readReg(ins.R1)+ins.I)
-		return 6
-	},
-	asm.OpSubi: func(c *Cpu) int {
-		var ins struct {
-			R1 byte
-			I  uint32
-			R2 byte
-		}
+			if c.read(b[:size]); c.err != nil {
+				return 0
+			}
 
-		if c.read(&ins); c.err != nil {
-			return 0
+			a = append(a, binary.LittleEndian.Uint32(b[:]))
+			n += size
 		}
 
-		c.writeReg(ins.R2, c.readReg(ins.R1)-ins.I)
-		return 6
-	},
-	asm.OpP: func(c *Cpu) int {
-		var R byte
+		c.jumped = false
 
-		if c.read(&R); c.err != nil {
+		if f(c, a); c.jumped {
 			return 0
 		}
 
-		fmt.Print(string(rune(c.reg[R])))
-		return 1
-	},
-	asm.OpBeq: func(c *Cpu) int {
-		var ins struct {
-			R1, R2 byte
-			I      uint32
-		}
+		return n
+	}
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+// alu returns the handler of the arithmetic instruction op, which
+// stores f of its first two operands in the third and sets the status
+// flags. The second operand is a register or an immediate, as given
+// by asm.Operands.
+func alu(op byte, f func(a, b uint32) (r uint32, carry, overflow bool)) func(*Cpu) int {
+	params, _ := asm.Operands(op)
 
-		if c.readReg(ins.R1) == c.readReg(ins.R2) {
-			c.jump(ins.I)
-			return 0
+	return exec(op, func(c *Cpu, a []uint32) {
+		b := a[1]
+		if params[1] == asm.Reg {
+			b = c.readReg(byte(b))
 		}
 
-		return 6
-	},
-	asm.OpBne: func(c *Cpu) int {
-		var ins struct {
-			R1, R2 byte
-			I      uint32
+		r, carry, overflow := f(c.readReg(byte(a[0])), b)
+		if c.err == nil {
+			c.writeReg(byte(a[2]), r)
+			c.setStatus(r, carry, overflow)
 		}
+	})
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
+// set returns the handler of the instruction op, which stores 1 in its
+// third register if cond holds for the first two, and 0 otherwise.
+// The status flags are unchanged.
+func set(op byte, cond func(a, b uint32) bool) func(*Cpu) int {
+	return exec(op, func(c *Cpu, a []uint32) {
+		var v uint32
+		if cond(c.readReg(byte(a[0])), c.readReg(byte(a[1]))) {
+			v = 1
 		}
 
-		if c.readReg(ins.R1) != c.readReg(ins.R2) {
-			c.jump(ins.I)
-			return 0
-		}
+		c.writeReg(byte(a[2]), v)
+	})
+}
 
-		return 6
-	},
-	asm.OpBgt: func(c *Cpu) int {
-		var ins struct {
-			R1, R2 byte
-			I      uint32
-		}
+// unary returns the handler of the instruction op, which stores f of
+// its first register in its second. The status flags are unchanged.
+func unary(op byte, f func(a uint32) uint32) func(*Cpu) int {
+	return exec(op, func(c *Cpu, a []uint32) {
+		c.writeReg(byte(a[1]), f(c.readReg(byte(a[0]))))
+	})
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
+// load returns the handler of the instruction op, which loads n bytes
+// from the address in its second register into its first, extending
+// the sign if signed is set.
+func load(op byte, n uint32, signed bool) func(*Cpu) int {
+	return exec(op, func(c *Cpu, a []uint32) {
+		v, err := c.load(c.readReg(byte(a[1])), n)
+		if c.err = err; err != nil {
+			return
 		}
 
-		if c.readReg(ins.R1) > c.readReg(ins.R2) {
-			c.jump(ins.I)
-			return 0
+		if s := 32 - 8*n; signed {
+			v = uint32(int32(v<<s) >> s)
 		}
 
-		return 6
-	},
-	asm.OpBlt: func(c *Cpu) int {
-		var ins struct {
-			R1, R2 byte
-			I      uint32
-		}
+		c.writeReg(byte(a[0]), v)
+	})
+}
 
-		if c.read(&ins); c.err != nil {
-			return 0
-		}
+// store returns the handler of the instruction op, which stores the
+// low n bytes of its second register at the address in its first.
+func store(op byte, n uint32) func(*Cpu) int {
+	return exec(op, func(c *Cpu, a []uint32) {
+		c.err = c.store(c.readReg(byte(a[0])), c.readReg(byte(a[1])), n)
+	})
+}
 
-		if c.readReg(ins.R1) < c.readReg(ins.R2) {
-			c.jump(ins.I)
-			return 0
+// test returns the handler of the branch op, taken if the status flag
+// f is set.
+func test(op byte, f uint32) func(*Cpu) int {
+	return exec(op, func(c *Cpu, a []uint32) {
+		if c.status&f != 0 {
+			c.jump(a[0])
 		}
+	})
+}
 
-		return 6
-	},
-	asm.OpJ: func(c *Cpu) int {
-		var I uint32
+// branch returns the handler of the conditional branch op, taken if
+// cond holds for its two registers. The target of a relative branch
+// is an offset from the instruction.
+func branch(op byte, cond func(a, b uint32) bool) func(*Cpu) int {
+	rel := asm.Relative(op)
 
-		if c.read(&I); c.err != nil {
-			return 0
+	return exec(op, func(c *Cpu, a []uint32) {
+		if !cond(c.readReg(byte(a[0])), c.readReg(byte(a[1]))) {
+			return
 		}
 
-		c.jump(I)
-		return 0
-	},
-	asm.OpJr: func(c *Cpu) int {
-		var R byte
-
-		if c.read(&R); c.err != nil {
-			return 0
+		if rel {
+			a[2] += c.last
 		}
 
-		c.jump(c.readReg(R))
-		return 0
-	},
-	asm.OpCall: func(c *Cpu) int {
-		var I uint32
+		c.jump(a[2])
+	})
+}
 
-		if c.read(&I); c.err != nil {
-			return 0
-		}
+// exit stops the machine with the exit status code.
+func (c *Cpu) exit(code uint32) {
+	c.code = code
+	c.flags |= 1
+}
 
-		c.writeReg(3, c.pc+4)
-		c.jump(I)
-		return 0
-	},
-	asm.OpExit: func(c *Cpu) int {
-		c.flags |= 1
-		return 0
-	},
+// ExitCode returns the status the program exited with: the operand of
+// exit, or 0 for exit without one or while the program runs.
+func (c *Cpu) ExitCode() uint32 {
+	return c.code
 }
 
 func (c *Cpu) State() bool {
 	return c.flags != 1
 }
 
+// Resume places vals in registers 0, 1, ... and runs until the guest
+// executes yield, exits or faults. It reports whether the guest
+// yielded and can be resumed again.
+func (c *Cpu) Resume(vals ...uint32) (bool, error) {
+	if len(vals) > c.nreg {
+		return false, fmt.Errorf("too many values (%d)", len(vals))
+	}
+
+	copy(c.reg[:], vals)
+	c.yield = false
+
+	for c.State() {
+		if err := c.Step(); err != nil {
+			return false, err
+		}
+
+		if c.yield {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (c *Cpu) Step() error {
-	var op byte
+	var b [1]byte
 
-	if c.read(&op); c.err != nil {
-		return c.err
+	if c.err == nil && c.cost.budget != 0 && c.cost.steps >= c.cost.budget {
+		c.err = ErrBudget
+	}
+
+	if c.err == nil && !c.inCode(c.pc) {
+		c.err = fmt.Errorf("pc %08x out of bounds", c.pc)
+	}
+
+	if c.err != nil {
+		return c.fault(c.err)
+	}
+
+	start := c.pc
+	c.last = start
+
+	// A fetch that faults executes nothing, not even the zero left in
+	// b.
+	if c.read(b[:]); c.err != nil {
+		return c.fault(c.err)
 	}
 
+	op := b[0]
+	c.yield = false
+	c.hit = nil
+	c.acc = c.acc[:0]
+	c.wrote = 0
 	c.pc++
 
 	f, ok := ops[op]
 	if !ok {
 		c.pc--
-		return fmt.Errorf("invalid opcode: %02x", op)
+		c.record(start, op)
+		c.retire(start, op)
+		return c.fault(fmt.Errorf("invalid opcode: %02x", op))
+	}
+
+	var before [MaxRegs]uint32
+	if c.expl != nil || c.paging() || c.User() {
+		before = c.reg
 	}
 
+	status := c.status
+
+	c.charge(op)
 	pc := f(c)
 	c.pc += uint32(pc)
-	return c.err
+	c.explain(start, before)
+	c.stepped(start)
+	c.record(start, op)
+	c.retire(start, op)
+	c.profile(op)
+
+	if c.err != nil {
+		c.trap(start, before, status)
+	}
+
+	if c.err == nil {
+		c.tick()
+		c.interrupt()
+	}
+
+	if c.err == nil && c.thr != nil {
+		c.thr.schedule()
+	}
+
+	return c.fault(c.err)
+}
+
+// Yielded reports whether the last instruction executed was yield.
+func (c *Cpu) Yielded() bool {
+	return c.yield
+}
+
+// Pc returns the address of the next instruction.
+func (c *Cpu) Pc() uint32 {
+	return c.pc
+}
+
+// StepLine executes instructions until control reaches a different
+// source line or the start of the current one again, the machine
+// halts or yields, or a fault occurs. Without a line table it is the
+// same as Step.
+func (c *Cpu) StepLine() error {
+	l, ok := c.Source(c.pc)
+
+	for {
+		if err := c.Step(); err != nil {
+			return err
+		}
+
+		if !ok || !c.State() || c.yield {
+			return nil
+		}
+
+		n, nok := c.Source(c.pc)
+		if !nok || n.File != l.File || n.Line != l.Line || c.pc-c.base == n.Addr && c.first(n) {
+			return nil
+		}
+	}
+}
+
+// first reports whether l is the first line table entry for its
+// source line.
+func (c *Cpu) first(l asm.Line) bool {
+	for _, j := range c.img.Lines {
+		if j.File == l.File && j.Line == l.Line {
+			return j.Addr == l.Addr
+		}
+	}
+
+	return false
+}
+
+// Source returns the source location of the instruction at pc, if
+// the image has a line table.
+func (c *Cpu) Source(pc uint32) (asm.Line, bool) {
+	if pc < c.base {
+		return asm.Line{}, false
+	}
+
+	return c.img.Source(pc - c.base)
 }
 
 func (c *Cpu) WriteTrace(w io.Writer) {
 	fmt.Fprintln(w, "register trace:")
-	for i, j := range c.reg {
+	for i, j := range c.reg[:c.nreg] {
 		fmt.Fprintf(w, "%02x: %08x\n", i, j)
 	}
 
-	fmt.Fprintf(w, "pc: %08x\n", c.pc)
-	fmt.Fprintln(w, "memory trace:")
-	for i, j := range c.mem {
-		if i > 0xff {
-			break
-		}
-		if i > 0 && i%16 == 0 {
-			fmt.Fprintln(w, "")
-		}
-		fmt.Fprintf(w, "%02x ", j)
+	fmt.Fprintf(w, "status: %s\n", c.statusString())
+	fmt.Fprintf(w, "pc: %08x", c.pc)
+	if l, ok := c.Source(c.pc); ok {
+		fmt.Fprintf(w, " (%s:%d)", l.File, l.Line)
 	}
 
 	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "memory trace:")
+	c.WriteMem(w, 0, 0x100)
+
+	if r := c.Ring(); len(r) > 0 {
+		fmt.Fprintln(w, "step trace:")
+		for _, e := range r {
+			c.writeEvent(w, e)
+		}
+	}
 }
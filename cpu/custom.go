@@ -0,0 +1,39 @@
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// OpHandler executes a custom instruction given its operands in
+// source order: register numbers for Reg operands and values for
+// Addr operands. A non-nil error faults the guest.
+type OpHandler func(g Guest, args []uint32) error
+
+// RegisterOp makes f execute the opcode op, which must first be given
+// its operands with asm.RegisterInstruction. Execution continues after
+// the instruction unless f calls Jump. Like the built-in instructions,
+// custom ones are shared by every Cpu; register them during
+// initialization.
+func RegisterOp(op byte, f OpHandler) error {
+	if _, ok := ops[op]; ok {
+		return fmt.Errorf("opcode %02x already defined", op)
+	}
+
+	if _, ok := asm.Operands(op); !ok {
+		return fmt.Errorf("opcode %02x has no instruction", op)
+	}
+
+	ops[op] = exec(op, func(c *Cpu, a []uint32) {
+		c.err = f(Guest{c: c}, append([]uint32(nil), a...))
+	})
+
+	return nil
+}
+
+// Jump continues execution at addr once the current instruction
+// completes.
+func (g Guest) Jump(addr uint32) error {
+	return g.c.jump(addr)
+}
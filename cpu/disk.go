@@ -0,0 +1,148 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+)
+
+// DiskBase and DiskIRQ are where hypo maps a Disk and the interrupt
+// line it raises.
+const (
+	DiskBase = 0xf0000400
+	DiskIRQ  = 3
+)
+
+// SectorSize is the size of a disk sector in bytes.
+const SectorSize = 512
+
+// Disk registers, as offsets from the base of a Disk.
+const (
+	DiskSector  = 0x00 // the sector to transfer
+	DiskAddr    = 0x04 // the memory address to transfer to or from
+	DiskCommand = 0x08 // write DiskRead or DiskWrite to start a transfer
+	DiskStatus  = 0x0c // DiskBusy and DiskError
+	DiskSectors = 0x10 // the number of sectors of the disk
+	DiskControl = 0x14 // 1 raises the interrupt line when a transfer ends
+)
+
+// Disk commands.
+const (
+	DiskRead  = 1 // copy the sector to memory
+	DiskWrite = 2 // copy memory to the sector
+)
+
+// Bits of the disk status register.
+const (
+	DiskBusy  = 1 << iota // a transfer has not finished
+	DiskError             // the last transfer failed
+)
+
+// DiskSize is the size of the registers of a Disk.
+const DiskSize = 0x18
+
+// Storage holds the sectors of a Disk, as an *os.File does.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Disk is a block storage Device. A transfer of one sector between
+// the disk and memory starts when a command is written and completes
+// after the instruction that wrote it; the guest polls the status or
+// waits for the interrupt.
+type Disk struct {
+	s       Storage
+	n       uint32
+	irq     int
+	sector  uint32
+	addr    uint32
+	command uint32
+	status  uint32
+	control uint32
+}
+
+// NewDisk returns a Disk of size bytes of s, rounded down to whole
+// sectors, raising the interrupt line irq.
+func NewDisk(s Storage, size int64, irq int) *Disk {
+	return &Disk{s: s, n: uint32(size / SectorSize), irq: irq}
+}
+
+func (d *Disk) Read32(addr uint32) (uint32, error) {
+	switch addr {
+	case DiskSector:
+		return d.sector, nil
+	case DiskAddr:
+		return d.addr, nil
+	case DiskCommand:
+		return d.command, nil
+	case DiskStatus:
+		return d.status, nil
+	case DiskSectors:
+		return d.n, nil
+	case DiskControl:
+		return d.control, nil
+	}
+
+	return 0, nil
+}
+
+func (d *Disk) Write32(addr, val uint32) error {
+	switch addr {
+	case DiskSector:
+		d.sector = val
+	case DiskAddr:
+		d.addr = val
+	case DiskCommand:
+		d.command = val
+		d.status = DiskBusy
+	case DiskControl:
+		d.control = val
+	}
+
+	return nil
+}
+
+// Tick carries out a transfer that was started.
+func (d *Disk) Tick(g Guest) {
+	if d.status&DiskBusy == 0 {
+		return
+	}
+
+	d.status = 0
+	if d.transfer(g) != nil {
+		d.status = DiskError
+	}
+
+	if d.control&1 != 0 {
+		g.Raise(d.irq)
+	}
+}
+
+// transfer carries out the command.
+func (d *Disk) transfer(g Guest) error {
+	var b [SectorSize]byte
+
+	if d.sector >= d.n {
+		return fmt.Errorf("sector %d is past the end of the disk", d.sector)
+	}
+
+	off := int64(d.sector) * SectorSize
+
+	switch d.command {
+	case DiskRead:
+		if _, err := d.s.ReadAt(b[:], off); err != nil {
+			return err
+		}
+
+		return g.Write(d.addr, b[:])
+	case DiskWrite:
+		if err := g.Read(d.addr, b[:]); err != nil {
+			return err
+		}
+
+		_, err := d.s.WriteAt(b[:], off)
+		return err
+	}
+
+	return fmt.Errorf("unknown disk command %d", d.command)
+}
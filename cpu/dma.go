@@ -0,0 +1,134 @@
+package cpu
+
+// DMABase and DMAIRQ are where hypo maps a DMA controller and the
+// interrupt line it raises.
+const (
+	DMABase = 0xf0000600
+	DMAIRQ  = 4
+)
+
+// DMA registers, as offsets from the base of a DMA.
+const (
+	DMASource  = 0x00 // the address of the next word to read
+	DMADest    = 0x04 // the address of the next word to write
+	DMACount   = 0x08 // the number of words left to copy
+	DMAControl = 0x0c // DMAStart, DMAInterrupt, DMAFixSource and DMAFixDest
+	DMAStatus  = 0x10 // DMABusy and DMAError
+)
+
+// Bits of the DMA control register.
+const (
+	DMAStart     = 1 << iota // start copying
+	DMAInterrupt             // raise the interrupt line when done
+	DMAFixSource             // read every word from the same address
+	DMAFixDest               // write every word to the same address
+)
+
+// Bits of the DMA status register.
+const (
+	DMABusy  = 1 << iota // a copy has not finished
+	DMAError             // the last copy stopped at a bad address
+)
+
+// DMASize is the size of the registers of a DMA.
+const DMASize = 0x14
+
+// DMA is a controller Device copying words between addresses while
+// the guest runs, one word after each instruction. Either address may
+// be in memory or in the MMIO window; fixing one reads or writes a
+// device register such as a UART's data word over and over.
+type DMA struct {
+	irq     int
+	src     uint32
+	dst     uint32
+	count   uint32
+	control uint32
+	status  uint32
+}
+
+// NewDMA returns an idle DMA raising the interrupt line irq.
+func NewDMA(irq int) *DMA {
+	return &DMA{irq: irq}
+}
+
+func (d *DMA) Read32(addr uint32) (uint32, error) {
+	switch addr {
+	case DMASource:
+		return d.src, nil
+	case DMADest:
+		return d.dst, nil
+	case DMACount:
+		return d.count, nil
+	case DMAControl:
+		return d.control, nil
+	case DMAStatus:
+		return d.status, nil
+	}
+
+	return 0, nil
+}
+
+func (d *DMA) Write32(addr, val uint32) error {
+	if d.status&DMABusy != 0 && addr != DMAControl {
+		return nil
+	}
+
+	switch addr {
+	case DMASource:
+		d.src = val
+	case DMADest:
+		d.dst = val
+	case DMACount:
+		d.count = val
+	case DMAControl:
+		d.control = val
+		if val&DMAStart != 0 {
+			d.status = DMABusy
+		}
+	}
+
+	return nil
+}
+
+// Tick copies the next word of a copy in progress.
+func (d *DMA) Tick(g Guest) {
+	if d.status&DMABusy == 0 {
+		return
+	}
+
+	if d.count > 0 {
+		v, err := g.Load(d.src)
+		if err == nil {
+			err = g.Store(d.dst, v)
+		}
+
+		if err != nil {
+			d.finish(g, DMAError)
+			return
+		}
+
+		if d.control&DMAFixSource == 0 {
+			d.src += 4
+		}
+
+		if d.control&DMAFixDest == 0 {
+			d.dst += 4
+		}
+
+		d.count--
+	}
+
+	if d.count == 0 {
+		d.finish(g, 0)
+	}
+}
+
+// finish ends the copy with the status s.
+func (d *DMA) finish(g Guest, s uint32) {
+	d.status = s
+	d.control &^= DMAStart
+
+	if d.control&DMAInterrupt != 0 {
+		g.Raise(d.irq)
+	}
+}
@@ -0,0 +1,202 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// SetExplain writes a plain English account of every instruction
+// executed to w, such as "add: %3 ← %1(5) + %2(7) = 12". Register
+// values are decimal and addresses hex. A nil w turns it off.
+func (c *Cpu) SetExplain(w io.Writer) {
+	c.expl = w
+}
+
+// explain writes the account of the instruction at pc, given the
+// registers before it ran.
+func (c *Cpu) explain(pc uint32, before [MaxRegs]uint32) {
+	if c.expl == nil || c.err != nil {
+		return
+	}
+
+	t, at := c.text()
+
+	d, err := asm.Decode(t[pc-at:], pc)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(c.expl, "%08x: %s: %s\n", pc, d.Name, c.describe(d, before))
+}
+
+// describe returns what d did, given the registers before it ran.
+func (c *Cpu) describe(d asm.Decoded, before [MaxRegs]uint32) string {
+	reg := func(i int) string {
+		r, _ := d.Reg(i)
+		return fmt.Sprintf("%%%d(%d)", r, before[r])
+	}
+
+	dst := func(i int) (string, uint32) {
+		r, _ := d.Reg(i)
+		return fmt.Sprintf("%%%d", r), c.reg[r]
+	}
+
+	imm := func(i int) uint32 {
+		v, _ := d.Imm(i)
+		return v
+	}
+
+	switch d.Op {
+	case asm.OpNop:
+		return "do nothing"
+	case asm.OpLd:
+		r, v := dst(0)
+		return fmt.Sprintf("%s ← mem[%s] = %d", r, reg(1), v)
+	case asm.OpLr:
+		r, v := dst(1)
+		return fmt.Sprintf("%s ← %d", r, v)
+	case asm.OpSt:
+		return fmt.Sprintf("mem[%s] ← %s", reg(0), reg(1))
+	case asm.OpLb, asm.OpLbu, asm.OpLh, asm.OpLhu:
+		r, v := dst(0)
+		return fmt.Sprintf("%s ← %s[%s] = %d", r, width(d.Op), reg(1), v)
+	case asm.OpSb, asm.OpSh:
+		return fmt.Sprintf("%s[%s] ← %s", width(d.Op), reg(0), reg(1))
+	case asm.OpClz, asm.OpCtz, asm.OpPopc:
+		r, v := dst(1)
+		what := map[byte]string{asm.OpClz: "leading zeros", asm.OpCtz: "trailing zeros", asm.OpPopc: "ones"}[d.Op]
+		return fmt.Sprintf("%s ← %s of %s = %d", r, what, reg(0), v)
+	case asm.OpSlt, asm.OpSltu, asm.OpSeq:
+		r, v := dst(2)
+		rel := map[byte]string{asm.OpSlt: "s<", asm.OpSltu: "<", asm.OpSeq: "=="}[d.Op]
+		return fmt.Sprintf("%s ← %s %s %s = %d", r, reg(0), rel, reg(1), v)
+	case asm.OpCmov:
+		r, v := dst(2)
+		if before[d.Args[0]] == 0 {
+			return fmt.Sprintf("%s is 0, keep %s = %d", reg(0), r, v)
+		}
+
+		return fmt.Sprintf("%s ← %s", r, reg(1))
+	case asm.OpCas:
+		r, v := dst(1)
+		if c.status&FlagZ != 0 {
+			return fmt.Sprintf("mem[%s] equals %s, ← %s", reg(0), reg(1), reg(2))
+		}
+
+		return fmt.Sprintf("mem[%s] = %d is not %s, %s ← %d", reg(0), v, reg(1), r, v)
+	case asm.OpSwap:
+		r, v := dst(1)
+		return fmt.Sprintf("mem[%s] ← %s, %s ← %d", reg(0), reg(1), r, v)
+	case asm.OpAdd, asm.OpSub, asm.OpShl, asm.OpShr, asm.OpSar, asm.OpRol, asm.OpRor:
+		r, v := dst(2)
+		return fmt.Sprintf("%s ← %s %s %s = %d", r, reg(0), operator(d.Op), reg(1), v)
+	case asm.OpAddi, asm.OpSubi, asm.OpShli, asm.OpShri, asm.OpSari, asm.OpRoli, asm.OpRori:
+		r, v := dst(2)
+		return fmt.Sprintf("%s ← %s %s %d = %d", r, reg(0), operator(d.Op), imm(1), v)
+	case asm.OpP, asm.OpPe:
+		r, _ := d.Reg(0)
+		to := "output"
+		if d.Op == asm.OpPe {
+			to = "error output"
+		}
+
+		return fmt.Sprintf("print %s %q to %s", reg(0), rune(before[r]), to)
+	case asm.OpBeq, asm.OpBne, asm.OpBgt, asm.OpBlt, asm.OpBeqr, asm.OpBner, asm.OpBgtr, asm.OpBltr:
+		rel := map[byte]string{
+			asm.OpBeq: "==", asm.OpBne: "!=", asm.OpBgt: ">", asm.OpBlt: "<",
+			asm.OpBeqr: "==", asm.OpBner: "!=", asm.OpBgtr: ">", asm.OpBltr: "<",
+		}[d.Op]
+		if c.pc == d.Addr+uint32(d.Size) {
+			return fmt.Sprintf("%s %s %s is false, continue", reg(0), rel, reg(1))
+		}
+
+		return fmt.Sprintf("%s %s %s is true, jump to %08x", reg(0), rel, reg(1), c.pc)
+	case asm.OpG:
+		r, v := dst(0)
+		if v == EOF {
+			return fmt.Sprintf("%s ← end of input", r)
+		}
+
+		return fmt.Sprintf("%s ← input %q", r, rune(v))
+	case asm.OpBcs, asm.OpBvs, asm.OpBz:
+		flag := map[byte]string{asm.OpBcs: "carry", asm.OpBvs: "overflow", asm.OpBz: "zero"}[d.Op]
+		if c.pc == d.Addr+uint32(d.Size) {
+			return fmt.Sprintf("%s is clear, continue", flag)
+		}
+
+		return fmt.Sprintf("%s is set, jump to %08x", flag, c.pc)
+	case asm.OpJ, asm.OpBr:
+		return fmt.Sprintf("jump to %08x", c.pc)
+	case asm.OpJr:
+		r, _ := d.Reg(0)
+		return fmt.Sprintf("jump to %%%d = %08x", r, c.pc)
+	case asm.OpCall, asm.OpCallr:
+		return fmt.Sprintf("push %08x, jump to %08x", d.Addr+uint32(d.Size), c.pc)
+	case asm.OpPush:
+		return fmt.Sprintf("%%sp ← %08x, mem[%%sp] ← %s", c.reg[RegSp], reg(0))
+	case asm.OpPop:
+		r, v := dst(0)
+		return fmt.Sprintf("%s ← mem[%%sp] = %d, %%sp ← %08x", r, v, c.reg[RegSp])
+	case asm.OpRet:
+		return fmt.Sprintf("pop %08x, jump to it", c.pc)
+	case asm.OpEi:
+		return "enable interrupts"
+	case asm.OpDi:
+		return "disable interrupts"
+	case asm.OpIret:
+		return fmt.Sprintf("pop status %s and %08x, jump to it", c.statusString(), c.pc)
+	case asm.OpExit:
+		return "stop the machine"
+	case asm.OpExitr, asm.OpExiti:
+		return fmt.Sprintf("stop the machine with status %d", c.code)
+	case asm.OpHcall:
+		return fmt.Sprintf("call host function %08x", imm(0))
+	case asm.OpSys:
+		if c.jumped {
+			return fmt.Sprintf("control register %d ← %08x, push %08x and status, jump to %08x", CrSys, imm(0), d.Addr+uint32(d.Size), c.pc)
+		}
+
+		return fmt.Sprintf("call the syscall handler with %08x", imm(0))
+	case asm.OpYield:
+		return "pause and return to the host"
+	case asm.OpMtcr:
+		return fmt.Sprintf("control register %d ← %s", imm(1), reg(0))
+	case asm.OpMfcr:
+		r, v := dst(1)
+		return fmt.Sprintf("%s ← control register %d = %d", r, imm(0), v)
+	}
+
+	return d.String()
+}
+
+// width names the memory access of the byte and halfword loads and
+// stores, with the sign extension of loads.
+func width(op byte) string {
+	return map[byte]string{
+		asm.OpLb: "mem8s", asm.OpLbu: "mem8", asm.OpSb: "mem8",
+		asm.OpLh: "mem16s", asm.OpLhu: "mem16", asm.OpSh: "mem16",
+	}[op]
+}
+
+// operator returns the operator computed by the arithmetic
+// instruction op. Arithmetic right shifts are written s>>.
+func operator(op byte) string {
+	switch op {
+	case asm.OpSub, asm.OpSubi:
+		return "-"
+	case asm.OpShl, asm.OpShli:
+		return "<<"
+	case asm.OpShr, asm.OpShri:
+		return ">>"
+	case asm.OpSar, asm.OpSari:
+		return "s>>"
+	case asm.OpRol, asm.OpRoli:
+		return "rotated left by"
+	case asm.OpRor, asm.OpRori:
+		return "rotated right by"
+	}
+
+	return "+"
+}
@@ -0,0 +1,66 @@
+package cpu
+
+// FramebufferBase is where hypo maps a Framebuffer.
+const FramebufferBase = 0xf0010000
+
+// The size of a Framebuffer, in character cells.
+const (
+	FramebufferCols = 80
+	FramebufferRows = 25
+)
+
+// FramebufferSize is the size of the cells of a Framebuffer.
+const FramebufferSize = FramebufferCols * FramebufferRows * 4
+
+// Framebuffer is a character display Device. Each word holds the
+// character of one cell in its low byte, row by row from the top
+// left, so the cell at column x of row y is at 4*(80*y+x).
+type Framebuffer struct {
+	cells [FramebufferRows][FramebufferCols]byte
+	dirty bool
+}
+
+// NewFramebuffer returns a Framebuffer filled with spaces.
+func NewFramebuffer() *Framebuffer {
+	f := &Framebuffer{dirty: true}
+	for i := range f.cells {
+		for j := range f.cells[i] {
+			f.cells[i][j] = ' '
+		}
+	}
+
+	return f
+}
+
+func (f *Framebuffer) Read32(addr uint32) (uint32, error) {
+	n := addr / 4
+	return uint32(f.cells[n/FramebufferCols][n%FramebufferCols]), nil
+}
+
+func (f *Framebuffer) Write32(addr, val uint32) error {
+	n := addr / 4
+	f.cells[n/FramebufferCols][n%FramebufferCols] = byte(val)
+	f.dirty = true
+	return nil
+}
+
+// Lines returns the rows of f as text, with unprintable characters
+// shown as spaces, and reports whether any cell was written since the
+// last call.
+func (f *Framebuffer) Lines() ([]string, bool) {
+	r := make([]string, FramebufferRows)
+	for i, j := range f.cells {
+		b := j
+		for k, v := range b {
+			if v < ' ' || v > '~' {
+				b[k] = ' '
+			}
+		}
+
+		r[i] = string(b[:])
+	}
+
+	dirty := f.dirty
+	f.dirty = false
+	return r, dirty
+}
@@ -0,0 +1,280 @@
+package cpu
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Hypercall numbers of the FS service.
+const (
+	FSOpen  = 0xf500
+	FSRead  = 0xf501
+	FSWrite = 0xf502
+	FSClose = 0xf503
+)
+
+// Modes of FSOpen.
+const (
+	FSReadOnly = iota // open an existing file
+	FSCreate          // create or truncate a file
+	FSAppend          // create a file or write at its end
+)
+
+// FSError is returned in register 0 by a failing FS hypercall.
+const FSError = 0xffffffff
+
+// FS is an in-memory file system offered to the guest through
+// hypercalls, so that it sees files without any access to the host's.
+// Registers hold the arguments and register 0 the result:
+//
+//	hcall FSOpen   %0 = path, NUL terminated, %1 = mode   -> fd
+//	hcall FSRead   %0 = fd, %1 = buffer, %2 = length     -> bytes read
+//	hcall FSWrite  %0 = fd, %1 = buffer, %2 = length     -> bytes written
+//	hcall FSClose  %0 = fd
+//
+// Failures return FSError rather than faulting the guest; only bad
+// guest addresses fault. Files are not part of a Snapshot.
+type FS struct {
+	files map[string][]byte
+	fds   map[uint32]*fsFile
+	next  uint32
+}
+
+type fsFile struct {
+	name     string
+	off      int
+	readOnly bool
+}
+
+// maxPath bounds the length of the paths given to FSOpen.
+const maxPath = 256
+
+// NewFS returns an empty FS.
+func NewFS() *FS {
+	return &FS{files: make(map[string][]byte), fds: make(map[uint32]*fsFile)}
+}
+
+// Register installs the hypercalls of fs on c.
+func (fs *FS) Register(c *Cpu) {
+	c.RegisterHypercall(FSOpen, fs.open)
+	c.RegisterHypercall(FSRead, fs.read)
+	c.RegisterHypercall(FSWrite, fs.write)
+	c.RegisterHypercall(FSClose, fs.close)
+}
+
+// Files returns the names of the files in fs, sorted.
+func (fs *FS) Files() []string {
+	r := make([]string, 0, len(fs.files))
+	for k := range fs.files {
+		r = append(r, k)
+	}
+
+	sort.Strings(r)
+	return r
+}
+
+// ReadFile returns the contents of the file name.
+func (fs *FS) ReadFile(name string) ([]byte, bool) {
+	b, ok := fs.files[name]
+	return b, ok
+}
+
+// WriteFile creates or replaces the file name.
+func (fs *FS) WriteFile(name string, data []byte) {
+	fs.files[name] = append([]byte(nil), data...)
+}
+
+// LoadTar adds the regular files of the tar archive r.
+func (fs *FS) LoadTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		fs.files[h.Name] = b
+	}
+}
+
+// LoadZip adds the files of the zip archive r of the given size.
+func (fs *FS) LoadZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range zr.File {
+		if j.FileInfo().IsDir() {
+			continue
+		}
+
+		f, err := j.Open()
+		if err != nil {
+			return err
+		}
+
+		b, err := io.ReadAll(f)
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+
+		fs.files[j.Name] = b
+	}
+
+	return nil
+}
+
+// WriteTar writes every file of fs to w as a tar archive.
+func (fs *FS) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	for _, j := range fs.Files() {
+		b := fs.files[j]
+
+		if err := tw.WriteHeader(&tar.Header{Name: j, Mode: 0644, Size: int64(len(b))}); err != nil {
+			return err
+		}
+
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// path reads the NUL terminated string at addr.
+func path(g Guest, addr uint32) (string, error) {
+	var b [1]byte
+	var s bytes.Buffer
+
+	for i := uint32(0); i < maxPath; i++ {
+		if err := g.Read(addr+i, b[:]); err != nil {
+			return "", err
+		}
+
+		if b[0] == 0 {
+			return s.String(), nil
+		}
+
+		s.WriteByte(b[0])
+	}
+
+	return "", fmt.Errorf("path at %08x too long", addr)
+}
+
+func (fs *FS) open(g Guest) error {
+	addr, _ := g.Reg(0)
+	mode, _ := g.Reg(1)
+
+	name, err := path(g, addr)
+	if err != nil {
+		return err
+	}
+
+	f := &fsFile{name: name}
+
+	switch _, ok := fs.files[name]; {
+	case mode == FSReadOnly && ok:
+		f.readOnly = true
+	case mode == FSCreate:
+		fs.files[name] = nil
+	case mode == FSAppend:
+		f.off = len(fs.files[name])
+		fs.files[name] = fs.files[name][:f.off:f.off]
+	default:
+		return g.SetReg(0, FSError)
+	}
+
+	fd := fs.next
+	fs.next++
+	fs.fds[fd] = f
+	return g.SetReg(0, fd)
+}
+
+func (fs *FS) read(g Guest) error {
+	fd, _ := g.Reg(0)
+	buf, _ := g.Reg(1)
+	n, _ := g.Reg(2)
+
+	f, ok := fs.fds[fd]
+	if !ok {
+		return g.SetReg(0, FSError)
+	}
+
+	// Another fd may have truncated the file with FSCreate.
+	d := fs.files[f.name]
+	if f.off > len(d) {
+		f.off = len(d)
+	}
+
+	b := d[f.off:]
+	if uint32(len(b)) > n {
+		b = b[:n]
+	}
+
+	if err := g.Write(buf, b); err != nil {
+		return err
+	}
+
+	f.off += len(b)
+	return g.SetReg(0, uint32(len(b)))
+}
+
+func (fs *FS) write(g Guest) error {
+	fd, _ := g.Reg(0)
+	buf, _ := g.Reg(1)
+	n, _ := g.Reg(2)
+
+	f, ok := fs.fds[fd]
+	if !ok || f.readOnly || uint64(n) > uint64(len(g.c.mem)) {
+		return g.SetReg(0, FSError)
+	}
+
+	b := make([]byte, n)
+	if err := g.Read(buf, b); err != nil {
+		return err
+	}
+
+	d := fs.files[f.name]
+	if end := f.off + len(b); end > len(d) {
+		d = append(d, make([]byte, end-len(d))...)
+	}
+
+	copy(d[f.off:], b)
+	fs.files[f.name] = d
+	f.off += len(b)
+	return g.SetReg(0, n)
+}
+
+func (fs *FS) close(g Guest) error {
+	fd, _ := g.Reg(0)
+
+	if _, ok := fs.fds[fd]; !ok {
+		return g.SetReg(0, FSError)
+	}
+
+	delete(fs.fds, fd)
+	return g.SetReg(0, 0)
+}
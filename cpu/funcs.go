@@ -0,0 +1,145 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// FuncStat is the profile of a single routine. Inclusive counts the
+// steps taken from entering the routine until it returned, Exclusive
+// only those not spent in routines it called.
+type FuncStat struct {
+	Addr      uint32
+	Name      string
+	Calls     uint64
+	Inclusive uint64
+	Exclusive uint64
+}
+
+type frame struct {
+	fn    uint32
+	ret   uint32
+	start uint64
+}
+
+type profile struct {
+	stack []frame
+	funcs map[uint32]*FuncStat
+}
+
+// SetFuncProfile enables counting steps per routine from the current
+// instruction on. A routine is entered by call and left by a jr to
+// the return address call saved, possibly unwinding several frames.
+func (c *Cpu) SetFuncProfile(on bool) {
+	c.prof = nil
+
+	if on {
+		c.prof = &profile{funcs: make(map[uint32]*FuncStat)}
+		c.prof.enter(c, c.pc, 0)
+	}
+}
+
+func (p *profile) enter(c *Cpu, fn, ret uint32) {
+	f, ok := p.funcs[fn]
+	if !ok {
+		f = &FuncStat{Addr: fn, Name: fmt.Sprintf("%08x", fn)}
+		if o, off, ok := c.img.Object(asm.SectText, fn-c.base); ok && off == 0 && fn >= c.base {
+			f.Name = o.Name
+		}
+
+		p.funcs[fn] = f
+	}
+
+	f.Calls++
+	p.stack = append(p.stack, frame{fn, ret, c.cost.steps})
+}
+
+// leave pops the innermost frame. Steps in recursive calls are only
+// counted by the outermost frame of a routine.
+func (p *profile) leave(steps uint64) {
+	f := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	if !p.active(f.fn) {
+		p.funcs[f.fn].Inclusive += steps - f.start
+	}
+}
+
+func (p *profile) active(fn uint32) bool {
+	for _, j := range p.stack {
+		if j.fn == fn {
+			return true
+		}
+	}
+
+	return false
+}
+
+// profile accounts the instruction op that has just executed.
+func (c *Cpu) profile(op byte) {
+	p := c.prof
+	if p == nil || c.err != nil {
+		return
+	}
+
+	p.funcs[p.stack[len(p.stack)-1].fn].Exclusive++
+
+	switch op {
+	case asm.OpCall, asm.OpCallr:
+		p.enter(c, c.pc, c.last+uint32(asm.Size(op)))
+	case asm.OpRet:
+		for i := len(p.stack) - 1; i > 0; i-- {
+			if p.stack[i].ret == c.pc {
+				for len(p.stack) > i {
+					p.leave(c.cost.steps)
+				}
+
+				break
+			}
+		}
+	}
+}
+
+// Funcs returns the profile of every routine entered, by descending
+// inclusive count. Routines that have not returned yet are counted
+// up to the current step.
+func (c *Cpu) Funcs() []FuncStat {
+	if c.prof == nil {
+		return nil
+	}
+
+	var r []FuncStat
+	for _, j := range c.prof.funcs {
+		f := *j
+
+		for _, k := range c.prof.stack {
+			if k.fn == f.Addr {
+				f.Inclusive += c.cost.steps - k.start
+				break
+			}
+		}
+
+		r = append(r, f)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Inclusive != r[j].Inclusive {
+			return r[i].Inclusive > r[j].Inclusive
+		}
+
+		return r[i].Addr < r[j].Addr
+	})
+
+	return r
+}
+
+// WriteFuncs writes the routine profile as a table.
+func (c *Cpu) WriteFuncs(w io.Writer) {
+	fmt.Fprintf(w, "%10s %10s %10s  %s\n", "calls", "inclusive", "exclusive", "routine")
+	for _, j := range c.Funcs() {
+		fmt.Fprintf(w, "%10d %10d %10d  %s\n", j.Calls, j.Inclusive, j.Exclusive, j.Name)
+	}
+}
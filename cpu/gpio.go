@@ -0,0 +1,141 @@
+package cpu
+
+import "sync"
+
+// GPIOBase and GPIOIRQ are where hypo maps a GPIO and the interrupt
+// line it raises.
+const (
+	GPIOBase = 0xf0000700
+	GPIOIRQ  = 5
+)
+
+// GPIO registers, as offsets from the base of a GPIO. Bit n of each
+// is pin n.
+const (
+	GPIODir     = 0x00 // 1 for an output pin, 0 for an input
+	GPIOOut     = 0x04 // the level of each output pin
+	GPIOIn      = 0x08 // the level of every pin
+	GPIOIntMask = 0x0c // input pins whose changes raise the interrupt
+	GPIOIntStat = 0x10 // input pins that changed; write 1 to clear
+)
+
+// GPIOSize is the size of the registers of a GPIO.
+const GPIOSize = 0x14
+
+// GPIO is a Device with 32 general purpose pins. The guest sets the
+// direction of each pin and the level of the outputs; the host drives
+// the inputs with Drive and observes the pins with Pins or Watch, from
+// any goroutine.
+type GPIO struct {
+	mu     sync.Mutex
+	irq    int
+	dir    uint32
+	out    uint32
+	in     uint32 // the levels driven by the host
+	last   uint32 // the input levels seen at the last tick
+	mask   uint32
+	stat   uint32
+	notify func(pins uint32)
+}
+
+// NewGPIO returns a GPIO with every pin an input at level 0, raising
+// the interrupt line irq.
+func NewGPIO(irq int) *GPIO {
+	return &GPIO{irq: irq}
+}
+
+// Drive sets the level the host applies to pin n. It is seen by the
+// guest only while the pin is an input.
+func (p *GPIO) Drive(n int, high bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if high {
+		p.in |= 1 << n
+	} else {
+		p.in &^= 1 << n
+	}
+}
+
+// Pins returns the level of every pin.
+func (p *GPIO) Pins() uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pins()
+}
+
+// Watch makes f be called with the level of every pin whenever the
+// guest changes an output. f runs on the goroutine running the guest.
+func (p *GPIO) Watch(f func(pins uint32)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.notify = f
+}
+
+// pins returns the level of every pin: outputs as set by the guest,
+// inputs as driven by the host.
+func (p *GPIO) pins() uint32 {
+	return p.out&p.dir | p.in&^p.dir
+}
+
+func (p *GPIO) Read32(addr uint32) (uint32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch addr {
+	case GPIODir:
+		return p.dir, nil
+	case GPIOOut:
+		return p.out, nil
+	case GPIOIn:
+		return p.pins(), nil
+	case GPIOIntMask:
+		return p.mask, nil
+	case GPIOIntStat:
+		return p.stat, nil
+	}
+
+	return 0, nil
+}
+
+func (p *GPIO) Write32(addr, val uint32) error {
+	p.mu.Lock()
+	before := p.pins()
+
+	switch addr {
+	case GPIODir:
+		p.dir = val
+	case GPIOOut:
+		p.out = val
+	case GPIOIntMask:
+		p.mask = val
+	case GPIOIntStat:
+		p.stat &^= val
+	}
+
+	after, f := p.pins(), p.notify
+	p.mu.Unlock()
+
+	if f != nil && after != before {
+		f(after)
+	}
+
+	return nil
+}
+
+// Tick records the inputs that changed since the last tick and raises
+// the interrupt line while an unmasked one is recorded.
+func (p *GPIO) Tick(g Guest) {
+	p.mu.Lock()
+	in := p.in &^ p.dir
+	p.stat |= (in ^ p.last) & p.mask
+	p.last = in
+	raise := p.stat&p.mask != 0
+	p.mu.Unlock()
+
+	if raise {
+		g.Raise(p.irq)
+	}
+}
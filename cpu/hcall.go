@@ -0,0 +1,185 @@
+package cpu
+
+import "fmt"
+
+// Hypercall is a host function invoked by the hcall instruction. A
+// non-nil error faults the guest.
+type Hypercall func(g Guest) error
+
+// Guest is the view of the machine handed to a Hypercall. All
+// accesses are bounds checked. Addresses are translated by the MMU as
+// the running program's are, except in the Guest handed to devices,
+// whose addresses are physical.
+type Guest struct {
+	c    *Cpu
+	phys bool
+}
+
+// RegisterHypercall makes f the handler for 'hcall n'. A nil f
+// removes the handler.
+func (c *Cpu) RegisterHypercall(n uint32, f Hypercall) {
+	if f == nil {
+		delete(c.hcall, n)
+		return
+	}
+
+	if c.hcall == nil {
+		c.hcall = make(map[uint32]Hypercall)
+	}
+
+	c.hcall[n] = f
+}
+
+// Guest returns an accessor view of c, for inspecting the machine
+// between calls to Resume.
+func (c *Cpu) Guest() Guest {
+	return Guest{c: c}
+}
+
+// Reg returns the value of register r.
+func (g Guest) Reg(r int) (uint32, error) {
+	if r < 0 || r >= g.c.nreg {
+		return 0, fmt.Errorf("invalid register %02x", r)
+	}
+
+	return g.c.reg[r], nil
+}
+
+// SetReg sets register r to v.
+func (g Guest) SetReg(r int, v uint32) error {
+	if r < 0 || r >= g.c.nreg {
+		return fmt.Errorf("invalid register %02x", r)
+	}
+
+	g.c.reg[r] = v
+
+	if g.c.hook.reg != nil {
+		g.c.hook.reg(r, v)
+	}
+
+	return nil
+}
+
+// Load returns the word at addr.
+func (g Guest) Load(addr uint32) (uint32, error) {
+	if g.phys {
+		return g.c.loadPhys(addr, 4)
+	}
+
+	return g.c.readImm(addr)
+}
+
+// Store writes the word v at addr.
+func (g Guest) Store(addr, v uint32) error {
+	if g.phys {
+		return g.c.storePhys(addr, v, 4)
+	}
+
+	return g.c.writeImm(addr, v)
+}
+
+// Read copies len(p) bytes of memory starting at addr into p.
+func (g Guest) Read(addr uint32, p []byte) error {
+	if g.phys {
+		return g.c.readPhys(addr, p)
+	}
+
+	if n := g.c.split(addr, len(p)); n < len(p) {
+		if err := g.Read(addr, p[:n]); err != nil {
+			return err
+		}
+
+		return g.Read(addr+uint32(n), p[n:])
+	}
+
+	if len(p) > 0 {
+		a, err := g.c.translate(addr, uint32(len(p)), false)
+		if err != nil {
+			return err
+		}
+
+		addr = a
+	}
+
+	return g.c.readPhys(addr, p)
+}
+
+// readPhys is Guest.Read at the physical address addr.
+func (c *Cpu) readPhys(addr uint32, p []byte) error {
+	if uint64(addr)+uint64(len(p)) > uint64(len(c.mem)) {
+		return fmt.Errorf("illegal read %08x", addr)
+	}
+
+	if err := c.guard(addr, uint32(len(p)), ProtR); err != nil {
+		return err
+	}
+
+	c.access(addr, uint32(len(p)))
+	copy(p, c.mem[addr:])
+
+	if c.watch != nil {
+		for i, j := range p {
+			c.watched(addr+uint32(i), 1, uint32(j), false)
+		}
+	}
+
+	return nil
+}
+
+// Write copies p into memory starting at addr.
+func (g Guest) Write(addr uint32, p []byte) error {
+	if g.phys {
+		return g.c.writePhys(addr, p)
+	}
+
+	if n := g.c.split(addr, len(p)); n < len(p) {
+		if err := g.Write(addr, p[:n]); err != nil {
+			return err
+		}
+
+		return g.Write(addr+uint32(n), p[n:])
+	}
+
+	if len(p) > 0 {
+		a, err := g.c.translate(addr, uint32(len(p)), true)
+		if err != nil {
+			return err
+		}
+
+		addr = a
+	}
+
+	return g.c.writePhys(addr, p)
+}
+
+// writePhys is Guest.Write at the physical address addr.
+func (c *Cpu) writePhys(addr uint32, p []byte) error {
+	if uint64(addr)+uint64(len(p)) > uint64(len(c.mem)) {
+		return fmt.Errorf("illegal write (at %08x)", addr)
+	}
+
+	if err := c.guard(addr, uint32(len(p)), ProtW); err != nil {
+		return err
+	}
+
+	c.access(addr, uint32(len(p)))
+	copy(c.mem[addr:], p)
+
+	if c.smc != nil {
+		c.modified(addr, uint32(len(p)))
+	}
+
+	if c.hook.mem != nil {
+		for i, j := range p {
+			c.hook.mem(addr+uint32(i), uint32(j), 1)
+		}
+	}
+
+	if c.watch != nil {
+		for i, j := range p {
+			c.watched(addr+uint32(i), 1, uint32(j), true)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package cpu
+
+import "fmt"
+
+// The heap lies between the highest section and the stack. Its end,
+// the program break, is also the lowest address the stack may use, so
+// moving the break up shrinks the room left for the stack.
+
+// Brk returns the program break, the end of the heap.
+func (c *Cpu) Brk() uint32 {
+	return c.stack.Lo
+}
+
+// SetBrk moves the program break to addr. It fails if addr is below
+// the start of the heap or above the stack pointer, leaving the break
+// where it was. Memory freed by moving the break down keeps its
+// contents.
+func (c *Cpu) SetBrk(addr uint32) error {
+	if addr < c.heap || addr > c.reg[RegSp] {
+		return fmt.Errorf("break %08x outside %08x to %08x", addr, c.heap, c.reg[RegSp])
+	}
+
+	c.stack.Lo = addr
+	return nil
+}
+
+// brk handles SysBrk.
+func brk(g Guest) error {
+	addr, _ := g.Reg(0)
+	if addr != 0 && g.c.SetBrk(addr) != nil {
+		return g.SetReg(0, FSError)
+	}
+
+	return g.SetReg(0, g.c.Brk())
+}
+
+// sbrk handles SysSbrk.
+func sbrk(g Guest) error {
+	n, _ := g.Reg(0)
+	old := g.c.Brk()
+
+	addr := int64(old) + int64(int32(n))
+	if addr < 0 || addr > int64(^uint32(0)) || g.c.SetBrk(uint32(addr)) != nil {
+		return g.SetReg(0, FSError)
+	}
+
+	return g.SetReg(0, old)
+}
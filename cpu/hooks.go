@@ -0,0 +1,44 @@
+package cpu
+
+import "github.com/rtcall/hypo/asm"
+
+// hooks are the callbacks set with OnStep, OnMemWrite and OnRegWrite.
+type hooks struct {
+	step func(d asm.Decoded)
+	mem  func(addr, v, n uint32)
+	reg  func(r int, v uint32)
+}
+
+// OnStep makes c call f with every instruction it executes without
+// faulting, once it has run, so that f sees its results in c. A nil f
+// removes the hook.
+func (c *Cpu) OnStep(f func(d asm.Decoded)) {
+	c.hook.step = f
+}
+
+// OnMemWrite makes c call f after every store to memory, made by an
+// instruction, a hypercall or a device, with the physical address, the
+// value stored and its size in bytes. Stores to the MMIO window are
+// not reported. A nil f removes the hook.
+func (c *Cpu) OnMemWrite(f func(addr, v, n uint32)) {
+	c.hook.mem = f
+}
+
+// OnRegWrite makes c call f after every write to a register by an
+// instruction or a hypercall, with the register and its new value. A
+// nil f removes the hook.
+func (c *Cpu) OnRegWrite(f func(r int, v uint32)) {
+	c.hook.reg = f
+}
+
+// stepped calls the OnStep hook with the instruction at pc.
+func (c *Cpu) stepped(pc uint32) {
+	if c.hook.step == nil || c.err != nil {
+		return
+	}
+
+	t, at := c.text()
+	if d, err := asm.Decode(t[pc-at:], pc); err == nil {
+		c.hook.step(d)
+	}
+}
@@ -0,0 +1,82 @@
+package cpu
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// VectorBase is the address of the interrupt vector table, which
+// holds the handler address of each of the NumIRQ lines, in order.
+const VectorBase = 0
+
+// NumIRQ is the number of interrupt lines.
+const NumIRQ = 32
+
+// Raise makes the interrupt line n, from 0 to NumIRQ-1, pending. It
+// is taken once interrupts are enabled with FlagI.
+func (c *Cpu) Raise(n int) {
+	if n >= 0 && n < NumIRQ {
+		c.irq |= 1 << n
+	}
+}
+
+// Pending returns the pending interrupt lines as a bit mask.
+func (c *Cpu) Pending() uint32 {
+	return c.irq
+}
+
+// SetInterrupts enables or disables interrupts, as ei and di do.
+func (c *Cpu) SetInterrupts(on bool) {
+	if on {
+		c.status |= FlagI
+	} else {
+		c.status &^= FlagI
+	}
+}
+
+// Raise makes the interrupt line n pending, as Cpu.Raise.
+func (g Guest) Raise(n int) {
+	g.c.Raise(n)
+}
+
+// interrupt takes the lowest pending interrupt line if interrupts are
+// enabled: it pushes pc and the status flags, disables interrupts,
+// enters supervisor mode and jumps to the handler in the vector table,
+// which is read from memory even when paging is on. iret undoes it.
+func (c *Cpu) interrupt() {
+	if c.status&FlagI == 0 || c.irq == 0 || !c.State() {
+		return
+	}
+
+	n := bits.TrailingZeros32(c.irq)
+
+	pc, err := c.word(VectorBase + uint32(n)*4)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	c.irq &^= 1 << n
+
+	c.push(c.pc)
+	if c.push(c.status); c.err != nil {
+		return
+	}
+
+	if c.expl != nil {
+		fmt.Fprintf(c.expl, "%08x: interrupt %d: push %08x and status, jump to %08x\n", c.pc, n, c.pc, pc)
+	}
+
+	c.status &^= FlagI | FlagU
+	c.jump(pc)
+}
+
+// iret returns from an interrupt handler, restoring the status flags
+// and pc pushed by interrupt.
+func (c *Cpu) iret() {
+	s := c.pop()
+	if pc := c.pop(); c.err == nil {
+		c.status = s
+		c.jump(pc)
+	}
+}
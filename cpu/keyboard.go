@@ -0,0 +1,97 @@
+package cpu
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// KeyboardBase and KeyboardIRQ are where hypo maps a Keyboard and the
+// interrupt line it raises.
+const (
+	KeyboardBase = 0xf0000300
+	KeyboardIRQ  = 2
+)
+
+// Keyboard registers, as offsets from the base of a Keyboard.
+const (
+	KeyboardData    = 0x0 // the latest key, or EOF if none is waiting
+	KeyboardStatus  = 0x4 // 1 while a key is waiting
+	KeyboardControl = 0x8 // 1 raises the interrupt line on a key
+)
+
+// KeyboardSize is the size of the registers of a Keyboard.
+const KeyboardSize = 0xc
+
+// Keyboard is a Device holding the latest key pressed. A key that is
+// not read before the next press is lost, as on simple hardware.
+type Keyboard struct {
+	key     atomic.Uint32 // the key plus keyWaiting, or 0
+	irq     int
+	control uint32
+}
+
+// keyWaiting marks a key that has not been read.
+const keyWaiting = 1 << 8
+
+// NewKeyboard returns a Keyboard taking its keys from the bytes read
+// from r, such as a terminal in raw mode, and raising the interrupt
+// line irq.
+func NewKeyboard(r io.Reader, irq int) *Keyboard {
+	k := &Keyboard{irq: irq}
+	go k.receive(r)
+	return k
+}
+
+// receive presses each byte read from r until r fails.
+func (k *Keyboard) receive(r io.Reader) {
+	var b [1]byte
+
+	for {
+		if _, err := r.Read(b[:]); err != nil {
+			return
+		}
+
+		k.Press(b[0])
+	}
+}
+
+// Press makes b the latest key. It may be called from any goroutine.
+func (k *Keyboard) Press(b byte) {
+	k.key.Store(keyWaiting | uint32(b))
+}
+
+func (k *Keyboard) Read32(addr uint32) (uint32, error) {
+	switch addr {
+	case KeyboardData:
+		if v := k.key.Swap(0); v != 0 {
+			return v &^ keyWaiting, nil
+		}
+
+		return EOF, nil
+	case KeyboardStatus:
+		if k.key.Load() != 0 {
+			return 1, nil
+		}
+
+		return 0, nil
+	case KeyboardControl:
+		return k.control, nil
+	}
+
+	return 0, nil
+}
+
+func (k *Keyboard) Write32(addr, val uint32) error {
+	if addr == KeyboardControl {
+		k.control = val
+	}
+
+	return nil
+}
+
+// Tick raises the interrupt line while a key is waiting, if enabled.
+func (k *Keyboard) Tick(g Guest) {
+	if k.control&1 != 0 && k.key.Load() != 0 {
+		g.Raise(k.irq)
+	}
+}
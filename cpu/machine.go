@@ -0,0 +1,142 @@
+package cpu
+
+import "fmt"
+
+// Machine is a multicore machine: Cpus, its cores, that run the same
+// image and share one memory, so that they communicate through memory
+// and synchronize with cas and swap. Everything else, such as
+// registers, devices and hypercalls, belongs to each core.
+type Machine struct {
+	Cores []*Cpu
+
+	// Quantum is the number of instructions a core runs before the
+	// next one takes over, 1 if zero.
+	Quantum int
+
+	// Seed, if not zero, makes Run pick the next core at random
+	// instead of in turn, in the same order for the same seed.
+	Seed uint64
+}
+
+// NewMachine returns a Machine of n cores running the image buf. Each
+// core reads its number, from 0 to n-1, from control register 5. The
+// stack space is divided evenly between them, core 0 having the
+// lowest part, so only core 0 can move the program break.
+func NewMachine(buf []byte, n int) (*Machine, error) {
+	return newMachine(n, func() (Cpu, error) {
+		return New(buf)
+	})
+}
+
+// NewRawMachine is NewMachine for code that has no header, loaded at
+// base as by NewRaw.
+func NewRawMachine(code []byte, base uint32, n int) (*Machine, error) {
+	return newMachine(n, func() (Cpu, error) {
+		return NewRaw(code, base)
+	})
+}
+
+// newMachine returns a Machine of n cores made by core.
+func newMachine(n int, core func() (Cpu, error)) (*Machine, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("machine of %d cores", n)
+	}
+
+	m := &Machine{}
+	for i := 0; i < n; i++ {
+		c, err := core()
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			c.mem = m.Cores[0].mem
+		}
+
+		m.Cores = append(m.Cores, &c)
+	}
+
+	m.split()
+	return m, nil
+}
+
+// SetStart sets up every core as Cpu.SetStart does, each with its own
+// part of the stack.
+func (m *Machine) SetStart(s Start) error {
+	for _, j := range m.Cores {
+		if err := j.SetStart(s); err != nil {
+			return err
+		}
+	}
+
+	m.split()
+	return nil
+}
+
+// SetVonNeumann makes every core fetch instructions from memory, as
+// Cpu.SetVonNeumann does, and divides the stack above the code between
+// them again.
+func (m *Machine) SetVonNeumann() error {
+	for _, j := range m.Cores {
+		if err := j.SetVonNeumann(); err != nil {
+			return err
+		}
+	}
+
+	m.split()
+	return nil
+}
+
+// split gives each core its number and its part of the stack.
+func (m *Machine) split() {
+	s := m.Cores[0].stack
+	size := (s.Hi - s.Lo) / uint32(len(m.Cores)) &^ 3
+
+	for i, c := range m.Cores {
+		c.stack = Range{s.Lo + uint32(i)*size, s.Lo + uint32(i+1)*size}
+		if i > 0 {
+			c.heap = c.stack.Lo
+		}
+
+		c.reg[RegSp] = c.stack.Hi
+		c.cr[CrCore] = uint32(i)
+	}
+}
+
+// Run steps the cores, Quantum instructions at a time, until every one
+// has exited. It stops at the first fault of any core. Yields do not
+// stop it. The interleaving depends only on Quantum and Seed, so runs
+// repeat exactly.
+func (m *Machine) Run() error {
+	q := m.Quantum
+	if q < 1 {
+		q = 1
+	}
+
+	rng := NewRNG(m.Seed)
+
+	for turn := 0; ; turn++ {
+		var live []int
+		for i, j := range m.Cores {
+			if j.State() {
+				live = append(live, i)
+			}
+		}
+
+		if len(live) == 0 {
+			return nil
+		}
+
+		i := live[turn%len(live)]
+		if m.Seed != 0 {
+			i = live[rng.next()%uint32(len(live))]
+		}
+
+		c := m.Cores[i]
+		for k := 0; k < q && c.State(); k++ {
+			if err := c.Step(); err != nil {
+				return fmt.Errorf("core %d: %w", i, err)
+			}
+		}
+	}
+}
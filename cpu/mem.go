@@ -0,0 +1,71 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// memObject returns the data or bss object containing addr. Objects
+// end with the section they are in.
+func (c *Cpu) memObject(addr uint32) (asm.Object, uint32, bool) {
+	for _, s := range c.img.Sections {
+		if addr < s.Addr || addr-s.Addr >= s.Size {
+			continue
+		}
+
+		o, off, ok := c.img.Object(s.Kind, addr)
+		return o, off, ok && o.Addr >= s.Addr
+	}
+
+	return asm.Object{}, 0, false
+}
+
+// WriteMem writes a hex and text dump of memory from lo to hi, one
+// 16 byte row at a time. Rows are annotated with the symbols that
+// start in them, or else the symbol they lie in, if the image has a
+// symbol table.
+func (c *Cpu) WriteMem(w io.Writer, lo, hi uint32) {
+	if hi > uint32(len(c.mem)) {
+		hi = uint32(len(c.mem))
+	}
+
+	for row := lo &^ 15; row < hi; row += 16 {
+		var text strings.Builder
+
+		fmt.Fprintf(w, "%08x  ", row)
+		for i := row; i < row+16; i++ {
+			j := c.mem[i]
+			fmt.Fprintf(w, "%02x ", j)
+
+			if j >= 0x20 && j < 0x7f {
+				text.WriteByte(j)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+
+		fmt.Fprintf(w, " |%s|", text.String())
+
+		var names []string
+		for _, o := range c.img.Objects {
+			if o.Kind != asm.SectText && o.Addr >= row && o.Addr < row+16 {
+				names = append(names, fmt.Sprintf("<%s>", o.Name))
+			}
+		}
+
+		if len(names) == 0 {
+			if o, off, ok := c.memObject(row); ok {
+				names = append(names, fmt.Sprintf("<%s+%x>", o.Name, off))
+			}
+		}
+
+		if len(names) > 0 {
+			fmt.Fprintf(w, " %s", strings.Join(names, " "))
+		}
+
+		fmt.Fprintln(w, "")
+	}
+}
@@ -0,0 +1,80 @@
+package cpu
+
+import "github.com/rtcall/hypo/asm"
+
+// MapHypercall is the conventional hypercall number for MemoryMap.
+const MapHypercall = 0x6d6d
+
+// Kinds of memory regions.
+const (
+	RegionText = iota
+	RegionData
+	RegionBss
+	RegionHeap
+	RegionStack
+	RegionInput
+	RegionDevice
+)
+
+// Region is a range of addresses holding one kind of thing. Text is
+// in the code address space, or in memory in von Neumann mode; every
+// other region is in memory.
+type Region struct {
+	Kind int
+	Range
+}
+
+// MemoryMap returns the regions of the machine, in order: the code,
+// each data and bss section, the heap, the stack, the input block set
+// up by SetStart and each device mapped with Map. The heap runs from
+// the highest section to the program break and the stack spans the
+// free memory down to it from the initial stack pointer, so the two
+// grow towards each other.
+func (c *Cpu) MemoryMap() []Region {
+	r := []Region{{RegionText, Range{c.base, c.base + uint32(len(c.img.Code))}}}
+
+	for _, j := range c.img.Sections {
+		k := RegionData
+		if j.Kind == asm.SectBss {
+			k = RegionBss
+		}
+
+		r = append(r, Region{k, Range{j.Addr, j.Addr + j.Size}})
+	}
+
+	r = append(r,
+		Region{RegionHeap, Range{c.heap, c.stack.Lo}},
+		Region{RegionStack, c.stack},
+		Region{RegionInput, c.input})
+
+	for _, j := range c.devs {
+		r = append(r, Region{RegionDevice, j.Range})
+	}
+
+	return r
+}
+
+// MemoryMap is a Hypercall that stores the memory map in the guest
+// buffer at %0, which has room for %1 regions of three words each:
+// kind, start and end. It returns the number of regions in %0, which
+// may exceed %1, in which case only the first %1 are stored.
+func MemoryMap(g Guest) error {
+	buf, _ := g.Reg(0)
+	n, _ := g.Reg(1)
+
+	m := g.c.MemoryMap()
+	for i, j := range m {
+		if uint32(i) >= n {
+			break
+		}
+
+		a := buf + uint32(i)*12
+		for k, v := range []uint32{uint32(j.Kind), j.Lo, j.Hi} {
+			if err := g.Store(a+uint32(k)*4, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return g.SetReg(0, uint32(len(m)))
+}
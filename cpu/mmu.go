@@ -0,0 +1,153 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The MMU maps the VirtSize bytes of the virtual address space to
+// memory in pages of PageSize bytes. Once paging is enabled, every
+// load and store address is translated through the page table, an
+// array of NumPages words in memory whose entry for the page of an
+// address holds the page-aligned physical address of its frame and
+// the Pte bits. Instruction fetches, the MMIO window and the memory
+// accesses of devices are never translated.
+const (
+	PageSize = 256
+	NumPages = 256
+	VirtSize = PageSize * NumPages
+)
+
+// Bits of a page table entry.
+const (
+	PteV = 1 << iota // the entry maps its page
+	PteW             // stores may write the page
+	PteU             // user mode may access the page
+)
+
+// Control registers, read with mfcr and written with mtcr.
+const (
+	CrMmu   = iota // bit 0 enables paging
+	CrPtbr         // physical address of the page table
+	CrFault        // address of the last page fault
+	CrCause        // Cause bits of the last page fault
+	CrSys          // operand of the last sys trapping from user mode
+	CrCore         // number of the core in a Machine
+	NumCr
+)
+
+// Bits of CrCause.
+const (
+	CauseValid = 1 << iota // the entry was valid, so the page was protected
+	CauseWrite             // the access was a store
+	CauseUser              // the access was made in user mode
+)
+
+// PageFault is the vector taken on a page fault. Its handler address is
+// the word after those of the interrupt lines in the vector table.
+const PageFault = NumIRQ
+
+// PageFaultError is the fault of an access that the page table does
+// not allow. It traps to the PageFault handler if there is one.
+type PageFaultError struct {
+	Addr  uint32 // virtual address accessed
+	Cause uint32 // Cause bits
+}
+
+func (e *PageFaultError) Error() string {
+	what := "read"
+	if e.Cause&CauseWrite != 0 {
+		what = "write"
+	}
+
+	if e.Cause&CauseValid != 0 {
+		return fmt.Sprintf("page fault: %s %08x in a protected page", what, e.Addr)
+	}
+
+	return fmt.Sprintf("page fault: %s %08x in an unmapped page", what, e.Addr)
+}
+
+// Cr returns control register n.
+func (c *Cpu) Cr(n int) uint32 {
+	if n < 0 || n >= NumCr {
+		return 0
+	}
+
+	return c.cr[n]
+}
+
+// SetCr sets control register n to v, as mtcr does.
+func (c *Cpu) SetCr(n int, v uint32) error {
+	if n < 0 || n >= NumCr {
+		return fmt.Errorf("invalid control register %x", n)
+	}
+
+	c.cr[n] = v
+	return nil
+}
+
+// paging reports whether the MMU translates addresses.
+func (c *Cpu) paging() bool {
+	return c.cr[CrMmu]&1 != 0
+}
+
+// translate returns the physical address of the n bytes at the virtual
+// address addr, which must not cross a page unless paging is off.
+func (c *Cpu) translate(addr, n uint32, write bool) (uint32, error) {
+	if !c.paging() {
+		return addr, nil
+	}
+
+	var cause uint32
+	if write {
+		cause |= CauseWrite
+	}
+
+	if c.status&FlagU != 0 {
+		cause |= CauseUser
+	}
+
+	end := uint64(addr) + uint64(n) - 1
+	if end >= VirtSize {
+		return 0, &PageFaultError{addr, cause}
+	}
+
+	if addr/PageSize != uint32(end)/PageSize {
+		return 0, fmt.Errorf("access at %08x crosses a page", addr)
+	}
+
+	pte, err := c.word(c.cr[CrPtbr] + addr/PageSize*4)
+	if err != nil {
+		return 0, fmt.Errorf("page table: %s", err)
+	}
+
+	if pte&PteV == 0 {
+		return 0, &PageFaultError{addr, cause}
+	}
+
+	if write && pte&PteW == 0 || cause&CauseUser != 0 && pte&PteU == 0 {
+		return 0, &PageFaultError{addr, cause | CauseValid}
+	}
+
+	return pte&^(PageSize-1) | addr%PageSize, nil
+}
+
+// split returns how many of the n bytes at addr lie in its page, or n
+// if paging is off.
+func (c *Cpu) split(addr uint32, n int) int {
+	if k := int(PageSize - addr%PageSize); c.paging() && k < n {
+		return k
+	}
+
+	return n
+}
+
+// word returns the word at the physical address addr, bypassing the
+// MMU, protection and devices.
+func (c *Cpu) word(addr uint32) (uint32, error) {
+	if addr%4 != 0 || addr > uint32(len(c.mem))-4 {
+		return 0, fmt.Errorf("illegal read %08x", addr)
+	}
+
+	return binary.LittleEndian.Uint32(c.mem[addr:]), nil
+}
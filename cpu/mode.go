@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// Vectors of the traps from user mode, after PageFault in the vector
+// table: Privileged is taken by a privileged instruction and Syscall
+// by sys.
+const (
+	Privileged = PageFault + 1 + iota
+	Syscall
+)
+
+// PrivilegeError is the fault of a privileged instruction run in user
+// mode, or of a load or store to the MMIO window, which only supervisor
+// mode may access. It traps to the Privileged handler if there is one.
+type PrivilegeError struct {
+	Addr uint32 // address of the instruction
+	Op   byte   // its opcode
+	Port uint32 // the MMIO address accessed, or 0
+}
+
+func (e *PrivilegeError) Error() string {
+	if e.Port != 0 {
+		return fmt.Sprintf("%s of MMIO address %08x in user mode", asm.Mnemonic(e.Op), e.Port)
+	}
+
+	return fmt.Sprintf("privileged instruction %s in user mode", asm.Mnemonic(e.Op))
+}
+
+// User reports whether c is in user mode.
+func (c *Cpu) User() bool {
+	return c.status&FlagU != 0
+}
+
+// super is exec for privileged instructions, which fault instead of
+// running in user mode.
+func super(op byte, f func(c *Cpu, a []uint32)) func(*Cpu) int {
+	return exec(op, func(c *Cpu, a []uint32) {
+		if c.User() {
+			c.err = &PrivilegeError{Addr: c.last, Op: op}
+			return
+		}
+
+		f(c, a)
+	})
+}
+
+// port returns the fault of an access to the MMIO address addr in user
+// mode.
+func (c *Cpu) port(addr uint32) error {
+	t, base := c.text()
+	return &PrivilegeError{c.last, t[c.last-base], addr}
+}
+
+// trap takes the PageFault or Privileged vector if the instruction at
+// pc faulted for either and a handler is set: it restores the
+// registers and flags of before the instruction, records a page fault
+// in CrFault and CrCause and enters the handler as interrupt does, so
+// that iret restarts the instruction.
+func (c *Cpu) trap(pc uint32, reg [MaxRegs]uint32, status uint32) {
+	var f *PageFaultError
+	var p *PrivilegeError
+
+	var n uint32
+	switch {
+	case errors.As(c.err, &f):
+		n = PageFault
+	case errors.As(c.err, &p):
+		n = Privileged
+	default:
+		return
+	}
+
+	h, err := c.word(VectorBase + n*4)
+	if err != nil || h == 0 {
+		return
+	}
+
+	why := c.err
+	c.err = nil
+	c.reg = reg
+	c.status = status
+
+	if f != nil {
+		c.cr[CrFault] = f.Addr
+		c.cr[CrCause] = f.Cause
+	}
+
+	c.enter(h, pc)
+	if c.expl != nil && c.err == nil {
+		fmt.Fprintf(c.expl, "%08x: %s: push %08x and status, jump to %08x\n", pc, why, pc, h)
+	}
+}
+
+// enter pushes pc and the status flags, disables interrupts and jumps
+// to the handler h in supervisor mode. A fault while pushing is a
+// double fault.
+func (c *Cpu) enter(h, pc uint32) {
+	c.push(pc)
+	if c.push(c.status); c.err != nil {
+		c.err = fmt.Errorf("double fault: %s", c.err)
+		return
+	}
+
+	c.status &^= FlagI | FlagU
+	c.jump(h)
+}
@@ -0,0 +1,262 @@
+package cpu
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// NetBase and NetIRQ are where hypo maps a Net and the interrupt line
+// it raises.
+const (
+	NetBase = 0xf0000900
+	NetIRQ  = 6
+)
+
+// Net registers, as offsets from the base of a Net.
+const (
+	NetAddr    = 0x00 // the address of a NUL-terminated "host:port"
+	NetBuf     = 0x04 // the address of the data to send or receive
+	NetLen     = 0x08 // the length of the buffer, then the bytes moved
+	NetCommand = 0x0c // write a command to start it
+	NetStatus  = 0x10 // NetBusy, NetError and NetClosed
+	NetControl = 0x14 // 1 raises the interrupt line when a command ends
+)
+
+// Net commands.
+const (
+	NetConnect = iota + 1 // connect to the address
+	NetListen             // wait for one connection on the address
+	NetSend               // send the buffer
+	NetRecv               // receive up to the length of the buffer
+	NetClose              // close the connection
+)
+
+// Bits of the net status register.
+const (
+	NetBusy   = 1 << iota // a command has not finished
+	NetError              // the last command failed
+	NetClosed             // the peer closed the connection
+)
+
+// NetSize is the size of the registers of a Net.
+const NetSize = 0x18
+
+// Net is a Device giving the guest one TCP connection, made as a
+// client or as a server. Commands run in the background while the
+// guest continues; it polls the status or waits for the interrupt.
+type Net struct {
+	irq     int
+	addr    uint32
+	buf     uint32
+	n       uint32
+	command uint32
+	status  uint32
+	control uint32
+	start   bool
+	done    chan netResult
+	conn    net.Conn
+}
+
+// netResult is the outcome of a command run in the background.
+type netResult struct {
+	conn net.Conn
+	data []byte
+	err  error
+}
+
+// NewNet returns a Net without a connection, raising the interrupt
+// line irq.
+func NewNet(irq int) *Net {
+	return &Net{irq: irq}
+}
+
+func (d *Net) Read32(addr uint32) (uint32, error) {
+	switch addr {
+	case NetAddr:
+		return d.addr, nil
+	case NetBuf:
+		return d.buf, nil
+	case NetLen:
+		return d.n, nil
+	case NetCommand:
+		return d.command, nil
+	case NetStatus:
+		return d.status, nil
+	case NetControl:
+		return d.control, nil
+	}
+
+	return 0, nil
+}
+
+func (d *Net) Write32(addr, val uint32) error {
+	if d.status&NetBusy != 0 && addr != NetControl {
+		return nil
+	}
+
+	switch addr {
+	case NetAddr:
+		d.addr = val
+	case NetBuf:
+		d.buf = val
+	case NetLen:
+		d.n = val
+	case NetCommand:
+		d.command = val
+		d.status = NetBusy
+		d.start = true
+	case NetControl:
+		d.control = val
+	}
+
+	return nil
+}
+
+// Tick starts a command that was written, and completes one that has
+// finished in the background.
+func (d *Net) Tick(g Guest) {
+	if d.start {
+		d.start = false
+		if err := d.begin(g); err != nil {
+			d.finish(g, NetError)
+		}
+
+		return
+	}
+
+	if d.done == nil {
+		return
+	}
+
+	select {
+	case r := <-d.done:
+		d.done = nil
+		d.end(g, r)
+	default:
+	}
+}
+
+// begin starts the command, reading its operands from guest memory.
+func (d *Net) begin(g Guest) error {
+	var run func() netResult
+
+	switch d.command {
+	case NetConnect, NetListen:
+		addr, err := path(g, d.addr)
+		if err != nil {
+			return err
+		}
+
+		if d.conn != nil {
+			d.conn.Close()
+			d.conn = nil
+		}
+
+		dial := d.command == NetConnect
+		run = func() netResult {
+			if dial {
+				c, err := net.Dial("tcp", addr)
+				return netResult{conn: c, err: err}
+			}
+
+			return accept(addr)
+		}
+	case NetSend:
+		if d.conn == nil {
+			return net.ErrClosed
+		}
+
+		if uint64(d.n) > uint64(len(g.c.mem)) {
+			return errors.New("send longer than memory")
+		}
+
+		b := make([]byte, d.n)
+		if err := g.Read(d.buf, b); err != nil {
+			return err
+		}
+
+		c := d.conn
+		run = func() netResult {
+			_, err := c.Write(b)
+			return netResult{data: b, err: err}
+		}
+	case NetRecv:
+		if d.conn == nil {
+			return net.ErrClosed
+		}
+
+		if uint64(d.n) > uint64(len(g.c.mem)) {
+			return errors.New("receive longer than memory")
+		}
+
+		b := make([]byte, d.n)
+		c := d.conn
+		run = func() netResult {
+			n, err := c.Read(b)
+			return netResult{data: b[:n], err: err}
+		}
+	case NetClose:
+		if d.conn != nil {
+			d.conn.Close()
+			d.conn = nil
+		}
+
+		d.finish(g, 0)
+		return nil
+	default:
+		return errors.New("unknown net command")
+	}
+
+	d.done = make(chan netResult, 1)
+	go func() { d.done <- run() }()
+	return nil
+}
+
+// end completes the command with the result r.
+func (d *Net) end(g Guest, r netResult) {
+	if r.conn != nil {
+		d.conn = r.conn
+	}
+
+	if d.command == NetRecv {
+		if err := g.Write(d.buf, r.data); err != nil {
+			r.err = err
+		}
+	}
+
+	if d.command == NetSend || d.command == NetRecv {
+		d.n = uint32(len(r.data))
+	}
+
+	switch {
+	case r.err == io.EOF:
+		d.finish(g, NetClosed)
+	case r.err != nil:
+		d.finish(g, NetError)
+	default:
+		d.finish(g, 0)
+	}
+}
+
+// finish ends the command with the status s.
+func (d *Net) finish(g Guest, s uint32) {
+	d.status = s
+
+	if d.control&1 != 0 {
+		g.Raise(d.irq)
+	}
+}
+
+// accept waits for one connection on addr.
+func accept(addr string) netResult {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return netResult{err: err}
+	}
+
+	defer l.Close()
+
+	c, err := l.Accept()
+	return netResult{conn: c, err: err}
+}
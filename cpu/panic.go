@@ -0,0 +1,79 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// PanicWindow is the number of instructions shown on either side of
+// the faulting one by WritePanic.
+const PanicWindow = 4
+
+// WritePanic writes a disassembly of the code around the last
+// executed instruction, marking it, followed by the registers it
+// referenced.
+func (c *Cpu) WritePanic(w io.Writer) {
+	code := asm.Disasm(c.loaded(), c.base)
+
+	at := -1
+	for i, d := range code {
+		if d.Addr == c.last {
+			at = i
+			break
+		}
+	}
+
+	fmt.Fprintf(w, "fault at %08x", c.last)
+	if l, ok := c.Source(c.last); ok {
+		fmt.Fprintf(w, " (%s:%d)", l.File, l.Line)
+	}
+
+	fmt.Fprintln(w, "")
+
+	if at < 0 {
+		fmt.Fprintln(w, "pc is outside the code")
+		return
+	}
+
+	fmt.Fprintln(w, "")
+
+	for i := at - PanicWindow; i <= at+PanicWindow; i++ {
+		if i < 0 || i >= len(code) {
+			continue
+		}
+
+		mark := "  "
+		if i == at {
+			mark = "=>"
+		}
+
+		fmt.Fprintf(w, "%s %08x  %-20s", mark, code[i].Addr, code[i])
+		if l, ok := c.Source(code[i].Addr); ok {
+			fmt.Fprintf(w, " %s:%d", l.File, l.Line)
+		}
+
+		fmt.Fprintln(w, "")
+	}
+
+	d := code[at]
+	seen := make(map[uint32]bool)
+
+	for i, j := range d.Args {
+		if d.Kinds[i] != asm.Reg || seen[j] {
+			continue
+		}
+
+		if len(seen) == 0 {
+			fmt.Fprintln(w, "\nregisters referenced:")
+		}
+
+		seen[j] = true
+		if j < uint32(c.nreg) {
+			fmt.Fprintf(w, "%%%d = %08x\n", j, c.reg[j])
+		} else {
+			fmt.Fprintf(w, "%%%d = invalid\n", j)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package cpu
+
+import "fmt"
+
+// Protection bits of memory, given to Protect. Memory no region covers
+// allows everything.
+const (
+	ProtR = 1 << iota // loads may read it
+	ProtW             // stores may write it
+	ProtX             // instructions may be fetched from it
+)
+
+// protection is a region of memory with the protection bits set by
+// Protect.
+type protection struct {
+	Range
+	prot uint32
+}
+
+// Protect sets the protection of the memory in r to prot, a set of
+// ProtR, ProtW and ProtX. Accesses that prot does not allow fault at
+// the instruction making them, including those of hypercalls and
+// devices on behalf of the guest. Where regions overlap, the one
+// protected last applies. ProtX only matters in von Neumann mode, as
+// instructions are otherwise never fetched from memory.
+func (c *Cpu) Protect(r Range, prot uint32) error {
+	if r.Lo >= r.Hi || r.Hi > uint32(len(c.mem)) {
+		return fmt.Errorf("protected range %08x:%08x is outside memory", r.Lo, r.Hi)
+	}
+
+	if prot&^(ProtR|ProtW|ProtX) != 0 {
+		return fmt.Errorf("bad protection %x", prot)
+	}
+
+	c.prot = append(c.prot, protection{r, prot})
+	return nil
+}
+
+// SetWX protects memory so that no byte is both writable and
+// executable: the code copied by SetVonNeumann may be read and run but
+// not written, and the rest of memory read and written but not run.
+// Call it after SetVonNeumann.
+func (c *Cpu) SetWX() {
+	c.prot = append(c.prot, protection{Range{0, uint32(len(c.mem))}, ProtR | ProtW})
+
+	if c.vn {
+		code := Range{c.base, c.base + uint32(len(c.img.Code))}
+		if code.Lo < code.Hi {
+			c.prot = append(c.prot, protection{code, ProtR | ProtX})
+		}
+	}
+}
+
+// protOf returns the protection of the byte at addr.
+func (c *Cpu) protOf(addr uint32) uint32 {
+	for i := len(c.prot) - 1; i >= 0; i-- {
+		if c.prot[i].Contains(addr) {
+			return c.prot[i].prot
+		}
+	}
+
+	return ProtR | ProtW | ProtX
+}
+
+// guard returns an error if any of the n bytes at addr lacks the
+// protection bit p.
+func (c *Cpu) guard(addr, n, p uint32) error {
+	if len(c.prot) == 0 {
+		return nil
+	}
+
+	for i := uint32(0); i < n; i++ {
+		if q := c.protOf(addr + i); q&p == 0 {
+			return fmt.Errorf("%s %08x violates %s protection", protVerb[p], addr+i, protString(q))
+		}
+	}
+
+	return nil
+}
+
+var protVerb = map[uint32]string{
+	ProtR: "read at",
+	ProtW: "write at",
+	ProtX: "fetch at",
+}
+
+// protString returns prot as letters, such as "r-x".
+func protString(prot uint32) string {
+	b := []byte("---")
+	for i, j := range "rwx" {
+		if prot&(1<<i) != 0 {
+			b[i] = byte(j)
+		}
+	}
+
+	return string(b)
+}
@@ -0,0 +1,113 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RetireVersion is the version of the retirement log format.
+const RetireVersion = 1
+
+// A retirement log records every retired instruction in a form meant
+// to stay comparable across interpreter versions. It starts with the
+// line "hypo-retire" and the version, followed by one line per step:
+//
+//	pc op r0 r1 r2 r3 r4 r5 r6 r7
+//
+// giving the address and opcode of the instruction and the registers
+// after it, all in hex. A step that faults is followed by a line
+// "fault: " and the error.
+
+// SetRetireLog writes a retirement log of the instructions executed
+// from now on to w. A nil w disables the log.
+func (c *Cpu) SetRetireLog(w io.Writer) {
+	c.tr.log = w
+	if w != nil {
+		fmt.Fprintf(w, "hypo-retire %d\n", RetireVersion)
+	}
+}
+
+func (c *Cpu) retire(pc uint32, op byte) {
+	if c.tr.log == nil {
+		return
+	}
+
+	fmt.Fprintf(c.tr.log, "%08x %02x", pc, op)
+	for _, j := range c.reg[:c.nreg] {
+		fmt.Fprintf(c.tr.log, " %08x", j)
+	}
+
+	fmt.Fprintln(c.tr.log, "")
+}
+
+// fault notes err, returned by the current step, in the retirement
+// log.
+func (c *Cpu) fault(err error) error {
+	if err != nil && c.tr.log != nil {
+		fmt.Fprintf(c.tr.log, "fault: %s\n", err)
+	}
+
+	return err
+}
+
+// VerifyRetire runs c to completion and checks that it retires the
+// same instructions as the retirement log read from r. It returns an
+// error describing the first difference.
+func VerifyRetire(c *Cpu, r io.Reader) error {
+	old := bufio.NewScanner(r)
+	n := 0
+
+	var b bytes.Buffer
+	c.SetRetireLog(&b)
+	defer c.SetRetireLog(nil)
+
+	check := func() error {
+		for b.Len() > 0 {
+			s, _ := b.ReadString('\n')
+			s = strings.TrimSuffix(s, "\n")
+
+			switch {
+			case !old.Scan():
+				if n == 0 {
+					return errors.New("empty log")
+				}
+
+				return fmt.Errorf("step %d: run continues past end of log", n)
+			case n == 0 && old.Text() != s:
+				return fmt.Errorf("unsupported log '%s'", old.Text())
+			case old.Text() != s:
+				return fmt.Errorf("step %d: got '%s', log has '%s'", n, s, old.Text())
+			}
+
+			n++
+		}
+
+		return nil
+	}
+
+	if err := check(); err != nil {
+		return err
+	}
+
+	for c.State() {
+		err := c.Step()
+
+		if err := check(); err != nil {
+			return err
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if old.Scan() {
+		return fmt.Errorf("step %d: log continues past end of run", n)
+	}
+
+	return old.Err()
+}
@@ -0,0 +1,55 @@
+package cpu
+
+// RNGBase is where hypo maps an RNG.
+const RNGBase = 0xf0000500
+
+// RNG registers, as offsets from the base of an RNG.
+const (
+	RNGData = 0x0 // the next random word
+	RNGSeed = 0x4 // write to restart the stream from a seed
+)
+
+// RNGSize is the size of the registers of an RNG.
+const RNGSize = 0x8
+
+// RNG is a Device producing a stream of pseudo-random words that is
+// the same for the same seed, on every host and Go version.
+type RNG struct {
+	state uint64
+}
+
+// NewRNG returns an RNG seeded with seed.
+func NewRNG(seed uint64) *RNG {
+	return &RNG{seed}
+}
+
+// Seed restarts the stream of r from seed.
+func (r *RNG) Seed(seed uint64) {
+	r.state = seed
+}
+
+// next returns the next word of the stream, using splitmix64.
+func (r *RNG) next() uint32 {
+	r.state += 0x9e3779b97f4a7c15
+
+	z := r.state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return uint32((z ^ z>>31) >> 32)
+}
+
+func (r *RNG) Read32(addr uint32) (uint32, error) {
+	if addr == RNGData {
+		return r.next(), nil
+	}
+
+	return 0, nil
+}
+
+func (r *RNG) Write32(addr, val uint32) error {
+	if addr == RNGSeed {
+		r.Seed(uint64(val))
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// RunUntil executes at least one instruction and stops before the
+// instruction at pc. It returns an error if the machine faults or
+// exits first. Yields do not stop it.
+func (c *Cpu) RunUntil(pc uint32) error {
+	for {
+		if err := c.Step(); err != nil {
+			return err
+		}
+
+		if c.pc == pc {
+			return nil
+		}
+
+		if !c.State() {
+			return fmt.Errorf("exited before reaching %08x", pc)
+		}
+	}
+}
+
+// RunUntilReturn executes until the current routine returns: until a
+// ret that is not matched by a call made since. It returns an error if
+// the machine faults or exits first.
+func (c *Cpu) RunUntilReturn() error {
+	depth := 0
+
+	for {
+		var op byte
+		if t, at := c.text(); c.inCode(c.pc) {
+			op = t[c.pc-at]
+		}
+
+		if err := c.Step(); err != nil {
+			return err
+		}
+
+		switch op {
+		case asm.OpCall, asm.OpCallr:
+			depth++
+		case asm.OpRet:
+			if depth == 0 {
+				return nil
+			}
+
+			depth--
+		}
+
+		if !c.State() {
+			return fmt.Errorf("exited before returning")
+		}
+	}
+}
+
+// Call runs the routine at addr as the call instruction would, pushing
+// the current pc as the return address, and stops once it returns.
+// Registers and memory can be set beforehand with Guest.
+func (c *Cpu) Call(addr uint32) error {
+	ret := c.pc
+
+	if c.push(ret); c.err != nil {
+		return c.err
+	}
+
+	if err := c.jump(addr); err != nil {
+		return err
+	}
+
+	if err := c.RunUntilReturn(); err != nil {
+		return err
+	}
+
+	if c.pc != ret {
+		return fmt.Errorf("returned to %08x instead of %08x", c.pc, ret)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+)
+
+// Modification is a store into memory that instructions had already
+// been fetched from, found by TrackSelfModify.
+type Modification struct {
+	Pc   uint32 // address of the instruction making the store
+	Addr uint32 // address written
+	Size uint32 // bytes written
+}
+
+func (m Modification) String() string {
+	return fmt.Sprintf("pc %08x: %d-byte write to code at %08x", m.Pc, m.Size, m.Addr)
+}
+
+// selfModify is the state of TrackSelfModify.
+type selfModify struct {
+	ran  []bool
+	mods []Modification
+	w    io.Writer
+}
+
+// TrackSelfModify makes c note every store, by an instruction, a
+// hypercall or a device, into bytes of memory it has fetched
+// instructions from. Code is only in memory in von Neumann mode, so
+// nothing is found otherwise. Each store is written to w as it
+// happens, if w is not nil, and all of them are returned by
+// SelfModified. Instructions are decoded afresh on every fetch, so
+// those fetched after a store see the new bytes; there is no decode
+// cache to invalidate.
+func (c *Cpu) TrackSelfModify(w io.Writer) {
+	c.smc = &selfModify{ran: make([]bool, len(c.mem)), w: w}
+}
+
+// SelfModified returns the stores into code found since
+// TrackSelfModify, oldest first.
+func (c *Cpu) SelfModified() []Modification {
+	if c.smc == nil {
+		return nil
+	}
+
+	return append([]Modification(nil), c.smc.mods...)
+}
+
+// WriteSelfModified writes the stores into code found since
+// TrackSelfModify, one per line, with the source line of the
+// instruction making each if it is known.
+func (c *Cpu) WriteSelfModified(w io.Writer) {
+	for _, j := range c.SelfModified() {
+		if l, ok := c.Source(j.Pc); ok {
+			fmt.Fprintf(w, "%s %s:%d\n", j, l.File, l.Line)
+		} else {
+			fmt.Fprintln(w, j)
+		}
+	}
+}
+
+// fetched notes that instructions were fetched from the n bytes of
+// memory at addr.
+func (c *Cpu) fetched(addr, n uint32) {
+	for i := addr; i < addr+n && int(i) < len(c.smc.ran); i++ {
+		c.smc.ran[i] = true
+	}
+}
+
+// modified records the store of n bytes at addr if any of them had
+// been fetched as instructions.
+func (c *Cpu) modified(addr, n uint32) {
+	for i := addr; i < addr+n && int(i) < len(c.smc.ran); i++ {
+		if !c.smc.ran[i] {
+			continue
+		}
+
+		m := Modification{c.last, addr, n}
+		c.smc.mods = append(c.smc.mods, m)
+
+		if c.smc.w != nil {
+			fmt.Fprintf(c.smc.w, "smc: %s\n", m)
+		}
+
+		return
+	}
+}
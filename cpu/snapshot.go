@@ -0,0 +1,75 @@
+package cpu
+
+// Snapshot is a saved machine state. Host configuration such as
+// hypercalls, costs and tracing is not part of it.
+//
+// For persistent fuzzing, run the guest until it yields just before
+// the code under test, take a Snapshot, then for each input Restore
+// it, place the input in memory with Guest and call Resume.
+type Snapshot struct {
+	reg    [MaxRegs]uint32
+	mem    [8192]byte
+	pc     uint32
+	flags  uint32
+	code   uint32
+	status uint32
+	stack  Range
+	cr     [NumCr]uint32
+	irq    uint32
+	thr    *threadState
+	err    error
+	yield  bool
+	last   uint32
+	steps  uint64
+	cycles uint64
+}
+
+// Snapshot saves the current state of c.
+func (c *Cpu) Snapshot() *Snapshot {
+	var thr *threadState
+	if c.thr != nil {
+		thr = c.thr.save()
+	}
+
+	return &Snapshot{
+		reg:    c.reg,
+		mem:    *c.mem,
+		pc:     c.pc,
+		flags:  c.flags,
+		code:   c.code,
+		status: c.status,
+		stack:  c.stack,
+		cr:     c.cr,
+		irq:    c.irq,
+		thr:    thr,
+		err:    c.err,
+		yield:  c.yield,
+		last:   c.last,
+		steps:  c.cost.steps,
+		cycles: c.cost.cycles,
+	}
+}
+
+// Restore returns c to the state saved in s, which must have been
+// taken from a Cpu running the same image.
+func (c *Cpu) Restore(s *Snapshot) {
+	c.reg = s.reg
+	*c.mem = s.mem
+	c.flags = s.flags
+	c.code = s.code
+	c.status = s.status
+	c.stack = s.stack
+	c.cr = s.cr
+	c.irq = s.irq
+	c.yield = s.yield
+	c.last = s.last
+	c.cost.steps = s.steps
+	c.cost.cycles = s.cycles
+
+	if c.thr != nil && s.thr != nil {
+		c.thr.load(s.thr)
+	}
+
+	c.seek(s.pc)
+	c.err = s.err
+}
@@ -0,0 +1,55 @@
+package cpu
+
+import "fmt"
+
+// setStack sets the bounds of the stack: from the end of the highest
+// section below top, or of the code in von Neumann mode, rounded up to
+// a word, up to top. The heap starts there, empty.
+func (c *Cpu) setStack(top uint32) {
+	var end uint32
+	for _, j := range c.img.Sections {
+		if e := j.Addr + j.Size; e > end && e <= top {
+			end = e
+		}
+	}
+
+	if e := c.base + uint32(len(c.img.Code)); c.vn && e > end && e <= top {
+		end = e
+	}
+
+	if end = (end + 3) &^ 3; end > top {
+		end = top
+	}
+
+	c.heap = end
+	c.stack = Range{end, top}
+}
+
+// push stores v on the stack, faulting if the stack is full.
+func (c *Cpu) push(v uint32) {
+	sp := c.reg[RegSp]
+	if !c.paging() && (sp < c.stack.Lo+4 || sp > c.stack.Hi) {
+		c.err = fmt.Errorf("stack overflow: push at %08x", sp)
+		return
+	}
+
+	if c.err = c.writeImm(sp-4, v); c.err == nil {
+		c.writeReg(RegSp, sp-4)
+	}
+}
+
+// pop loads a word from the stack, faulting if the stack is empty.
+func (c *Cpu) pop() uint32 {
+	sp := c.reg[RegSp]
+	if !c.paging() && (sp < c.stack.Lo || sp+4 > c.stack.Hi) {
+		c.err = fmt.Errorf("stack underflow: pop at %08x", sp)
+		return 0
+	}
+
+	v, err := c.readImm(sp)
+	if c.err = err; err == nil {
+		c.writeReg(RegSp, sp+4)
+	}
+
+	return v
+}
@@ -0,0 +1,128 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// Startup registers. A program starts with RegArgc holding the number
+// of words in its input block, RegArgv the address of the block and
+// RegSp a stack pointer just below it, for a stack growing down to the
+// highest section. All other registers are zero.
+//
+// A program given argument strings instead finds in its input block
+// an argv array of RegArgc pointers followed by a zero word, then the
+// strings themselves, each NUL terminated.
+const (
+	RegArgc = 0
+	RegArgv = 1
+	RegSp   = asm.RegSp
+)
+
+// Register file sizes. New and NewRaw give a Cpu NumRegs registers;
+// SetRegs selects another machine profile, up to MaxRegs.
+const (
+	NumRegs = asm.NumRegs
+	MaxRegs = 32
+)
+
+// SetRegs gives c n registers, which asm.ValidRegs must accept. It
+// zeroes registers beyond the old count, and is meant to be called
+// before the first Step, with the count the program was assembled
+// for.
+func (c *Cpu) SetRegs(n int) error {
+	if !asm.ValidRegs(n) {
+		return fmt.Errorf("machines have 8, 16 or 32 registers, not %d", n)
+	}
+
+	for i := c.nreg; i < n; i++ {
+		c.reg[i] = 0
+	}
+
+	c.nreg = n
+	return nil
+}
+
+// NumRegs returns the number of registers of c.
+func (c *Cpu) NumRegs() int {
+	return c.nreg
+}
+
+// Start is the state a program starts in.
+type Start struct {
+	Args []uint32 // words of the input block
+	Argv []string // argument strings, instead of Args
+	Top  uint32   // end of the input block, the end of memory if 0
+}
+
+// words returns the words of the input block of s at addr, and the
+// value of RegArgc.
+func (s Start) words(addr uint32) ([]uint32, uint32) {
+	if len(s.Argv) == 0 {
+		return s.Args, uint32(len(s.Args))
+	}
+
+	var b []byte
+	ptr := make([]uint32, len(s.Argv)+1)
+	str := addr + uint32(len(ptr))*4
+
+	for i, j := range s.Argv {
+		ptr[i] = str + uint32(len(b))
+		b = append(append(b, j...), 0)
+	}
+
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+
+	for i := 0; i < len(b); i += 4 {
+		ptr = append(ptr, binary.LittleEndian.Uint32(b[i:]))
+	}
+
+	return ptr, uint32(len(s.Argv))
+}
+
+// SetStart sets up the startup registers and input block described
+// by s. New and NewRaw apply the zero Start, so embedders only call
+// it to pass input, before the first Step.
+func (c *Cpu) SetStart(s Start) error {
+	top := s.Top
+	if top == 0 {
+		top = uint32(len(c.mem))
+	}
+
+	if len(s.Args) > 0 && len(s.Argv) > 0 {
+		return fmt.Errorf("input words and argument strings given together")
+	}
+
+	// The size of the block does not depend on its address.
+	w, _ := s.words(0)
+
+	n := uint64(len(w)) * 4
+	if top > uint32(len(c.mem)) || n > uint64(top) {
+		return fmt.Errorf("input block of %d words does not fit below %08x", len(w), top)
+	}
+
+	addr := top - uint32(n)
+	w, argc := s.words(addr)
+
+	for _, j := range c.img.Sections {
+		if n > 0 && addr < j.Addr+j.Size && j.Addr < top {
+			return fmt.Errorf("input block at %08x overlaps section at %08x", addr, j.Addr)
+		}
+	}
+
+	for i, j := range w {
+		binary.LittleEndian.PutUint32(c.mem[addr+uint32(i)*4:], j)
+	}
+
+	c.input = Range{addr, top}
+	c.setStack(addr)
+	c.reg = [MaxRegs]uint32{}
+	c.reg[RegArgc] = argc
+	c.reg[RegArgv] = addr
+	c.reg[RegSp] = addr
+	return nil
+}
@@ -0,0 +1,69 @@
+package cpu
+
+// Status flags, set by arithmetic instructions and tested by bcs, bvs
+// and bz. FlagI is instead set by ei and cleared by di, and FlagU is
+// set in user mode.
+const (
+	FlagZ = 1 << iota // the result was zero
+	FlagN             // the result was negative
+	FlagC             // add carried out or sub borrowed
+	FlagV             // the signed result overflowed
+	FlagI             // interrupts are enabled
+	FlagU             // the cpu is in user mode
+)
+
+// Status returns the status flags.
+func (c *Cpu) Status() uint32 {
+	return c.status
+}
+
+// statusString returns the set status flags as letters, such as
+// "ZC", or "-" if none is set.
+func (c *Cpu) statusString() string {
+	var b []byte
+	for i, j := range "ZNCVIU" {
+		if c.status&(1<<i) != 0 {
+			b = append(b, byte(j))
+		}
+	}
+
+	if len(b) == 0 {
+		return "-"
+	}
+
+	return string(b)
+}
+
+// setStatus sets the flags for the result r of an arithmetic
+// instruction that carried or overflowed as given.
+func (c *Cpu) setStatus(r uint32, carry, overflow bool) {
+	c.status &= FlagI | FlagU
+
+	if r == 0 {
+		c.status |= FlagZ
+	}
+
+	if int32(r) < 0 {
+		c.status |= FlagN
+	}
+
+	if carry {
+		c.status |= FlagC
+	}
+
+	if overflow {
+		c.status |= FlagV
+	}
+}
+
+// add returns a+b, whether it carried and whether it overflowed.
+func add(a, b uint32) (uint32, bool, bool) {
+	r := a + b
+	return r, r < a, int32(^(a^b)&(a^r)) < 0
+}
+
+// sub returns a-b, whether it borrowed and whether it overflowed.
+func sub(a, b uint32) (uint32, bool, bool) {
+	r := a - b
+	return r, b > a, int32((a^b)&(a^r)) < 0
+}
@@ -0,0 +1,74 @@
+package cpu
+
+import "io"
+
+// StrictWriter normalizes guest output so that it can be compared
+// byte for byte:
+//
+//   - "\r\n" and a lone "\r" are written as "\n"
+//   - spaces and tabs before a line break are dropped
+//   - spaces and tabs at the end of the output are dropped by Flush
+//
+// Everything else is passed through unchanged. Guest output does not
+// depend on the host locale.
+type StrictWriter struct {
+	w    io.Writer
+	pend []byte
+	cr   bool
+}
+
+func NewStrictWriter(w io.Writer) *StrictWriter {
+	return &StrictWriter{w: w}
+}
+
+func (s *StrictWriter) Write(p []byte) (int, error) {
+	var out []byte
+
+	for _, c := range p {
+		switch {
+		case c == '\r':
+			if s.cr {
+				out = append(out, '\n')
+			}
+			s.pend = s.pend[:0]
+			s.cr = true
+		case c == '\n':
+			out = append(out, '\n')
+			s.pend = s.pend[:0]
+			s.cr = false
+		case c == ' ' || c == '\t':
+			if s.cr {
+				out = append(out, '\n')
+				s.cr = false
+			}
+			s.pend = append(s.pend, c)
+		default:
+			if s.cr {
+				out = append(out, '\n')
+				s.cr = false
+			}
+			out = append(out, s.pend...)
+			out = append(out, c)
+			s.pend = s.pend[:0]
+		}
+	}
+
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush ends the output, writing a pending line break.
+func (s *StrictWriter) Flush() error {
+	s.pend = s.pend[:0]
+
+	if s.cr {
+		s.cr = false
+		_, err := s.w.Write([]byte{'\n'})
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package cpu
+
+import "fmt"
+
+// SyscallHandler handles the sys instruction. num is its operand, and
+// any arguments and results are passed in registers and memory, which
+// the handler reaches with c.Guest(). A non-nil error faults the
+// guest.
+type SyscallHandler func(c *Cpu, num uint32) error
+
+// SetSyscallHandler makes f the handler of every 'sys n'. Unlike
+// hypercalls, which are registered one number at a time, a single
+// handler serves all numbers, so embedders can expose a whole system
+// interface. A nil f removes the handler, and sys then faults.
+func (c *Cpu) SetSyscallHandler(f SyscallHandler) {
+	c.sys = f
+}
+
+// syscall handles 'sys n'. In user mode, it enters the Syscall
+// handler instead if there is one, with n in CrSys, to return to the
+// next instruction.
+func syscall(c *Cpu, a []uint32) {
+	if h, err := c.word(VectorBase + Syscall*4); c.User() && err == nil && h != 0 {
+		c.cr[CrSys] = a[0]
+		c.enter(h, c.pc+4)
+		return
+	}
+
+	if c.sys == nil {
+		c.err = fmt.Errorf("no syscall handler for sys %08x", a[0])
+		return
+	}
+
+	c.err = c.sys(c, a[0])
+}
@@ -0,0 +1,236 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Numbers of the standard syscalls served by Syscalls.
+const (
+	SysOpen = iota + 1
+	SysRead
+	SysWrite
+	SysClose
+	SysBrk
+	SysSbrk
+)
+
+// Syscalls serves the standard syscalls, giving the guest the files
+// below a root directory of the host and nothing else. Registers hold
+// the arguments and register 0 the result, as for FS:
+//
+//	sys SysOpen   %0 = path, NUL terminated, %1 = mode   -> fd
+//	sys SysRead   %0 = fd, %1 = buffer, %2 = length     -> bytes read
+//	sys SysWrite  %0 = fd, %1 = buffer, %2 = length     -> bytes written
+//	sys SysClose  %0 = fd
+//	sys SysBrk    %0 = new break, or 0 to ask          -> break
+//	sys SysSbrk   %0 = signed increment                -> old break
+//
+// Modes are those of FSOpen, and failures return FSError. Paths are
+// taken relative to the root, and those leading out of it, through
+// ".." or symbolic links, fail. Descriptors 0, 1 and 2 are the input
+// of g and the outputs of p and pe. The break is that of Cpu.SetBrk.
+type Syscalls struct {
+	root  string
+	files map[uint32]*os.File
+	next  uint32
+}
+
+// NewSyscalls returns the standard syscalls with files below root,
+// or without any files if root is empty. Install them with
+// c.SetSyscallHandler(s.Handle).
+func NewSyscalls(root string) (*Syscalls, error) {
+	s := &Syscalls{files: make(map[uint32]*os.File), next: 3}
+	if root == "" {
+		return s, nil
+	}
+
+	r, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	s.root, err = filepath.Abs(r)
+	return s, err
+}
+
+// Handle is the SyscallHandler of s.
+func (s *Syscalls) Handle(c *Cpu, num uint32) error {
+	g := c.Guest()
+
+	switch num {
+	case SysOpen:
+		return s.open(g)
+	case SysRead:
+		return s.read(g)
+	case SysWrite:
+		return s.write(g)
+	case SysClose:
+		return s.close(g)
+	case SysBrk:
+		return brk(g)
+	case SysSbrk:
+		return sbrk(g)
+	}
+
+	return fmt.Errorf("unknown syscall %d", num)
+}
+
+// Close closes every file the guest left open.
+func (s *Syscalls) Close() {
+	for k, j := range s.files {
+		j.Close()
+		delete(s.files, k)
+	}
+}
+
+// resolve returns the host path of the guest path name, or false if
+// it is outside the root.
+func (s *Syscalls) resolve(name string) (string, bool) {
+	if s.root == "" {
+		return "", false
+	}
+
+	p := filepath.Join(s.root, filepath.Clean("/"+name))
+
+	// Follow links in the directory, and in the file if it exists. A
+	// link that cannot be followed is refused, as opening it to create
+	// a file would create its target wherever it points.
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return "", false
+	}
+
+	p = filepath.Join(dir, filepath.Base(p))
+	if q, err := filepath.EvalSymlinks(p); err == nil {
+		p = q
+	} else if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return "", false
+	}
+
+	return p, p == s.root || strings.HasPrefix(p, s.root+string(filepath.Separator))
+}
+
+func (s *Syscalls) open(g Guest) error {
+	addr, _ := g.Reg(0)
+	mode, _ := g.Reg(1)
+
+	name, err := path(g, addr)
+	if err != nil {
+		return err
+	}
+
+	p, ok := s.resolve(name)
+	if !ok {
+		return g.SetReg(0, FSError)
+	}
+
+	flag := map[uint32]int{
+		FSReadOnly: os.O_RDONLY,
+		FSCreate:   os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+		FSAppend:   os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	}
+
+	fl, ok := flag[mode]
+	if !ok {
+		return g.SetReg(0, FSError)
+	}
+
+	f, err := os.OpenFile(p, fl, 0644)
+	if err != nil {
+		return g.SetReg(0, FSError)
+	}
+
+	fd := s.next
+	s.next++
+	s.files[fd] = f
+	return g.SetReg(0, fd)
+}
+
+// reader returns the stream read by the descriptor fd.
+func (s *Syscalls) reader(g Guest, fd uint32) (io.Reader, bool) {
+	if fd == 0 {
+		return g.c.in, true
+	}
+
+	f, ok := s.files[fd]
+	return f, ok
+}
+
+// writer returns the stream written by the descriptor fd.
+func (s *Syscalls) writer(g Guest, fd uint32) (io.Writer, bool) {
+	switch fd {
+	case 1:
+		return g.c.out, true
+	case 2:
+		return g.c.errOut, true
+	}
+
+	f, ok := s.files[fd]
+	return f, ok
+}
+
+func (s *Syscalls) read(g Guest) error {
+	fd, _ := g.Reg(0)
+	buf, _ := g.Reg(1)
+	n, _ := g.Reg(2)
+
+	r, ok := s.reader(g, fd)
+	if !ok || uint64(n) > uint64(len(g.c.mem)) {
+		return g.SetReg(0, FSError)
+	}
+
+	b := make([]byte, n)
+	m, err := r.Read(b)
+	if err != nil && err != io.EOF {
+		return g.SetReg(0, FSError)
+	}
+
+	if err := g.Write(buf, b[:m]); err != nil {
+		return err
+	}
+
+	return g.SetReg(0, uint32(m))
+}
+
+func (s *Syscalls) write(g Guest) error {
+	fd, _ := g.Reg(0)
+	buf, _ := g.Reg(1)
+	n, _ := g.Reg(2)
+
+	w, ok := s.writer(g, fd)
+	if !ok || uint64(n) > uint64(len(g.c.mem)) {
+		return g.SetReg(0, FSError)
+	}
+
+	b := make([]byte, n)
+	if err := g.Read(buf, b); err != nil {
+		return err
+	}
+
+	m, err := w.Write(b)
+	if err != nil {
+		return g.SetReg(0, FSError)
+	}
+
+	return g.SetReg(0, uint32(m))
+}
+
+func (s *Syscalls) close(g Guest) error {
+	fd, _ := g.Reg(0)
+
+	f, ok := s.files[fd]
+	if !ok {
+		return g.SetReg(0, FSError)
+	}
+
+	delete(s.files, fd)
+	if f.Close() != nil {
+		return g.SetReg(0, FSError)
+	}
+
+	return g.SetReg(0, 0)
+}
@@ -0,0 +1,81 @@
+package cpu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestResolve checks that guest paths stay below the root, whatever
+// links inside it point to.
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	out := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"inside":   filepath.Join(root, "file"),
+		"outside":  filepath.Join(out, "file"),
+		"dangling": filepath.Join(out, "missing"),
+		"escape":   out,
+		"loop":     filepath.Join(root, "loop"),
+	}
+
+	for k, v := range links {
+		if err := os.Symlink(v, filepath.Join(root, k)); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(out, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSyscalls(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"file", true},
+		{"/file", true},
+		{"new", true},
+		{"dir/new", true},
+		{"inside", true},
+		{"../file", true},
+		{"outside", false},
+		{"dangling", false},
+		{"escape/new", false},
+		{"escape/file", false},
+		{"loop", false},
+		{"missing/new", false},
+	}
+
+	for _, j := range tests {
+		if _, ok := s.resolve(j.name); ok != j.ok {
+			t.Errorf("resolve(%q) = %v, want %v", j.name, ok, j.ok)
+		}
+	}
+}
+
+// TestResolveNoRoot checks that no path resolves without a root.
+func TestResolveNoRoot(t *testing.T) {
+	s, err := NewSyscalls("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.resolve("file"); ok {
+		t.Errorf("resolve without a root succeeded")
+	}
+}
@@ -0,0 +1,225 @@
+package cpu
+
+import "fmt"
+
+// Hypercall numbers of the Threads service.
+const (
+	ThreadSpawn = 0x7400
+	ThreadJoin  = 0x7401
+	ThreadExit  = 0x7402
+	ThreadSelf  = 0x7403
+)
+
+// ThreadError is returned in register 0 by a failing ThreadJoin.
+const ThreadError = 0xffffffff
+
+// Threads schedules green threads on one Cpu. Each thread is a context
+// of registers, pc and status flags; memory is shared. Threads switch
+// cooperatively, in turn, when the running one executes yield, waits
+// in ThreadJoin or ends with ThreadExit. Registers hold the arguments
+// and register 0 the result:
+//
+//	hcall ThreadSpawn  %0 = pc, %1 = argument, %2 = stack pointer -> id
+//	hcall ThreadJoin   %0 = id   -> the value the thread exited with
+//	hcall ThreadExit   %0 = value
+//	hcall ThreadSelf   -> id
+//
+// A new thread starts at pc with the argument in %0 and the stack
+// pointer in %sp, which should lie in the stack region, for example
+// below that of its parent, and inherits the user mode and interrupt
+// enable flags of its parent. Joining an unknown thread or the caller
+// returns ThreadError. The program stops once every thread has exited,
+// or when one executes exit. A Snapshot saves the contexts of every
+// thread.
+type Threads struct {
+	c    *Cpu
+	ctx  []*thread
+	cur  int
+	next bool
+}
+
+type thread struct {
+	reg    [MaxRegs]uint32
+	pc     uint32
+	status uint32
+	done   bool
+	value  uint32
+	wait   int // id of the thread joined, or -1
+}
+
+// NewThreads returns a scheduler whose only thread is the program.
+func NewThreads() *Threads {
+	return &Threads{ctx: []*thread{{wait: -1}}}
+}
+
+// Register installs the hypercalls of t on c and makes t schedule its
+// threads. The code c runs becomes thread 0.
+func (t *Threads) Register(c *Cpu) {
+	t.c = c
+	c.thr = t
+	c.RegisterHypercall(ThreadSpawn, t.spawn)
+	c.RegisterHypercall(ThreadJoin, t.join)
+	c.RegisterHypercall(ThreadExit, t.exit)
+	c.RegisterHypercall(ThreadSelf, t.self)
+}
+
+// Spawn adds a thread starting at pc with arg in %0 and sp in %sp, to
+// run once the running thread gives way, and returns its id. The
+// thread runs in the mode of the running one.
+func (t *Threads) Spawn(pc, arg, sp uint32) int {
+	j := &thread{pc: pc, wait: -1}
+	j.reg[0] = arg
+	j.reg[RegSp] = sp
+
+	if t.c != nil {
+		j.status = t.c.status & (FlagI | FlagU)
+	}
+
+	t.ctx = append(t.ctx, j)
+	return len(t.ctx) - 1
+}
+
+// Current returns the id of the running thread.
+func (t *Threads) Current() int {
+	return t.cur
+}
+
+// Join runs c until thread id has exited and returns the value it
+// exited with. It fails if the program faults or stops first.
+func (t *Threads) Join(id int) (uint32, error) {
+	if id < 0 || id >= len(t.ctx) {
+		return 0, fmt.Errorf("no thread %d", id)
+	}
+
+	for !t.ctx[id].done {
+		if !t.c.State() {
+			return 0, fmt.Errorf("stopped before thread %d exited", id)
+		}
+
+		if err := t.c.Step(); err != nil {
+			return 0, err
+		}
+	}
+
+	return t.ctx[id].value, nil
+}
+
+// schedule switches to the next thread able to run, in turn, if the
+// running one has yielded, is waiting or has exited.
+func (t *Threads) schedule() {
+	c := t.c
+	if !c.yield && !t.next {
+		return
+	}
+
+	t.next = false
+
+	for i := 1; i <= len(t.ctx); i++ {
+		n := (t.cur + i) % len(t.ctx)
+		if j := t.ctx[n]; j.done || j.wait >= 0 {
+			continue
+		}
+
+		if n == t.cur {
+			return
+		}
+
+		cur := t.ctx[t.cur]
+		cur.reg = c.reg
+		cur.pc = c.pc
+		cur.status = c.status
+
+		j := t.ctx[n]
+		c.reg = j.reg
+		c.status = j.status
+		c.seek(j.pc)
+		t.cur = n
+		return
+	}
+
+	for _, j := range t.ctx {
+		if !j.done {
+			c.err = fmt.Errorf("deadlock: every thread is waiting")
+			return
+		}
+	}
+
+	c.exit(0)
+}
+
+// spawn handles ThreadSpawn.
+func (t *Threads) spawn(g Guest) error {
+	pc, _ := g.Reg(0)
+	arg, _ := g.Reg(1)
+	sp, _ := g.Reg(2)
+
+	return g.SetReg(0, uint32(t.Spawn(pc, arg, sp)))
+}
+
+// join handles ThreadJoin.
+func (t *Threads) join(g Guest) error {
+	r, _ := g.Reg(0)
+
+	id := int(r)
+	if r >= uint32(len(t.ctx)) || id == t.cur {
+		return g.SetReg(0, ThreadError)
+	}
+
+	if j := t.ctx[id]; j.done {
+		return g.SetReg(0, j.value)
+	}
+
+	t.ctx[t.cur].wait = id
+	t.next = true
+	return nil
+}
+
+// exit handles ThreadExit.
+func (t *Threads) exit(g Guest) error {
+	cur := t.ctx[t.cur]
+	cur.value, _ = g.Reg(0)
+	cur.done = true
+
+	for _, j := range t.ctx {
+		if j.wait == t.cur {
+			j.wait = -1
+			j.reg[0] = cur.value
+		}
+	}
+
+	t.next = true
+	return nil
+}
+
+// self handles ThreadSelf.
+func (t *Threads) self(g Guest) error {
+	return g.SetReg(0, uint32(t.cur))
+}
+
+// threadState is the state of a Threads saved in a Snapshot.
+type threadState struct {
+	ctx  []thread
+	cur  int
+	next bool
+}
+
+// save returns a copy of the state of t.
+func (t *Threads) save() *threadState {
+	s := &threadState{cur: t.cur, next: t.next}
+	for _, j := range t.ctx {
+		s.ctx = append(s.ctx, *j)
+	}
+
+	return s
+}
+
+// load returns t to the state s.
+func (t *Threads) load(s *threadState) {
+	t.ctx = t.ctx[:0]
+	for _, j := range s.ctx {
+		j := j
+		t.ctx = append(t.ctx, &j)
+	}
+
+	t.cur, t.next = s.cur, s.next
+}
@@ -0,0 +1,120 @@
+package cpu
+
+import "time"
+
+// TimerBase and TimerIRQ are where hypo maps its Timer and the
+// interrupt line it raises.
+const (
+	TimerBase = 0xf0000100
+	TimerIRQ  = 0
+)
+
+// Timer registers, as offsets from the base of a Timer.
+const (
+	TimerPeriod  = 0x0 // ticks between expiries
+	TimerControl = 0x4 // TimerEnable, TimerMillis and TimerOneShot
+	TimerStatus  = 0x8 // 1 once expired; write 1 to clear
+	TimerCount   = 0xc // ticks left until the next expiry
+)
+
+// Bits of the timer control register.
+const (
+	TimerEnable  = 1 << iota // count down
+	TimerMillis              // count milliseconds instead of instructions
+	TimerOneShot             // stop after the first expiry
+)
+
+// TimerSize is the size of the registers of a Timer.
+const TimerSize = 0x10
+
+// Timer is a programmable timer Device. Once enabled it counts down
+// the period, in executed instructions or in wall-clock milliseconds,
+// then sets its status and raises its interrupt line, and starts
+// again unless it is one-shot. Enabling it restarts the count.
+type Timer struct {
+	irq     int
+	period  uint32
+	control uint32
+	status  uint32
+	count   uint32
+	start   time.Time
+}
+
+// NewTimer returns a stopped Timer raising the interrupt line irq.
+func NewTimer(irq int) *Timer {
+	return &Timer{irq: irq}
+}
+
+func (t *Timer) Read32(addr uint32) (uint32, error) {
+	switch addr {
+	case TimerPeriod:
+		return t.period, nil
+	case TimerControl:
+		return t.control, nil
+	case TimerStatus:
+		return t.status, nil
+	case TimerCount:
+		return t.left(), nil
+	}
+
+	return 0, nil
+}
+
+func (t *Timer) Write32(addr, val uint32) error {
+	switch addr {
+	case TimerPeriod:
+		t.period = val
+	case TimerControl:
+		t.control = val
+		t.restart()
+	case TimerStatus:
+		t.status &^= val
+	}
+
+	return nil
+}
+
+// Tick counts down one instruction, or checks the clock.
+func (t *Timer) Tick(g Guest) {
+	if t.control&TimerEnable == 0 {
+		return
+	}
+
+	if t.control&TimerMillis == 0 && t.count > 0 {
+		t.count--
+	}
+
+	if t.left() > 0 {
+		return
+	}
+
+	t.status |= 1
+	g.Raise(t.irq)
+
+	if t.control&TimerOneShot != 0 {
+		t.control &^= TimerEnable
+		return
+	}
+
+	t.restart()
+}
+
+// restart starts counting the period again.
+func (t *Timer) restart() {
+	t.count = t.period
+	t.start = time.Now()
+}
+
+// left returns the ticks left until the timer expires.
+func (t *Timer) left() uint32 {
+	if t.control&TimerMillis == 0 {
+		return t.count
+	}
+
+	ms := uint32(time.Since(t.start) / time.Millisecond)
+	if ms >= t.period {
+		return 0
+	}
+
+	return t.period - ms
+}
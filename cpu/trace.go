@@ -0,0 +1,168 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rtcall/hypo/asm"
+)
+
+// Event is a single executed instruction as seen by the tracer. Reg
+// holds the register file after the instruction completed.
+type Event struct {
+	Pc  uint32
+	Op  byte
+	Reg [MaxRegs]uint32
+}
+
+// Range is the half-open address range [Lo, Hi).
+type Range struct {
+	Lo, Hi uint32
+}
+
+// Contains reports whether addr is in r.
+func (r Range) Contains(addr uint32) bool {
+	return addr >= r.Lo && addr < r.Hi
+}
+
+// Overlaps reports whether r and s share an address.
+func (r Range) Overlaps(s Range) bool {
+	return r.Lo < s.Hi && s.Lo < r.Hi
+}
+
+// Filter selects the instructions recorded by the tracer. Every set
+// field must match; the zero Filter matches everything.
+type Filter struct {
+	Pc   *Range // instruction address
+	Mem  *Range // memory accessed by the instruction
+	Regs uint32 // bit set of registers, one of which must be written
+	Ops  []byte // opcodes
+}
+
+type tracer struct {
+	filt  Filter
+	ring  []Event
+	next  int
+	full  bool
+	every uint64
+	count uint64
+	w     io.Writer
+	log   io.Writer
+}
+
+// SetRing keeps the last n executed instructions in a ring buffer,
+// which is written out by WriteTrace. n <= 0 disables the buffer.
+func (c *Cpu) SetRing(n int) {
+	c.tr.ring = nil
+	c.tr.next = 0
+	c.tr.full = false
+
+	if n > 0 {
+		c.tr.ring = make([]Event, n)
+	}
+}
+
+// SetSample writes every nth executed instruction to w. n <= 0
+// disables sampling.
+func (c *Cpu) SetSample(n int, w io.Writer) {
+	c.tr.every = 0
+	c.tr.w = nil
+
+	if n > 0 && w != nil {
+		c.tr.every = uint64(n)
+		c.tr.w = w
+	}
+}
+
+// SetFilter restricts the ring buffer and sampled trace to the
+// instructions matching f.
+func (c *Cpu) SetFilter(f Filter) {
+	c.tr.filt = f
+}
+
+func (c *Cpu) match(pc uint32, op byte) bool {
+	f := &c.tr.filt
+
+	if f.Pc != nil && !f.Pc.Contains(pc) {
+		return false
+	}
+
+	if f.Regs != 0 && f.Regs&c.wrote == 0 {
+		return false
+	}
+
+	if f.Mem != nil {
+		ok := false
+		for _, j := range c.acc {
+			ok = ok || f.Mem.Overlaps(j)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	if f.Ops != nil {
+		for _, j := range f.Ops {
+			if j == op {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// access notes that the current instruction touched n bytes at addr.
+func (c *Cpu) access(addr, n uint32) {
+	c.acc = append(c.acc, Range{addr, addr + n})
+}
+
+// Ring returns the contents of the ring buffer, oldest first.
+func (c *Cpu) Ring() []Event {
+	if !c.tr.full {
+		return append([]Event(nil), c.tr.ring[:c.tr.next]...)
+	}
+
+	return append(append([]Event(nil), c.tr.ring[c.tr.next:]...), c.tr.ring[:c.tr.next]...)
+}
+
+func (c *Cpu) record(pc uint32, op byte) {
+	if c.tr.ring == nil && c.tr.every == 0 || !c.match(pc, op) {
+		return
+	}
+
+	e := Event{pc, op, c.reg}
+
+	if c.tr.ring != nil {
+		c.tr.ring[c.tr.next] = e
+		c.tr.next++
+
+		if c.tr.next == len(c.tr.ring) {
+			c.tr.next = 0
+			c.tr.full = true
+		}
+	}
+
+	if c.tr.every != 0 {
+		if c.tr.count%c.tr.every == 0 {
+			c.writeEvent(c.tr.w, e)
+		}
+		c.tr.count++
+	}
+}
+
+func (c *Cpu) writeEvent(w io.Writer, e Event) {
+	fmt.Fprintf(w, "%08x: %02x %-5s", e.Pc, e.Op, asm.Mnemonic(e.Op))
+	for _, j := range e.Reg[:c.nreg] {
+		fmt.Fprintf(w, " %08x", j)
+	}
+
+	if l, ok := c.Source(e.Pc); ok {
+		fmt.Fprintf(w, " %s:%d", l.File, l.Line)
+	}
+
+	fmt.Fprintln(w, "")
+}
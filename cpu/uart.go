@@ -0,0 +1,113 @@
+package cpu
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// UARTBase and UARTIRQ are where hypo maps a UART and the interrupt
+// line it raises.
+const (
+	UARTBase = 0xf0000200
+	UARTIRQ  = 1
+)
+
+// UART registers, as offsets from the base of a UART.
+const (
+	UARTData    = 0x0 // read the next byte received, or EOF; write a byte to send
+	UARTStatus  = 0x4 // UARTReady and UARTClosed
+	UARTControl = 0x8 // UARTInterrupt
+)
+
+// Bits of the UART status register.
+const (
+	UARTReady  = 1 << iota // a received byte is waiting
+	UARTClosed             // the peer has gone and every byte was read
+)
+
+// UARTInterrupt in the control register raises the interrupt line
+// while a received byte is waiting.
+const UARTInterrupt = 1
+
+// UARTSize is the size of the registers of a UART.
+const UARTSize = 0xc
+
+// UART is a serial port Device bridged to a byte stream, such as a
+// TCP connection or a pseudo-terminal. Bytes arrive in the background
+// and wait in a buffer until the guest reads them.
+type UART struct {
+	w       io.Writer
+	rx      chan byte
+	closed  atomic.Bool
+	irq     int
+	control uint32
+}
+
+// NewUART returns a UART sending to and receiving from rw and raising
+// the interrupt line irq.
+func NewUART(rw io.ReadWriter, irq int) *UART {
+	u := &UART{w: rw, rx: make(chan byte, 4096), irq: irq}
+	go u.receive(rw)
+	return u
+}
+
+// receive copies the bytes read from r to u.rx until r fails.
+func (u *UART) receive(r io.Reader) {
+	var b [256]byte
+
+	for {
+		n, err := r.Read(b[:])
+		for _, j := range b[:n] {
+			u.rx <- j
+		}
+
+		if err != nil {
+			u.closed.Store(true)
+			return
+		}
+	}
+}
+
+func (u *UART) Read32(addr uint32) (uint32, error) {
+	switch addr {
+	case UARTData:
+		select {
+		case b := <-u.rx:
+			return uint32(b), nil
+		default:
+			return EOF, nil
+		}
+	case UARTStatus:
+		var s uint32
+		if len(u.rx) > 0 {
+			s |= UARTReady
+		} else if u.closed.Load() {
+			s |= UARTClosed
+		}
+
+		return s, nil
+	case UARTControl:
+		return u.control, nil
+	}
+
+	return 0, nil
+}
+
+func (u *UART) Write32(addr, val uint32) error {
+	switch addr {
+	case UARTData:
+		_, err := u.w.Write([]byte{byte(val)})
+		return err
+	case UARTControl:
+		u.control = val
+	}
+
+	return nil
+}
+
+// Tick raises the interrupt line while a byte is waiting, if enabled.
+func (u *UART) Tick(g Guest) {
+	if u.control&UARTInterrupt != 0 && len(u.rx) > 0 {
+		g.Raise(u.irq)
+	}
+}
@@ -0,0 +1,54 @@
+package cpu
+
+import "fmt"
+
+// SetVonNeumann copies the code into memory at its load address and
+// makes c fetch instructions from memory from then on, so that code
+// and data share one address space: programs can read and modify
+// their own code, and execution may continue anywhere in memory. The
+// stack moves above the code. It fails if the code does not fit in
+// memory or overlaps a data or bss section or the input block, as
+// sections do unless assembled with asm.Options.VonNeumann, and must be
+// called before the first Step.
+func (c *Cpu) SetVonNeumann() error {
+	code := Range{c.base, c.base + uint32(len(c.img.Code))}
+	if code.Hi < code.Lo || code.Hi > uint32(len(c.mem)) {
+		return fmt.Errorf("code at %08x does not fit in memory", c.base)
+	}
+
+	for _, j := range c.img.Sections {
+		if code.Overlaps(Range{j.Addr, j.Addr + j.Size}) {
+			return fmt.Errorf("code at %08x overlaps section at %08x", code.Lo, j.Addr)
+		}
+	}
+
+	if code.Overlaps(c.input) {
+		return fmt.Errorf("code at %08x overlaps input block at %08x", code.Lo, c.input.Lo)
+	}
+
+	copy(c.mem[code.Lo:], c.img.Code)
+	c.vn = true
+	c.setStack(c.input.Lo)
+	return nil
+}
+
+// text returns the bytes instructions are fetched from and the
+// address of the first: the loaded code, or all of memory in von
+// Neumann mode.
+func (c *Cpu) text() ([]byte, uint32) {
+	if c.vn {
+		return c.mem[:], 0
+	}
+
+	return c.img.Code, c.base
+}
+
+// loaded returns the loaded code as it is now, which in von Neumann mode
+// the program may have changed.
+func (c *Cpu) loaded() []byte {
+	if c.vn {
+		return c.mem[c.base : c.base+uint32(len(c.img.Code))]
+	}
+
+	return c.img.Code
+}
@@ -0,0 +1,78 @@
+package cpu
+
+import "fmt"
+
+// Accesses watched by Watch.
+const (
+	WatchRead = 1 << iota
+	WatchWrite
+)
+
+// Hit is an access that triggered a watchpoint.
+type Hit struct {
+	Pc    uint32 // address of the instruction making the access
+	Addr  uint32 // address accessed
+	Size  uint32 // bytes accessed
+	Write bool   // whether it was a store
+	Value uint32 // the value loaded or stored
+}
+
+func (h Hit) String() string {
+	what := "read"
+	if h.Write {
+		what = "write"
+	}
+
+	return fmt.Sprintf("pc %08x: %d-byte %s at %08x: %08x", h.Pc, h.Size, what, h.Addr, h.Value)
+}
+
+// watchpoint is a range of memory watched by Watch.
+type watchpoint struct {
+	Range
+	kind int
+}
+
+// Watch sets a watchpoint on the memory in r for the accesses in kind,
+// WatchRead, WatchWrite or both. Loads and stores of instructions,
+// and the reads and writes of hypercalls and devices, touching r are
+// reported by Watched after the step making them; the script run
+// command and hypo -watch pause there. Addresses are physical, and
+// the MMIO window cannot be watched.
+func (c *Cpu) Watch(r Range, kind int) {
+	c.watch = append(c.watch, watchpoint{r, kind})
+}
+
+// ClearWatches removes every watchpoint.
+func (c *Cpu) ClearWatches() {
+	c.watch = nil
+}
+
+// Watched returns the first access of the last step that triggered a
+// watchpoint, and whether there was one.
+func (c *Cpu) Watched() (Hit, bool) {
+	if c.hit == nil {
+		return Hit{}, false
+	}
+
+	return *c.hit, true
+}
+
+// watched records the access of n bytes at addr with value v if it
+// triggers a watchpoint and none has in this step.
+func (c *Cpu) watched(addr, n, v uint32, write bool) {
+	if c.hit != nil {
+		return
+	}
+
+	kind := WatchRead
+	if write {
+		kind = WatchWrite
+	}
+
+	for _, j := range c.watch {
+		if j.kind&kind != 0 && j.Overlaps(Range{addr, addr + n}) {
+			c.hit = &Hit{c.last, addr, n, write, v}
+			return
+		}
+	}
+}
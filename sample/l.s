@@ -20,4 +20,4 @@ func:
 func_loop:
     addi %1 $2 %1
     blt %1 %0 func_loop
-    jr %3
+    ret